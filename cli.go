@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"claude-relay/internal/autodetect"
+	"claude-relay/internal/config"
+	"claude-relay/internal/models"
+	"claude-relay/internal/relay"
+	"claude-relay/internal/users"
+)
+
+// runModelsCommand implements "claude-relay models [-refresh] [-remap]",
+// which lists the relay catalog and what changed since the last check, and
+// "claude-relay models history".
+func runModelsCommand(args []string) error {
+	fs := flag.NewFlagSet("models", flag.ExitOnError)
+	refresh := fs.Bool("refresh", false, "ignore the cache TTL and revalidate with the relay")
+	remap := fs.Bool("remap", false, "replace missing or deprecated models with suggestions and save")
+	fs.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if cfg.BaseURL == "" || cfg.APIKey == "" {
+		return fmt.Errorf("base_url and api_key must be configured first")
+	}
+
+	if fs.Arg(0) == "history" {
+		history, err := relay.CatalogHistory(cfg)
+		if err != nil {
+			return err
+		}
+		if len(history) == 0 {
+			fmt.Println("no catalog changes recorded")
+		}
+		for _, c := range history {
+			printCatalogChange(c.CheckedAt, c.Added, c.Removed)
+		}
+		return nil
+	}
+
+	res, err := relay.Catalog(cfg, *refresh)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d models (%s, fetched %s)\n", len(res.Models), res.Source, res.FetchedAt)
+	if res.Error != "" {
+		fmt.Printf("offline, showing cached catalog: %s\n", res.Error)
+	}
+	for _, m := range res.Models {
+		fmt.Println("  " + m.ID)
+	}
+	if res.Changes != nil {
+		fmt.Println()
+		printCatalogChange(res.Changes.CheckedAt, res.Changes.Added, res.Changes.Removed)
+	}
+
+	issues := autodetect.Check(cfg, res.Models)
+	if len(issues) == 0 {
+		return nil
+	}
+	fmt.Println()
+	for _, is := range issues {
+		line := fmt.Sprintf("%s: %s is %s", is.Field, is.Current, is.Problem)
+		if is.Suggested != "" {
+			line += " -> " + is.Suggested
+		}
+		fmt.Println(line)
+	}
+	if !*remap {
+		fmt.Println("run with -remap to apply the suggestions")
+		return nil
+	}
+	if !autodetect.Apply(cfg, issues) {
+		fmt.Println("some entries have no suggestion and were left unchanged")
+	}
+	if err := config.Save(cfg); err != nil {
+		return err
+	}
+	fmt.Println("mappings updated")
+	return nil
+}
+
+// runAccountCommand implements "claude-relay account", which prints the
+// key's remaining quota, expiry and per-model usage.
+func runAccountCommand() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if cfg.BaseURL == "" || cfg.APIKey == "" {
+		return fmt.Errorf("base_url and api_key must be configured first")
+	}
+	acct, err := relay.Account(cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("provider: %s\n", acct.Provider)
+	if acct.Name != "" {
+		fmt.Printf("key:      %s\n", acct.Name)
+	}
+	if acct.Unlimited {
+		fmt.Printf("quota:    unlimited ($%.2f used)\n", acct.Used)
+	} else {
+		fmt.Printf("quota:    $%.2f left of $%.2f ($%.2f used)\n", acct.Remaining, acct.Granted, acct.Used)
+	}
+	if acct.ExpiresAt == "" {
+		fmt.Println("expires:  never")
+	} else {
+		fmt.Printf("expires:  %s\n", acct.ExpiresAt)
+	}
+	if len(acct.ModelUsage) > 0 {
+		fmt.Println()
+		for _, u := range acct.ModelUsage {
+			fmt.Printf("  %-40s %5d req  $%8.4f  %d in / %d out\n", u.Model, u.Requests, u.Used, u.PromptTokens, u.CompletionTokens)
+		}
+	}
+	if p := relay.AccountProblem(acct); p != "" {
+		fmt.Println()
+		fmt.Println("warning: " + p)
+	}
+	return nil
+}
+
+// runUserCommand implements "claude-relay user list|add|passwd|remove",
+// which edits the users file of a multi-user server.
+func runUserCommand(args []string) error {
+	usage := fmt.Errorf("usage: claude-relay user list | add NAME -role ROLE [-targets a,b*] [-no-password] | passwd NAME | remove NAME [-file users.json]")
+	if len(args) == 0 {
+		return usage
+	}
+	action, name := args[0], ""
+	rest := args[1:]
+	if action != "list" {
+		if len(rest) == 0 {
+			return usage
+		}
+		name, rest = rest[0], rest[1:]
+	}
+	fs := flag.NewFlagSet("user", flag.ExitOnError)
+	file := fs.String("file", config.UsersPath(), "users file")
+	role := fs.String("role", "", "viewer, operator or admin")
+	targets := fs.String("targets", "", "comma-separated target names or patterns the user may access (default all)")
+	noPassword := fs.Bool("no-password", false, "check the password against the users file's htpasswd instead")
+	fs.Parse(rest)
+
+	f, err := users.ReadFile(*file)
+	if os.IsNotExist(err) && action == "add" {
+		f, err = &models.UsersFile{Users: []models.User{}}, nil
+	}
+	if err != nil {
+		return err
+	}
+	idx := slices.IndexFunc(f.Users, func(u models.User) bool { return u.Name == name })
+
+	switch action {
+	case "list":
+		for _, u := range f.Users {
+			scope := "all targets"
+			if len(u.Targets) > 0 {
+				scope = strings.Join(u.Targets, ", ")
+			}
+			fmt.Printf("%-20s %-9s %s\n", u.Name, u.Role, scope)
+		}
+		if f.Htpasswd != "" {
+			fmt.Printf("htpasswd: %s (default role: %s)\n", f.Htpasswd, cmp.Or(string(f.DefaultRole), "none"))
+		}
+		return nil
+	case "add":
+		if idx >= 0 {
+			return fmt.Errorf("user %q already exists", name)
+		}
+		u := models.User{Name: name, Role: models.Role(*role)}
+		if *targets != "" {
+			u.Targets = strings.Split(*targets, ",")
+		}
+		if err := users.ValidateUser(u); err != nil {
+			return err
+		}
+		if !*noPassword {
+			if u.PasswordHash, err = promptPasswordHash(); err != nil {
+				return err
+			}
+		}
+		f.Users = append(f.Users, u)
+	case "passwd":
+		if idx < 0 {
+			return fmt.Errorf("user %q not found", name)
+		}
+		if f.Users[idx].PasswordHash, err = promptPasswordHash(); err != nil {
+			return err
+		}
+	case "remove":
+		if idx < 0 {
+			return fmt.Errorf("user %q not found", name)
+		}
+		f.Users = slices.Delete(f.Users, idx, idx+1)
+	default:
+		return usage
+	}
+	if err := users.WriteFile(*file, f); err != nil {
+		return err
+	}
+	fmt.Printf("saved %s\n", *file)
+	return nil
+}
+
+// promptPasswordHash reads a password from stdin, asking twice without echo
+// when stdin is a terminal, and returns its hash.
+func promptPasswordHash() (string, error) {
+	in := bufio.NewReader(os.Stdin)
+	st, _ := os.Stdin.Stat()
+	tty := st != nil && st.Mode()&os.ModeCharDevice != 0
+	read := func(prompt string) (string, error) {
+		if tty {
+			fmt.Fprint(os.Stderr, prompt)
+			stty := exec.Command("stty", "-echo")
+			stty.Stdin = os.Stdin
+			if stty.Run() == nil {
+				defer func() {
+					restore := exec.Command("stty", "echo")
+					restore.Stdin = os.Stdin
+					restore.Run()
+					fmt.Fprintln(os.Stderr)
+				}()
+			}
+		}
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("read password: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	password, err := read("Password: ")
+	if err != nil {
+		return "", err
+	}
+	if tty {
+		again, err := read("Repeat password: ")
+		if err != nil {
+			return "", err
+		}
+		if again != password {
+			return "", fmt.Errorf("passwords do not match")
+		}
+	}
+	if password == "" {
+		return "", fmt.Errorf("empty password")
+	}
+	return users.HashPassword(password)
+}
+
+func printCatalogChange(at string, added, removed []string) {
+	fmt.Printf("changes at %s:\n", at)
+	for _, id := range added {
+		fmt.Println("  + " + id)
+	}
+	for _, id := range removed {
+		fmt.Println("  - " + id)
+	}
+}
@@ -0,0 +1,222 @@
+package autodetect
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"claude-relay/internal/config"
+	"claude-relay/internal/mapping"
+	"claude-relay/internal/models"
+	"claude-relay/internal/relay"
+)
+
+// Triggers recorded in AutoDetectReport.Trigger.
+const (
+	TriggerDeploy   = "deploy"
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Check compares every ModelMappings entry and tier default with the catalog
+// and suggests a replacement for each one that is missing or deprecated.
+// Tier defaults are checked as they are deployed, after the mapping rules and
+// the fallback model (see mapping.Defaults); with invalid rules they are not
+// checked at all, as preflight reports that.
+func Check(cfg *models.Config, catalog []models.RelayModel) []models.CatalogIssue {
+	byID := make(map[string]models.RelayModel, len(catalog))
+	for _, m := range catalog {
+		byID[m.ID] = m
+	}
+
+	var issues []models.CatalogIssue
+	check := func(field, current, slot string) {
+		if current == "" {
+			return
+		}
+		issue := models.CatalogIssue{Field: field, Current: current}
+		m, ok := byID[current]
+		switch {
+		case !ok:
+			issue.Problem = "missing"
+		case m.Deprecated || relay.IsDeprecated(current):
+			issue.Problem = "deprecated"
+		default:
+			return
+		}
+		if s, ok := relay.SuggestModel(slot, catalog, cfg.SuggestPrefs); ok && s.RelayID != current {
+			issue.Suggested = s.RelayID
+		}
+		issues = append(issues, issue)
+	}
+
+	for _, mm := range cfg.ModelMappings {
+		slot := mm.VSCodeID
+		if relay.ParseModelID(slot).Tier == "" {
+			slot = mm.RelayID
+		}
+		check("model_mappings["+mm.VSCodeID+"]", mm.RelayID, slot)
+	}
+	// Tier defaults keep their tier but not necessarily their version.
+	if opus, sonnet, haiku, err := mapping.Defaults(cfg); err == nil {
+		check("default_opus_model", opus, "claude-opus")
+		check("default_sonnet_model", sonnet, "claude-sonnet")
+		check("default_haiku_model", haiku, "claude-haiku")
+	}
+	return issues
+}
+
+// Apply writes the suggested replacements into cfg and reports whether every
+// issue could be fixed.
+func Apply(cfg *models.Config, issues []models.CatalogIssue) bool {
+	fixed := true
+	for _, is := range issues {
+		if is.Suggested == "" {
+			fixed = false
+			continue
+		}
+		switch is.Field {
+		case "default_opus_model":
+			cfg.DefaultOpus = is.Suggested
+		case "default_sonnet_model":
+			cfg.DefaultSonnet = is.Suggested
+		case "default_haiku_model":
+			cfg.DefaultHaiku = is.Suggested
+		default:
+			id := strings.TrimSuffix(strings.TrimPrefix(is.Field, "model_mappings["), "]")
+			for i := range cfg.ModelMappings {
+				if cfg.ModelMappings[i].VSCodeID == id {
+					cfg.ModelMappings[i].RelayID = is.Suggested
+				}
+			}
+		}
+	}
+	return fixed
+}
+
+// Run refreshes the catalog and checks cfg against it. With the "apply" policy
+// the suggestions are written into cfg (the caller saves it when
+// report.Applied is set); issues that cannot be fixed, or any issue under the
+// "block" policy, set report.Blocked. A catalog that cannot be fetched is
+// reported but never blocks, and stale cached catalogs are not checked.
+func Run(cfg *models.Config, trigger string) *models.AutoDetectReport {
+	report := &models.AutoDetectReport{
+		CheckedAt: time.Now().UTC().Format(time.RFC3339),
+		Trigger:   trigger,
+		Policy:    policy(cfg),
+		Issues:    []models.CatalogIssue{},
+	}
+	defer record(report)
+
+	res, err := relay.Catalog(cfg, true)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	if res.Source == relay.SourceOffline {
+		report.Error = "relay unreachable, not checked: " + res.Error
+		return report
+	}
+	catalog := res.Models
+	report.Models = len(catalog)
+	if issues := Check(cfg, catalog); len(issues) > 0 {
+		report.Issues = issues
+		if report.Policy == models.AutoDetectApply {
+			report.Applied = true
+			report.Blocked = !Apply(cfg, issues)
+		} else {
+			report.Blocked = true
+		}
+	}
+	return report
+}
+
+// Summary describes the unresolved issues of a blocked report in one line.
+func Summary(report *models.AutoDetectReport) string {
+	var parts []string
+	for _, is := range report.Issues {
+		if report.Applied && is.Suggested != "" {
+			continue // already fixed
+		}
+		s := fmt.Sprintf("%s %s is %s", is.Field, is.Current, is.Problem)
+		if is.Suggested != "" {
+			s += " (suggested: " + is.Suggested + ")"
+		}
+		parts = append(parts, s)
+	}
+	return "model check failed: " + strings.Join(parts, "; ")
+}
+
+func policy(cfg *models.Config) string {
+	if cfg.AutoDetectPolicy == models.AutoDetectApply {
+		return models.AutoDetectApply
+	}
+	return models.AutoDetectBlock
+}
+
+// --- Last report and schedule ---
+
+var (
+	mu      sync.Mutex
+	last    *models.AutoDetectReport
+	lastRun time.Time
+)
+
+func record(report *models.AutoDetectReport) {
+	mu.Lock()
+	defer mu.Unlock()
+	last = report
+	lastRun = time.Now()
+}
+
+// Last returns the most recent report, or nil if no check has run yet.
+func Last() *models.AutoDetectReport {
+	mu.Lock()
+	defer mu.Unlock()
+	return last
+}
+
+// schedulerTick is how often the scheduler re-reads the config, so interval
+// changes take effect without a restart.
+const schedulerTick = time.Minute
+
+// StartScheduler runs the background check according to the saved config's
+// AutoDetect and AutoDetectInterval settings. Applied suggestions are saved.
+func StartScheduler() {
+	go func() {
+		for range time.Tick(schedulerTick) {
+			runScheduled()
+		}
+	}()
+}
+
+func runScheduled() {
+	cfg, err := config.Load()
+	if err != nil || !cfg.AutoDetect || cfg.AutoDetectInterval == "" || cfg.BaseURL == "" || cfg.APIKey == "" {
+		return
+	}
+	interval, err := time.ParseDuration(cfg.AutoDetectInterval)
+	if err != nil || interval <= 0 {
+		return
+	}
+	mu.Lock()
+	due := time.Since(lastRun) >= interval
+	mu.Unlock()
+	if !due {
+		return
+	}
+
+	report := Run(cfg, TriggerSchedule)
+	switch {
+	case report.Error != "":
+		log.Printf("autodetect: %s", report.Error)
+	case report.Applied:
+		if err := config.Save(cfg); err != nil {
+			log.Printf("autodetect: save config: %v", err)
+		}
+	case report.Blocked:
+		log.Printf("autodetect: %s", Summary(report))
+	}
+}
@@ -0,0 +1,69 @@
+package autodetect
+
+import (
+	"reflect"
+	"testing"
+
+	"claude-relay/internal/models"
+)
+
+func TestCheckUsesDeployedDefaults(t *testing.T) {
+	catalog := []models.RelayModel{
+		{ID: "claude-opus-4-6"},
+		{ID: "claude-sonnet-4-5-20250929"},
+		{ID: "claude-haiku-4-5-20251001"},
+	}
+	cfg := &models.Config{
+		// Written as VS Code IDs, deployed as relay IDs by the rules.
+		DefaultOpus:   "claude-opus-4.6",
+		DefaultSonnet: "claude-sonnet-4.5",
+		MappingRules: []models.MappingRule{
+			{Pattern: "claude-opus-4.6", Kind: models.RuleExact, Target: "claude-opus-4-6"},
+			{Pattern: "claude-sonnet-4.5", Kind: models.RuleExact, Target: "claude-sonnet-4-7"},
+		},
+		// The empty haiku default deploys the fallback.
+		FallbackModel: "claude-3-5-haiku-20241022",
+	}
+	got := Check(cfg, catalog)
+	want := []models.CatalogIssue{
+		{Field: "default_sonnet_model", Current: "claude-sonnet-4-7", Problem: "missing", Suggested: "claude-sonnet-4-5-20250929"},
+		{Field: "default_haiku_model", Current: "claude-3-5-haiku-20241022", Problem: "missing", Suggested: "claude-haiku-4-5-20251001"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Check = %+v\nwant %+v", got, want)
+	}
+
+	if !Apply(cfg, got) {
+		t.Fatal("Apply left issues unfixed")
+	}
+	if got := Check(cfg, catalog); len(got) != 0 {
+		t.Errorf("after Apply: %+v", got)
+	}
+}
+
+func TestCheckMappings(t *testing.T) {
+	catalog := []models.RelayModel{
+		{ID: "claude-opus-4-6"},
+		{ID: "claude-3-7-sonnet-20250219"},
+		{ID: "claude-sonnet-4-5"},
+	}
+	cfg := &models.Config{ModelMappings: []models.ModelMapping{
+		{VSCodeID: "claude-opus-4.6", RelayID: "claude-opus-4-6"},
+		{VSCodeID: "claude-sonnet-4.5", RelayID: "claude-3-7-sonnet-20250219"},
+		{VSCodeID: "my-model", RelayID: "gone-model"},
+	}}
+	got := Check(cfg, catalog)
+	want := []models.CatalogIssue{
+		{Field: "model_mappings[claude-sonnet-4.5]", Current: "claude-3-7-sonnet-20250219", Problem: "deprecated", Suggested: "claude-sonnet-4-5"},
+		{Field: "model_mappings[my-model]", Current: "gone-model", Problem: "missing"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Check = %+v\nwant %+v", got, want)
+	}
+	if Apply(cfg, got) {
+		t.Error("Apply: an issue without a suggestion cannot be fixed")
+	}
+	if cfg.ModelMappings[1].RelayID != "claude-sonnet-4-5" {
+		t.Errorf("mapping not applied: %+v", cfg.ModelMappings[1])
+	}
+}
@@ -0,0 +1,126 @@
+// Package codespaces lists and starts GitHub codespaces through the gh CLI,
+// the same tool codespace targets already use to connect. Any gh on PATH
+// works, which lets a fake one stand in for GitHub.
+package codespaces
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"claude-relay/internal/models"
+)
+
+const (
+	// ghTimeout bounds a single gh call.
+	ghTimeout = time.Minute
+	// startTimeout bounds how long Start waits for a codespace to come up.
+	startTimeout = 10 * time.Minute
+)
+
+// pollInterval is how often Start checks the state while waiting.
+var pollInterval = 3 * time.Second
+
+// listFields are the gh codespace list --json fields List reads.
+const listFields = "name,displayName,repository,state,gitStatus,lastUsedAt"
+
+// List returns the user's codespaces.
+func List(ctx context.Context) ([]models.Codespace, error) {
+	out, err := gh(ctx, "codespace", "list", "--json", listFields, "--limit", "1000")
+	if err != nil {
+		return nil, err
+	}
+	var raw []struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+		Repository  string `json:"repository"`
+		State       string `json:"state"`
+		GitStatus   struct {
+			Ref string `json:"ref"`
+		} `json:"gitStatus"`
+		LastUsedAt string `json:"lastUsedAt"`
+	}
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return nil, fmt.Errorf("parse gh codespace list: %w", err)
+	}
+	list := make([]models.Codespace, 0, len(raw))
+	for _, c := range raw {
+		list = append(list, models.Codespace{
+			Name:        c.Name,
+			DisplayName: c.DisplayName,
+			Repository:  c.Repository,
+			Branch:      c.GitStatus.Ref,
+			State:       c.State,
+			LastUsedAt:  c.LastUsedAt,
+		})
+	}
+	return list, nil
+}
+
+// State returns the state of the named codespace, e.g. Available or
+// Shutdown.
+func State(ctx context.Context, name string) (string, error) {
+	out, err := gh(ctx, "api", "/user/codespaces/"+url.PathEscape(name), "--jq", ".state")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Start starts the named codespace unless it is running and waits until it
+// is Available, reporting progress to logf. A codespace still shutting down
+// is started once it reaches Shutdown, as is one that stops again while
+// Start waits.
+func Start(ctx context.Context, name string, logf func(string, ...any)) error {
+	deadline := time.Now().Add(startTimeout)
+	for waited := false; ; waited = true {
+		state, err := State(ctx, name)
+		if err != nil {
+			return err
+		}
+		switch state {
+		case models.CodespaceAvailable:
+			if waited {
+				logf("codespace %s is available", name)
+			}
+			return nil
+		case models.CodespaceShutdown:
+			logf("starting codespace %s", name)
+			if _, err := gh(ctx, "api", "--method", "POST", "/user/codespaces/"+url.PathEscape(name)+"/start"); err != nil {
+				return fmt.Errorf("start codespace %s: %w", name, err)
+			}
+		case "Failed", "Unavailable", "Deleted":
+			return fmt.Errorf("codespace %s is %s", name, state)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("codespace %s still %s after %s", name, state, startTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// gh runs the gh CLI and returns its stdout.
+func gh(ctx context.Context, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, ghTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "gh", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("gh %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("gh %s: %w", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
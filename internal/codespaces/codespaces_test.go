@@ -0,0 +1,125 @@
+package codespaces
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-relay/internal/models"
+	"claude-relay/internal/testutil"
+)
+
+// fakeGH puts a gh on PATH that serves list.json for "codespace list" and
+// pops one line of states per state lookup, repeating the last. It returns
+// the directory holding those files.
+func fakeGH(t *testing.T) string {
+	t.Helper()
+	dir := testutil.FakeCommand(t, "gh", `if [ -f "$dir/fail" ]; then cat "$dir/fail" >&2; exit 1; fi
+case "$1 $2" in
+"codespace list") cat "$dir/list.json" ;;
+"api --method") ;;
+api*)
+	head -n 1 "$dir/states"
+	if [ "$(wc -l < "$dir/states")" -gt 1 ]; then
+		tail -n +2 "$dir/states" > "$dir/states.tmp" && mv "$dir/states.tmp" "$dir/states"
+	fi ;;
+*) echo "unexpected gh $*" >&2; exit 1 ;;
+esac
+`)
+	old := pollInterval
+	pollInterval = time.Millisecond
+	t.Cleanup(func() { pollInterval = old })
+	return dir
+}
+
+func TestList(t *testing.T) {
+	dir := fakeGH(t)
+	testutil.WriteFile(t, filepath.Join(dir, "list.json"), `[
+		{"name":"fluffy-space-abc","displayName":"fluffy space","repository":"acme/app","state":"Available","gitStatus":{"ref":"main","hasUncommittedChanges":true},"lastUsedAt":"2026-10-01T12:00:00Z"},
+		{"name":"other-xyz","repository":"acme/lib","state":"Shutdown","gitStatus":{}}
+	]`)
+	got, err := List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.Codespace{
+		{Name: "fluffy-space-abc", DisplayName: "fluffy space", Repository: "acme/app", Branch: "main", State: "Available", LastUsedAt: "2026-10-01T12:00:00Z"},
+		{Name: "other-xyz", Repository: "acme/lib", State: "Shutdown"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List = %+v", got)
+	}
+	if c := testutil.Calls(t, dir); c[0] != "codespace list --json "+listFields+" --limit 1000" {
+		t.Errorf("gh called with %q", c[0])
+	}
+
+	testutil.WriteFile(t, filepath.Join(dir, "list.json"), `{"message":"oops"}`)
+	if _, err := List(context.Background()); err == nil {
+		t.Error("malformed output: want an error")
+	}
+	testutil.WriteFile(t, filepath.Join(dir, "fail"), "To get started with GitHub CLI, please run:  gh auth login")
+	if _, err := List(context.Background()); err == nil || !strings.Contains(err.Error(), "gh auth login") {
+		t.Errorf("gh failure: got %v", err)
+	}
+}
+
+func TestStart(t *testing.T) {
+	const startCall = "api --method POST /user/codespaces/cs-1/start"
+	const stateCall = "api /user/codespaces/cs-1 --jq .state"
+	tests := []struct {
+		name    string
+		states  string
+		wantErr string
+		want    []string
+	}{
+		{"already available", "Available", "",
+			[]string{stateCall}},
+		{"shutdown", "Shutdown\nStarting\nStarting\nAvailable", "",
+			[]string{stateCall, startCall, stateCall, stateCall, stateCall}},
+		// Started only once it has shut down.
+		{"shutting down", "ShuttingDown\nShutdown\nStarting\nAvailable", "",
+			[]string{stateCall, stateCall, startCall, stateCall, stateCall}},
+		// Stopped again while starting: started again.
+		{"stopped while starting", "Shutdown\nStarting\nShutdown\nAvailable", "",
+			[]string{stateCall, startCall, stateCall, stateCall, startCall, stateCall}},
+		{"failed", "Shutdown\nStarting\nFailed", "codespace cs-1 is Failed",
+			[]string{stateCall, startCall, stateCall, stateCall}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := fakeGH(t)
+			testutil.WriteFile(t, filepath.Join(dir, "states"), tt.states+"\n")
+			var logged []string
+			err := Start(context.Background(), "cs-1", func(format string, args ...any) {
+				logged = append(logged, fmt.Sprintf(format, args...))
+			})
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("Start: got %v, want %q", err, tt.wantErr)
+			}
+			if got := testutil.Calls(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gh calls:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			if tt.name == "shutdown" && !reflect.DeepEqual(logged, []string{"starting codespace cs-1", "codespace cs-1 is available"}) {
+				t.Errorf("logged %q", logged)
+			}
+		})
+	}
+}
+
+func TestStartCanceled(t *testing.T) {
+	dir := fakeGH(t)
+	testutil.WriteFile(t, filepath.Join(dir, "states"), "Starting\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := Start(ctx, "cs-1", func(string, ...any) {}); err == nil {
+		t.Error("Start: want an error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Start returned after %v", d)
+	}
+}
@@ -19,6 +19,29 @@ func Init() {
 	configPath = filepath.Join(home, ".claude-relay", "config.json")
 }
 
+// CacheDir is where cached relay data is kept (~/.claude-relay/cache).
+func CacheDir() string {
+	return filepath.Join(filepath.Dir(configPath), "cache")
+}
+
+// UsersPath is the default users file of a multi-user server
+// (~/.claude-relay/users.json).
+func UsersPath() string {
+	return filepath.Join(filepath.Dir(configPath), "users.json")
+}
+
+// LocksDir holds the per-target lock files shared by every claude-relay
+// process on this machine (~/.claude-relay/locks).
+func LocksDir() string {
+	return filepath.Join(filepath.Dir(configPath), "locks")
+}
+
+// TLSDir is where the self-signed server certificate is kept
+// (~/.claude-relay/tls).
+func TLSDir() string {
+	return filepath.Join(filepath.Dir(configPath), "tls")
+}
+
 func Load() (*models.Config, error) {
 	mu.RLock()
 	defer mu.RUnlock()
@@ -66,7 +89,7 @@ func defaultConfig() *models.Config {
 		MCPServers: []models.MCPServer{
 			{Name: "fetch", Enabled: true, Command: "uvx", Args: []string{"mcp-server-fetch"}},
 			{Name: "deepwiki", Enabled: true, Command: "npx", Args: []string{"-y", "mcp-deepwiki@latest"}},
-			{Name: "filesystem", Enabled: true, Command: "npx", Args: []string{"-y", "@modelcontextprotocol/server-filesystem", "/home/shiro"}},
+			{Name: "filesystem", Enabled: true, Command: "npx", Args: []string{"-y", "@modelcontextprotocol/server-filesystem", "${HOME}"}},
 		},
 		Targets: []models.Target{
 			{Name: "local", Type: models.TargetLocal},
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"claude-relay/internal/mapping"
+	"claude-relay/internal/models"
+)
+
+// builtinTools are the Claude Code tool names accepted in permission rules.
+var builtinTools = map[string]bool{
+	"Bash": true, "BashOutput": true, "Edit": true, "ExitPlanMode": true,
+	"Glob": true, "Grep": true, "KillShell": true, "LS": true,
+	"MultiEdit": true, "NotebookEdit": true, "NotebookRead": true, "Read": true,
+	"SlashCommand": true, "Task": true, "TodoWrite": true, "WebFetch": true,
+	"WebSearch": true, "Write": true,
+}
+
+var (
+	// Tool or Tool(specifier)
+	toolRuleRe = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9_-]*)(?:\((.*)\))?$`)
+	// mcp__server, mcp__server__tool or mcp__server__*
+	mcpRuleRe = regexp.MustCompile(`^mcp__[A-Za-z0-9_-]+(?:__(?:[A-Za-z0-9_-]+|\*))?$`)
+)
+
+var permissionModes = map[string]bool{
+	"default": true, "acceptEdits": true, "plan": true, "bypassPermissions": true,
+}
+
+// hookEvents maps each hook event to whether it takes a matcher, and if so
+// the fixed set of matcher values (nil means any tool-name regex).
+var hookEvents = map[string]struct {
+	matcher bool
+	values  []string
+}{
+	"PreToolUse":       {matcher: true},
+	"PostToolUse":      {matcher: true},
+	"Notification":     {},
+	"UserPromptSubmit": {},
+	"Stop":             {},
+	"SubagentStop":     {},
+	"PreCompact":       {matcher: true, values: []string{"manual", "auto"}},
+	"SessionStart":     {matcher: true, values: []string{"startup", "resume", "clear", "compact"}},
+	"SessionEnd":       {},
+}
+
+// Validate checks the mapping rules, catalog and HTTP settings and the parts of
+// the config that are written verbatim into Claude's settings.json, so
+// malformed entries are rejected before deploy.
+func Validate(cfg *models.Config) error {
+	if err := mapping.Validate(cfg); err != nil {
+		return err
+	}
+	if err := validateCatalogSettings(cfg); err != nil {
+		return err
+	}
+	if err := ValidateHTTPSettings(cfg.HTTP); err != nil {
+		return err
+	}
+	if err := ValidatePermissions(cfg.Permissions); err != nil {
+		return err
+	}
+	return ValidateHooks(cfg.Hooks)
+}
+
+// ValidateHTTPSettings checks the syntax of the outbound settings. Files are
+// only read when a client is built.
+func ValidateHTTPSettings(s *models.HTTPSettings) error {
+	if s == nil {
+		return nil
+	}
+	if s.Proxy != "" && s.Proxy != models.ProxyDirect {
+		u, err := url.Parse(s.Proxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("http.proxy %q: must be a URL such as http://proxy:3128, or \"direct\"", s.Proxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("http.proxy %q: scheme must be http, https or socks5", s.Proxy)
+		}
+	}
+	if (s.ClientCert == "") != (s.ClientKey == "") {
+		return fmt.Errorf("http: client_cert and client_key must be set together")
+	}
+	switch s.TLSMinVersion {
+	case "", "1.2", "1.3":
+	default:
+		return fmt.Errorf("http.tls_min_version %q: must be 1.2 or 1.3", s.TLSMinVersion)
+	}
+	for _, t := range []struct{ name, value string }{
+		{"timeout", s.Timeout}, {"connect_timeout", s.ConnectTimeout},
+	} {
+		if t.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(t.value); err != nil || d <= 0 {
+			return fmt.Errorf("http.%s %q: must be a positive duration such as 30s", t.name, t.value)
+		}
+	}
+	return nil
+}
+
+func validateCatalogSettings(cfg *models.Config) error {
+	switch cfg.AutoDetectPolicy {
+	case "", models.AutoDetectBlock, models.AutoDetectApply:
+	default:
+		return fmt.Errorf("auto_detect_policy %q: must be block or apply", cfg.AutoDetectPolicy)
+	}
+	switch cfg.AccountAPI {
+	case "", "newapi", "oneapi", "none":
+	default:
+		return fmt.Errorf("account_api %q: must be newapi, oneapi or none", cfg.AccountAPI)
+	}
+	if cfg.QuotaPerUSD < 0 {
+		return fmt.Errorf("quota_per_usd %d: must be positive, or 0 for the default 500000", cfg.QuotaPerUSD)
+	}
+	if cfg.CatalogTTL != "" {
+		if d, err := time.ParseDuration(cfg.CatalogTTL); err != nil || d < 0 {
+			return fmt.Errorf("catalog_ttl %q: must be a duration such as 1h, or 0 to always revalidate", cfg.CatalogTTL)
+		}
+	}
+	if cfg.AutoDetectInterval != "" {
+		d, err := time.ParseDuration(cfg.AutoDetectInterval)
+		if err != nil {
+			return fmt.Errorf("auto_detect_interval: %w", err)
+		}
+		if d < time.Minute {
+			return fmt.Errorf("auto_detect_interval must be at least 1m")
+		}
+	}
+	return nil
+}
+
+// ValidatePermissions checks allow/deny/ask tool rules and defaultMode.
+func ValidatePermissions(p *models.Permissions) error {
+	if p == nil {
+		return nil
+	}
+	for _, list := range []struct {
+		name  string
+		rules []string
+	}{{"allow", p.Allow}, {"deny", p.Deny}, {"ask", p.Ask}} {
+		for i, rule := range list.rules {
+			if err := validateToolRule(rule); err != nil {
+				return fmt.Errorf("permissions.%s[%d] %q: %w", list.name, i, rule, err)
+			}
+		}
+	}
+	if p.DefaultMode != "" && !permissionModes[p.DefaultMode] {
+		return fmt.Errorf("permissions.defaultMode %q: must be one of default, acceptEdits, plan, bypassPermissions", p.DefaultMode)
+	}
+	for i, dir := range p.AdditionalDirectories {
+		if strings.TrimSpace(dir) == "" {
+			return fmt.Errorf("permissions.additionalDirectories[%d]: empty path", i)
+		}
+	}
+	return nil
+}
+
+func validateToolRule(rule string) error {
+	if strings.ContainsAny(rule, "\n\r") {
+		return fmt.Errorf("must be a single line")
+	}
+	if strings.HasPrefix(rule, "mcp__") {
+		if !mcpRuleRe.MatchString(rule) {
+			return fmt.Errorf("MCP rules look like mcp__server or mcp__server__tool")
+		}
+		return nil
+	}
+	m := toolRuleRe.FindStringSubmatch(rule)
+	if m == nil {
+		return fmt.Errorf("expected Tool or Tool(specifier)")
+	}
+	if !builtinTools[m[1]] {
+		return fmt.Errorf("unknown tool %q", m[1])
+	}
+	hasSpec := strings.HasSuffix(rule, ")")
+	spec := m[2]
+	if hasSpec && strings.TrimSpace(spec) == "" {
+		return fmt.Errorf("empty specifier; use %s without parentheses to match all", m[1])
+	}
+	if strings.Count(spec, "(") != strings.Count(spec, ")") {
+		return fmt.Errorf("unbalanced parentheses in specifier")
+	}
+	if m[1] == "WebFetch" && hasSpec && !strings.HasPrefix(spec, "domain:") {
+		return fmt.Errorf("WebFetch specifier must be domain:<host>")
+	}
+	return nil
+}
+
+// ValidateHooks checks hook event names, matchers and hook commands.
+func ValidateHooks(hooks map[string][]models.HookMatcher) error {
+	for event, matchers := range hooks {
+		spec, ok := hookEvents[event]
+		if !ok {
+			return fmt.Errorf("hooks: unknown event %q", event)
+		}
+		for i, m := range matchers {
+			where := fmt.Sprintf("hooks.%s[%d]", event, i)
+			if m.Matcher != "" {
+				if !spec.matcher {
+					return fmt.Errorf("%s: %s hooks do not take a matcher", where, event)
+				}
+				if err := validateMatcher(m.Matcher, spec.values); err != nil {
+					return fmt.Errorf("%s matcher %q: %w", where, m.Matcher, err)
+				}
+			}
+			if len(m.Hooks) == 0 {
+				return fmt.Errorf("%s: at least one hook is required", where)
+			}
+			for j, h := range m.Hooks {
+				if err := validateHook(h); err != nil {
+					return fmt.Errorf("%s.hooks[%d]: %w", where, j, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateMatcher(matcher string, values []string) error {
+	if values != nil {
+		for _, v := range values {
+			if matcher == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+	}
+	if matcher == "*" {
+		return nil
+	}
+	if _, err := regexp.Compile(matcher); err != nil {
+		return fmt.Errorf("invalid regex: %w", err)
+	}
+	return nil
+}
+
+func validateHook(h models.Hook) error {
+	switch h.Type {
+	case "command":
+		if strings.TrimSpace(h.Command) == "" {
+			return fmt.Errorf("command hooks need a command")
+		}
+	case "prompt":
+		if strings.TrimSpace(h.Prompt) == "" {
+			return fmt.Errorf("prompt hooks need a prompt")
+		}
+	default:
+		return fmt.Errorf("type must be command or prompt, got %q", h.Type)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
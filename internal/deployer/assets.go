@@ -0,0 +1,286 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"claude-relay/internal/models"
+)
+
+// Synced assets, relative to the source directory and to ~/.claude on the
+// target: every *.md below commands/ and agents/, plus CLAUDE.md.
+var assetDirs = []string{"commands", "agents"}
+
+const assetRootFile = "CLAUDE.md"
+
+// assetManifest maps relative asset paths (forward slashes) to sha256 hex,
+// or to unreadablePrefix and the reason for a file that could not be read.
+type assetManifest map[string]string
+
+const unreadablePrefix = "unreadable:"
+
+// localAssetManifest hashes the assets below root.
+func localAssetManifest(root string) (assetManifest, error) {
+	manifest := make(assetManifest)
+	add := func(p string) error {
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			manifest[filepath.ToSlash(rel)] = unreadablePrefix + err.Error()
+			return nil
+		}
+		sum := sha256.Sum256(data)
+		manifest[filepath.ToSlash(rel)] = hex.EncodeToString(sum[:])
+		return nil
+	}
+	for _, dir := range assetDirs {
+		err := filepath.WalkDir(filepath.Join(root, dir), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(d.Name(), ".md") {
+				return nil
+			}
+			return add(p)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, assetRootFile)); err == nil {
+		if err := add(filepath.Join(root, assetRootFile)); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// remoteAssetManifestCmd prints "<sha256> <relpath>" per asset in ~/.claude,
+// or "unreadable: <relpath>" for a file it cannot read.
+const remoteAssetManifestCmd = `python3 -c "
+import hashlib,os
+root=os.path.expanduser('~/.claude')
+files=[]
+for sub in ('commands','agents'):
+    for d,_,fs in os.walk(os.path.join(root,sub)):
+        files+=[os.path.relpath(os.path.join(d,f),root) for f in fs if f.endswith('.md')]
+if os.path.isfile(os.path.join(root,'CLAUDE.md')): files.append('CLAUDE.md')
+for r in files:
+    try:
+        with open(os.path.join(root,r),'rb') as f: print(hashlib.sha256(f.read()).hexdigest()+' '+r.replace(os.sep,'/'))
+    except EnvironmentError: print('unreadable: '+r.replace(os.sep,'/'))
+"`
+
+func remoteAssetManifest(ctx context.Context, target models.Target) (assetManifest, error) {
+	out, err := remoteExec(ctx, target, remoteAssetManifestCmd)
+	if err != nil {
+		return nil, fmt.Errorf("read asset manifest on %s: %w", target.Name, err)
+	}
+	manifest := make(assetManifest)
+	for _, line := range strings.Split(out, "\n") {
+		if sum, rel, ok := strings.Cut(line, " "); ok {
+			if sum == unreadablePrefix {
+				sum += "cannot read on " + target.Name
+			}
+			manifest[rel] = sum
+		}
+	}
+	return manifest, nil
+}
+
+// diffAssets compares the source manifest with the target's.
+func diffAssets(src, dst assetManifest) (drift []models.AssetDrift) {
+	for rel, sum := range src {
+		switch dstSum, ok := dst[rel]; {
+		case strings.HasPrefix(sum, unreadablePrefix):
+			drift = append(drift, models.AssetDrift{Path: rel, State: "unreadable", Error: strings.TrimPrefix(sum, unreadablePrefix)})
+		case strings.HasPrefix(dstSum, unreadablePrefix):
+			drift = append(drift, models.AssetDrift{Path: rel, State: "unreadable", Error: strings.TrimPrefix(dstSum, unreadablePrefix)})
+		case !ok:
+			drift = append(drift, models.AssetDrift{Path: rel, State: "missing"})
+		case dstSum != sum:
+			drift = append(drift, models.AssetDrift{Path: rel, State: "changed"})
+		}
+	}
+	for rel := range dst {
+		if _, ok := src[rel]; !ok {
+			drift = append(drift, models.AssetDrift{Path: rel, State: "orphan"})
+		}
+	}
+	sort.Slice(drift, func(i, j int) bool { return drift[i].Path < drift[j].Path })
+	return drift
+}
+
+// assetSource returns the local source directory, defaulting to ~/.claude.
+func assetSource(sync *models.AssetSync) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	src := sync.Source
+	switch {
+	case src == "":
+		return filepath.Join(home, ".claude"), nil
+	case strings.HasPrefix(src, "~/"):
+		return filepath.Join(home, src[2:]), nil
+	}
+	return src, nil
+}
+
+// targetAssetManifest reads the manifest of ~/.claude on the target.
+func targetAssetManifest(ctx context.Context, target models.Target) (assetManifest, string, error) {
+	if target.Type == models.TargetLocal {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, "", err
+		}
+		root := filepath.Join(home, ".claude")
+		m, err := localAssetManifest(root)
+		return m, root, err
+	}
+	m, err := remoteAssetManifest(ctx, target)
+	return m, "", err
+}
+
+// AssetDrift reports which synced assets differ between source and target.
+// Assets that cannot be compared are reported per asset, as unreadable, or as
+// unknown when the target manifest cannot be read at all; only an unreadable
+// source fails.
+func AssetDrift(ctx context.Context, target models.Target, sync *models.AssetSync) ([]models.AssetDrift, error) {
+	src, err := assetSource(sync)
+	if err != nil {
+		return nil, err
+	}
+	srcManifest, err := localAssetManifest(src)
+	if err != nil {
+		return nil, fmt.Errorf("read asset source: %w", err)
+	}
+	dstManifest, _, err := targetAssetManifest(ctx, target)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		drift := make([]models.AssetDrift, 0, len(srcManifest))
+		for rel := range srcManifest {
+			drift = append(drift, models.AssetDrift{Path: rel, State: "unknown", Error: err.Error()})
+		}
+		sort.Slice(drift, func(i, j int) bool { return drift[i].Path < drift[j].Path })
+		return drift, nil
+	}
+	drift := diffAssets(srcManifest, dstManifest)
+	if !sync.DeleteOrphans {
+		// Orphans are only drift when we would delete them.
+		kept := drift[:0]
+		for _, d := range drift {
+			if d.State != "orphan" {
+				kept = append(kept, d)
+			}
+		}
+		drift = kept
+	}
+	return drift, nil
+}
+
+// SyncAssets pushes changed assets to the target and, if configured, deletes
+// target assets that no longer exist in the source.
+func SyncAssets(ctx context.Context, target models.Target, sync *models.AssetSync) (*models.AssetSyncResult, error) {
+	src, err := assetSource(sync)
+	if err != nil {
+		return nil, err
+	}
+	srcManifest, err := localAssetManifest(src)
+	if err != nil {
+		return nil, fmt.Errorf("read asset source: %w", err)
+	}
+	ctx, err = Pin(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	dstManifest, localRoot, err := targetAssetManifest(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if localRoot != "" && filepath.Clean(localRoot) == filepath.Clean(src) {
+		// Syncing ~/.claude onto itself.
+		return &models.AssetSyncResult{Target: target.Name, Unchanged: len(srcManifest)}, nil
+	}
+
+	result := &models.AssetSyncResult{Target: target.Name, Pushed: []string{}, Deleted: []string{}}
+	for _, d := range diffAssets(srcManifest, dstManifest) {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if err := validateAssetPath(d.Path); err != nil {
+			return result, err
+		}
+		switch d.State {
+		case "missing", "changed", "unreadable":
+			data, err := os.ReadFile(filepath.Join(src, filepath.FromSlash(d.Path)))
+			if err != nil {
+				return result, err
+			}
+			if err := pushAsset(ctx, target, localRoot, d.Path, data); err != nil {
+				return result, fmt.Errorf("push %s: %w", d.Path, err)
+			}
+			logf(ctx, "pushed %s", d.Path)
+			result.Pushed = append(result.Pushed, d.Path)
+		case "orphan":
+			if !sync.DeleteOrphans {
+				continue
+			}
+			if err := deleteAsset(ctx, target, localRoot, d.Path); err != nil {
+				return result, fmt.Errorf("delete %s: %w", d.Path, err)
+			}
+			logf(ctx, "deleted %s", d.Path)
+			result.Deleted = append(result.Deleted, d.Path)
+		}
+	}
+	result.Unchanged = len(srcManifest) - len(result.Pushed)
+	return result, nil
+}
+
+// validateAssetPath guards the relative paths interpolated into shell commands.
+func validateAssetPath(rel string) error {
+	if strings.ContainsAny(rel, "\"'`$\\\n") || strings.Contains(rel, "..") {
+		return fmt.Errorf("unsupported asset path: %q", rel)
+	}
+	return nil
+}
+
+func pushAsset(ctx context.Context, target models.Target, localRoot, rel string, data []byte) error {
+	if localRoot != "" {
+		dst := filepath.Join(localRoot, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(dst, data, 0644)
+	}
+	// The content goes over stdin, so its size is not bound by the
+	// command line limit.
+	cmd := fmt.Sprintf(`mkdir -p "$HOME/.claude/%s" && cat > "$HOME/.claude/%s"`, path.Dir(rel), rel)
+	_, err := remoteRun(ctx, target, cmd, bytes.NewReader(data))
+	return err
+}
+
+func deleteAsset(ctx context.Context, target models.Target, localRoot, rel string) error {
+	if localRoot != "" {
+		return os.Remove(filepath.Join(localRoot, filepath.FromSlash(rel)))
+	}
+	_, err := remoteExec(ctx, target, fmt.Sprintf(`rm -f "$HOME/.claude/%s"`, rel))
+	return err
+}
@@ -0,0 +1,137 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"claude-relay/internal/models"
+	"claude-relay/internal/testutil"
+)
+
+// assetSourceDir writes a source tree of synced assets and one that is not.
+func assetSourceDir(t *testing.T) string {
+	t.Helper()
+	src := t.TempDir()
+	testutil.WriteFile(t, filepath.Join(src, "commands", "review.md"), "review the diff\n")
+	testutil.WriteFile(t, filepath.Join(src, "agents", "ops", "deploy.md"), "deploy agent\n")
+	testutil.WriteFile(t, filepath.Join(src, "CLAUDE.md"), "be brief\n")
+	testutil.WriteFile(t, filepath.Join(src, "commands", "notes.txt"), "not an asset\n")
+	return src
+}
+
+func driftStates(drift []models.AssetDrift) []string {
+	states := []string{}
+	for _, d := range drift {
+		states = append(states, d.Path+" "+d.State)
+	}
+	return states
+}
+
+func TestSyncAssetsRemote(t *testing.T) {
+	home := fakeSSH(t)
+	src := assetSourceDir(t)
+	dst := filepath.Join(home, ".claude")
+	testutil.WriteFile(t, filepath.Join(dst, "commands", "review.md"), "stale\n")
+	testutil.WriteFile(t, filepath.Join(dst, "agents", "old.md"), "gone from the source\n")
+	target := models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev"}
+	sync := &models.AssetSync{Enabled: true, Source: src}
+	ctx := context.Background()
+
+	drift, err := AssetDrift(ctx, target, sync)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Orphans are only drift when they would be deleted.
+	want := []string{"CLAUDE.md missing", "agents/ops/deploy.md missing", "commands/review.md changed"}
+	if got := driftStates(drift); !reflect.DeepEqual(got, want) {
+		t.Errorf("drift = %q", got)
+	}
+	sync.DeleteOrphans = true
+	drift, _ = AssetDrift(ctx, target, sync)
+	want = []string{"CLAUDE.md missing", "agents/old.md orphan", "agents/ops/deploy.md missing", "commands/review.md changed"}
+	if got := driftStates(drift); !reflect.DeepEqual(got, want) {
+		t.Errorf("drift with orphans = %q", got)
+	}
+
+	res, err := SyncAssets(ctx, target, sync)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Pushed, []string{"CLAUDE.md", "agents/ops/deploy.md", "commands/review.md"}) ||
+		!reflect.DeepEqual(res.Deleted, []string{"agents/old.md"}) || res.Unchanged != 0 {
+		t.Errorf("result = %+v", res)
+	}
+	for _, rel := range []string{"CLAUDE.md", "agents/ops/deploy.md", "commands/review.md"} {
+		want, _ := os.ReadFile(filepath.Join(src, rel))
+		if got, err := os.ReadFile(filepath.Join(dst, rel)); err != nil || string(got) != string(want) {
+			t.Errorf("%s on the target = %q, %v", rel, got, err)
+		}
+	}
+	for _, rel := range []string{"agents/old.md", "commands/notes.txt"} {
+		if _, err := os.Stat(filepath.Join(dst, rel)); !os.IsNotExist(err) {
+			t.Errorf("%s on the target: %v", rel, err)
+		}
+	}
+
+	// A second sync has nothing to do.
+	if drift, err := AssetDrift(ctx, target, sync); err != nil || len(drift) != 0 {
+		t.Errorf("drift after sync = %q, %v", driftStates(drift), err)
+	}
+	if res, err := SyncAssets(ctx, target, sync); err != nil || len(res.Pushed)+len(res.Deleted) != 0 || res.Unchanged != 3 {
+		t.Errorf("second sync = %+v, %v", res, err)
+	}
+}
+
+func TestSyncAssetsLocal(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	src := assetSourceDir(t)
+	target := models.Target{Name: "local", Type: models.TargetLocal}
+	ctx := context.Background()
+
+	res, err := SyncAssets(ctx, target, &models.AssetSync{Enabled: true, Source: src})
+	if err != nil || len(res.Pushed) != 3 {
+		t.Fatalf("sync = %+v, %v", res, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(home, ".claude", "agents", "ops", "deploy.md")); string(data) != "deploy agent\n" {
+		t.Errorf("pushed agent = %q", data)
+	}
+
+	// The default source is ~/.claude itself, which is never pushed onto.
+	res, err = SyncAssets(ctx, target, &models.AssetSync{Enabled: true})
+	if err != nil || res.Pushed != nil || res.Unchanged != 3 {
+		t.Errorf("sync onto itself = %+v, %v", res, err)
+	}
+}
+
+func TestAssetDriftUnreachable(t *testing.T) {
+	testutil.FakeCommand(t, "ssh", "echo 'connection refused' >&2; exit 255\n")
+	src := assetSourceDir(t)
+	target := models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev"}
+
+	drift, err := AssetDrift(context.Background(), target, &models.AssetSync{Enabled: true, Source: src})
+	if err != nil || len(drift) != 3 {
+		t.Fatalf("drift = %+v, %v", drift, err)
+	}
+	for _, d := range drift {
+		if d.State != "unknown" || d.Error == "" {
+			t.Errorf("%s: %+v", d.Path, d)
+		}
+	}
+}
+
+func TestValidateAssetPath(t *testing.T) {
+	for _, rel := range []string{"commands/a.md", "agents/ops/deploy-v2.md", "CLAUDE.md"} {
+		if err := validateAssetPath(rel); err != nil {
+			t.Errorf("%s: %v", rel, err)
+		}
+	}
+	for _, rel := range []string{"commands/$(id).md", `agents/a"b.md`, "commands/../../.bashrc.md", "commands/a`b`.md"} {
+		if err := validateAssetPath(rel); err == nil {
+			t.Errorf("%s accepted", rel)
+		}
+	}
+}
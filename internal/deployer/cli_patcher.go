@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+
+	"claude-relay/internal/mapping"
 )
 
 const cliPatchMarker = "/* claude-relay-cli-patch */"
@@ -41,19 +43,6 @@ func FindCLIJS() (string, error) {
 	return "", fmt.Errorf("cli.js not found; ensure GitHub Copilot Chat is installed")
 }
 
-// buildCLIModelMap generates the globalThis model map JS snippet.
-func buildCLIModelMap(mappings map[string]string) string {
-	var pairs []string
-	for k, v := range mappings {
-		pairs = append(pairs, fmt.Sprintf(`"%s":"%s"`, k, v))
-	}
-	sort.Strings(pairs) // deterministic output
-	return fmt.Sprintf(
-		`globalThis.__cliModelMap={%s};globalThis.__cliMap=function(m){return(globalThis.__cliModelMap[m]||m)};`,
-		strings.Join(pairs, ","),
-	)
-}
-
 // cliPatchPoints defines the find→replace rules for cli.js.
 // Each entry: [0]=old string, [1]=new string.
 // IMPORTANT: These patterns are based on the minified cli.js from copilot-chat-0.37.x.
@@ -189,7 +178,7 @@ func discoverCLIPatchPoints(content string) []cliPatchPoint {
 //     → injecting at "use strict" puts code in wrong scope → "XXX is not defined" errors
 //  3. Must use globalThis.* to ensure variables are accessible across all scopes
 //  4. Injection point is BEFORE the first import statement at file top level
-func PatchCLI(path string, mappings map[string]string) error {
+func PatchCLI(path string, mapper *mapping.Mapper) error {
 	backupPath := path + ".claude-relay-backup"
 
 	// If a backup exists, always restore from the clean backup first.
@@ -214,8 +203,16 @@ func PatchCLI(path string, mappings map[string]string) error {
 			return fmt.Errorf("create cli.js backup: %w", err)
 		}
 	}
-	content := string(data)
+	content, err := patchCLIContent(string(data), mapper)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
 
+// patchCLIContent applies the model map and the function-level patches to
+// the content of an unpatched cli.js.
+func patchCLIContent(content string, mapper *mapping.Mapper) (string, error) {
 	// Remove existing patch header if present (handles dirty backups)
 	if start := strings.Index(content, cliPatchMarker); start >= 0 {
 		// Look for the import statement that follows
@@ -233,8 +230,8 @@ func PatchCLI(path string, mappings map[string]string) error {
 		}
 	}
 
-	// Build the model map JS
-	modelMapJS := cliPatchMarker + buildCLIModelMap(mappings)
+	// Build the model map JS (exact table, rules and fallback)
+	modelMapJS := cliPatchMarker + mapper.JS()
 
 	// Inject at file header, before the first import statement
 	// cli.js starts with: #!/usr/bin/env node\n// comments...\nimport{createRequire...
@@ -246,7 +243,7 @@ func PatchCLI(path string, mappings map[string]string) error {
 	if idx := strings.Index(content, importMarker); idx >= 0 {
 		content = content[:idx] + modelMapJS + content[idx:]
 	} else {
-		return fmt.Errorf("cannot find import statement in cli.js; file format may have changed")
+		return "", fmt.Errorf("cannot find import statement in cli.js; file format may have changed")
 	}
 
 	// Discover and apply function-level patches
@@ -260,10 +257,9 @@ func PatchCLI(path string, mappings map[string]string) error {
 	}
 
 	if applied == 0 {
-		return fmt.Errorf("no function-level patches matched in cli.js; minified names may have changed (see ARCHITECTURE.md)")
+		return "", fmt.Errorf("no function-level patches matched in cli.js; minified names may have changed (see ARCHITECTURE.md)")
 	}
-
-	return os.WriteFile(path, []byte(content), 0644)
+	return content, nil
 }
 
 // RestoreCLIBackup restores cli.js from backup.
@@ -0,0 +1,89 @@
+package deployer
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"claude-relay/internal/mapping"
+	"claude-relay/internal/models"
+)
+
+// patchRemoteCLICopy patches cli.js on a target by copying the clean backup
+// out, patching it with the local patcher and copying the result back, the
+// way kubectl cp moves files: as tar streams through exec. The target needs
+// only sh and tar.
+func patchRemoteCLICopy(ctx context.Context, target models.Target, cliPath, backupPath string, mapper *mapping.Mapper) error {
+	hdr, data, err := remoteReadFile(ctx, target, backupPath)
+	if err != nil {
+		return fmt.Errorf("copy %s: %w", backupPath, err)
+	}
+	content, err := patchCLIContent(string(data), mapper)
+	if err != nil {
+		return err
+	}
+	if err := remoteWriteFile(ctx, target, cliPath, []byte(content), hdr); err != nil {
+		return fmt.Errorf("copy %s: %w", cliPath, err)
+	}
+	return nil
+}
+
+// remoteReadFile reads a file on the target from a tar stream and returns
+// its header along with its content.
+func remoteReadFile(ctx context.Context, target models.Target, file string) (*tar.Header, []byte, error) {
+	dir, name := path.Split(file)
+	out, err := remoteRun(ctx, target, "cd "+shellQuote(dir)+" && tar -cf - "+shellQuote(name), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	tr := tar.NewReader(bytes.NewReader(out))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil, fmt.Errorf("%s missing from tar stream", name)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("read tar stream: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeReg && path.Clean(hdr.Name) == name {
+			data, err := io.ReadAll(tr)
+			return hdr, data, err
+		}
+	}
+}
+
+// remoteWriteFile writes data to a file on the target by extracting a tar
+// stream in its directory. The file gets the mode and owner in like, the
+// header of the file it replaces; tar keeps the owner only when it runs as
+// root and otherwise leaves the file to the exec user, as cp would.
+func remoteWriteFile(ctx context.Context, target models.Target, file string, data []byte, like *tar.Header) error {
+	dir, name := path.Split(file)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     like.Mode,
+		Uid:      like.Uid,
+		Gid:      like.Gid,
+		Uname:    like.Uname,
+		Gname:    like.Gname,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	_, err := remoteRun(ctx, target, "cd "+shellQuote(dir)+" && tar -xf -", &buf)
+	return err
+}
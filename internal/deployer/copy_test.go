@@ -0,0 +1,85 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"claude-relay/internal/models"
+	"claude-relay/internal/testutil"
+)
+
+// fakeKubectlExec puts a kubectl on PATH whose "exec ... -- sh -c cmd"
+// runs cmd locally.
+func fakeKubectlExec(t *testing.T) {
+	testutil.FakeCommand(t, "kubectl", `while [ "$#" -gt 0 ] && [ "$1" != "--" ]; do shift; done
+shift
+exec "$@"
+`)
+}
+
+func TestRemoteCopyRoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not found")
+	}
+	fakeKubectlExec(t)
+	target := models.Target{Name: "ide", Type: models.TargetKubernetes, Host: "pod-1"}
+	ctx := context.Background()
+
+	// The directory name needs quoting for the shell.
+	dir := filepath.Join(t.TempDir(), "it's $HOME")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	backup := filepath.Join(dir, "cli.js.backup")
+	cli := filepath.Join(dir, "cli.js")
+	if err := os.WriteFile(backup, []byte("original"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(backup, 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	hdr, data, err := remoteReadFile(ctx, target, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "original" || hdr.Mode&0777 != 0750 || hdr.Uid != os.Getuid() {
+		t.Errorf("read %q, mode %o, uid %d", data, hdr.Mode, hdr.Uid)
+	}
+
+	if err := remoteWriteFile(ctx, target, cli, []byte("patched"), hdr); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(cli)
+	if err != nil || string(got) != "patched" {
+		t.Errorf("wrote %q, %v", got, err)
+	}
+	if fi, err := os.Stat(cli); err != nil || fi.Mode().Perm() != 0750 {
+		t.Errorf("mode of written file: %v, %v", fi.Mode(), err)
+	}
+
+	if _, _, err := remoteReadFile(ctx, target, filepath.Join(dir, "missing.js")); err == nil {
+		t.Error("missing file: want an error")
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"/home/alice/":      `'/home/alice/'`,
+		"it's":              `'it'\''s'`,
+		"$HOME `id` \\ \"x": `'$HOME ` + "`id`" + ` \ "x'`,
+		"":                  `''`,
+	}
+	for in, want := range tests {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", in, got, want)
+		}
+		out, err := exec.Command("sh", "-c", "printf %s "+shellQuote(in)).Output()
+		if err != nil || string(out) != in {
+			t.Errorf("sh read %s as %q, %v", shellQuote(in), out, err)
+		}
+	}
+}
@@ -1,42 +1,134 @@
 package deployer
 
 import (
+	"context"
 	"fmt"
 
+	"claude-relay/internal/config"
+	"claude-relay/internal/mapping"
 	"claude-relay/internal/models"
 )
 
-// Deploy executes a full deployment to the given target.
-func Deploy(target models.Target, cfg *models.Config) error {
+type logKey struct{}
+
+// WithLog returns a context under which deployer operations report each
+// step to logf, e.g. into the log of a job.
+func WithLog(ctx context.Context, logf func(format string, args ...any)) context.Context {
+	return context.WithValue(ctx, logKey{}, logf)
+}
+
+// logf reports a step to the logger set by WithLog, if any.
+func logf(ctx context.Context, format string, args ...any) {
+	if l, ok := ctx.Value(logKey{}).(func(string, ...any)); ok {
+		l(format, args...)
+	}
+}
+
+// Deploy executes a full deployment to the given target. Cancelling ctx
+// stops it between steps; remote steps are also bounded by stepTimeout.
+func Deploy(ctx context.Context, target models.Target, cfg *models.Config) error {
+	if err := config.Validate(cfg); err != nil {
+		return err
+	}
+	ctx, err := Pin(ctx, target)
+	if err != nil {
+		return err
+	}
+	logf(ctx, "resolving template variables on %s", target.Name)
+	cfg, _, err = resolveConfig(ctx, target, cfg)
+	if err != nil {
+		return err
+	}
 	if target.Type == models.TargetLocal {
-		return deployLocal(cfg)
+		err = deployLocal(ctx, cfg)
+	} else {
+		err = deployRemote(ctx, target, cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	if cfg.AssetSync != nil && cfg.AssetSync.Enabled {
+		logf(ctx, "syncing assets")
+		if _, err := SyncAssets(ctx, target, cfg.AssetSync); err != nil {
+			return fmt.Errorf("sync assets: %w", err)
+		}
+	}
+	return nil
+}
+
+// Preview resolves the per-target values a deploy would write, without
+// touching the target.
+func Preview(ctx context.Context, target models.Target, cfg *models.Config) (*models.DeployPreview, error) {
+	ctx, err := Pin(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	resolved, vars, err := resolveConfig(ctx, target, cfg)
+	if err != nil {
+		return nil, err
 	}
-	return deployRemote(target, cfg)
+	env, err := claudeEnv(resolved)
+	if err != nil {
+		return nil, err
+	}
+	return &models.DeployPreview{
+		Target:     target.Name,
+		Vars:       vars,
+		MCPServers: resolved.MCPServers,
+		Env:        env,
+	}, nil
 }
 
-// Status checks the deployment status of a target.
-func Status(target models.Target) (*models.DeployStatus, error) {
+// Status checks the deployment status of a target, including asset drift
+// when asset sync is enabled.
+func Status(ctx context.Context, target models.Target, cfg *models.Config) (*models.DeployStatus, error) {
+	ctx, err := Pin(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	var status *models.DeployStatus
 	if target.Type == models.TargetLocal {
-		return statusLocal(target)
+		status, err = statusLocal(target)
+	} else {
+		status, err = statusRemote(ctx, target)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.AssetSync != nil && cfg.AssetSync.Enabled {
+		// Drift is extra information; failing to check it leaves the rest
+		// of the status intact.
+		drift, err := AssetDrift(ctx, target, cfg.AssetSync)
+		if err != nil {
+			status.AssetDriftError = err.Error()
+		}
+		status.AssetDrift = drift
 	}
-	return statusRemote(target)
+	return status, nil
 }
 
-// Restore restores the extension.js backup on a target.
-func Restore(target models.Target) error {
+// Restore undoes a deployment: settings files are put back to their
+// pre-deploy snapshot and cli.js/extension.js are restored from backup.
+func Restore(ctx context.Context, target models.Target) error {
 	if target.Type == models.TargetLocal {
 		return restoreLocal()
 	}
-	return restoreRemote(target)
+	ctx, err := Pin(ctx, target)
+	if err != nil {
+		return err
+	}
+	return restoreRemote(ctx, target)
 }
 
 // --- Local operations ---
 
-func deployLocal(cfg *models.Config) error {
-	// 1. Build mapping table
-	mappings := make(map[string]string)
-	for _, m := range cfg.ModelMappings {
-		mappings[m.VSCodeID] = m.RelayID
+func deployLocal(ctx context.Context, cfg *models.Config) error {
+	// 1. Compile mapping table and rules
+	mapper, err := mapping.Compile(cfg)
+	if err != nil {
+		return fmt.Errorf("compile model mappings: %w", err)
 	}
 
 	// 2. Restore extension.js if previously patched (cleanup legacy patches)
@@ -58,16 +150,32 @@ func deployLocal(cfg *models.Config) error {
 	if err != nil {
 		return fmt.Errorf("find cli.js: %w", err)
 	}
-	if err := PatchCLI(cliPath, mappings); err != nil {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	logf(ctx, "patching %s", cliPath)
+	if err := PatchCLI(cliPath, mapper); err != nil {
 		return fmt.Errorf("patch cli.js: %w", err)
 	}
 
-	// 4. Write claude settings
+	// 4. Write claude settings (snapshot the original first so Restore can undo it)
+	claudePath, err := claudeSettingsPath()
+	if err != nil {
+		return err
+	}
+	if err := snapshotFile(claudePath); err != nil {
+		return fmt.Errorf("snapshot claude settings: %w", err)
+	}
+	logf(ctx, "writing %s", claudePath)
 	if err := WriteClaudeSettings(cfg); err != nil {
 		return fmt.Errorf("write claude settings: %w", err)
 	}
 
 	// 5. Write VSCode settings (MCP)
+	if err := snapshotFile(vscodeSettingsPath(models.TargetLocal)); err != nil {
+		return fmt.Errorf("snapshot vscode settings: %w", err)
+	}
+	logf(ctx, "writing %s", vscodeSettingsPath(models.TargetLocal))
 	if err := WriteVSCodeSettings(models.TargetLocal, cfg.MCPServers); err != nil {
 		return fmt.Errorf("write vscode settings: %w", err)
 	}
@@ -89,6 +197,10 @@ func statusLocal(target models.Target) (*models.DeployStatus, error) {
 		status.BackupExists = HasBackup(extPath)
 	}
 	status.ConfigExists = ClaudeSettingsExist()
+	if claudePath, err := claudeSettingsPath(); err == nil {
+		status.ClaudeSettingsState = snapshotState(claudePath)
+	}
+	status.VSCodeSettingsState = snapshotState(vscodeSettingsPath(models.TargetLocal))
 
 	// Check cli.js patch status (this is the only file we actively patch)
 	cliPath, cliErr := FindCLIJS()
@@ -102,6 +214,16 @@ func statusLocal(target models.Target) (*models.DeployStatus, error) {
 }
 
 func restoreLocal() error {
+	// Put settings files back first; they do not depend on cli.js being found.
+	if claudePath, err := claudeSettingsPath(); err == nil {
+		if err := restoreSnapshot(claudePath); err != nil {
+			return fmt.Errorf("restore claude settings: %w", err)
+		}
+	}
+	if err := restoreSnapshot(vscodeSettingsPath(models.TargetLocal)); err != nil {
+		return fmt.Errorf("restore vscode settings: %w", err)
+	}
+
 	// Restore extension.js if backup exists (legacy cleanup)
 	extPath, _ := FindExtensionJS()
 	if extPath != "" && HasBackup(extPath) {
@@ -0,0 +1,99 @@
+package deployer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"claude-relay/internal/locks"
+	"claude-relay/internal/models"
+)
+
+// remoteLockDir is the lock on a remote target. mkdir is atomic, so two
+// machines deploying to the same host cannot both create it. The holder is
+// written next to it first and moved in, so holder.json is either missing
+// or complete.
+const remoteLockDir = "$HOME/.claude-relay.lock"
+
+// LockRemote takes the lock on a remote target for holder and returns the
+// function that releases it. It returns a *locks.LockedError when another
+// claude-relay, usually on another machine, holds it. Local targets need
+// no remote lock.
+//
+// A lock without a readable holder belongs to a claude-relay that has just
+// created it, or one that died before moving its holder in; it counts as
+// held until it is older than locks.StaleAfter.
+func LockRemote(ctx context.Context, target models.Target, holder models.LockHolder) (func(), error) {
+	if target.Type == models.TargetLocal {
+		return func() {}, nil
+	}
+	ctx, err := Pin(ctx, target)
+	if err != nil {
+		return nil, fmt.Errorf("lock %s: %w", target.Name, err)
+	}
+	data, err := json.Marshal(holder)
+	if err != nil {
+		return nil, err
+	}
+	staleMinutes := int(locks.StaleAfter.Minutes())
+	// Prints "acquired", or "old" or "new" for the age of the existing lock
+	// followed by its holder.
+	acquire := fmt.Sprintf(`tmp="%[1]s.$$"; printf '%%s' '%[2]s' | base64 -d > "$tmp" || exit 1
+if mkdir "%[1]s" 2>/dev/null; then mv "$tmp" "%[1]s/holder.json" && echo acquired; exit; fi
+rm -f "$tmp"
+if test -n "$(find "%[1]s" -maxdepth 0 -mmin +%[3]d 2>/dev/null)"; then echo old; else echo new; fi
+cat "%[1]s/holder.json" 2>/dev/null || true`,
+		remoteLockDir, base64.StdEncoding.EncodeToString(data), staleMinutes)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		out, err := remoteExec(ctx, target, acquire)
+		if err != nil {
+			return nil, fmt.Errorf("lock %s: %w", target.Name, err)
+		}
+		if out == "acquired" {
+			logf(ctx, "locked %s", target.Name)
+			return func() { unlockRemote(ctx, target, holder) }, nil
+		}
+		age, holderJSON, _ := strings.Cut(out, "\n")
+		var current models.LockHolder
+		var remove string
+		switch {
+		case json.Unmarshal([]byte(holderJSON), &current) == nil && validHolderID(current.ID):
+			if !locks.Stale(current) {
+				return nil, &locks.LockedError{Target: target.Name, Holder: current, Remote: true}
+			}
+			// Only if it still belongs to the same abandoned holder.
+			remove = fmt.Sprintf(`if grep -q '"id":"%s"' "%s/holder.json" 2>/dev/null; then rm -rf "%s"; fi`,
+				current.ID, remoteLockDir, remoteLockDir)
+		case age != "old":
+			return nil, &locks.LockedError{Target: target.Name, Remote: true}
+		default:
+			// Only if it is still old, not replaced by a fresh lock.
+			remove = fmt.Sprintf(`if test -n "$(find "%[1]s" -maxdepth 0 -mmin +%[2]d 2>/dev/null)"; then rm -rf "%[1]s"; fi`,
+				remoteLockDir, staleMinutes)
+		}
+		logf(ctx, "removing stale lock on %s", target.Name)
+		if _, err := remoteExec(ctx, target, remove); err != nil {
+			return nil, fmt.Errorf("remove stale lock on %s: %w", target.Name, err)
+		}
+	}
+	return nil, fmt.Errorf("lock %s: lock keeps reappearing", target.Name)
+}
+
+// validHolderID reports whether id looks like one from locks.NewHolder, so
+// it can be put in a shell command.
+func validHolderID(id string) bool {
+	return id != "" && strings.Trim(id, "0123456789abcdef") == ""
+}
+
+// unlockRemote removes the remote lock if holder still owns it. It runs even
+// when ctx was canceled, so a canceled deploy does not leave the lock behind.
+func unlockRemote(ctx context.Context, target models.Target, holder models.LockHolder) {
+	cmd := fmt.Sprintf(`if grep -q '"id":"%s"' "%s/holder.json" 2>/dev/null; then rm -rf "%s"; fi`,
+		holder.ID, remoteLockDir, remoteLockDir)
+	if _, err := remoteExec(context.WithoutCancel(ctx), target, cmd); err != nil {
+		logf(ctx, "could not release the lock on %s: %v", target.Name, err)
+	}
+}
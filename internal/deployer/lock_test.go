@@ -0,0 +1,108 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"claude-relay/internal/locks"
+	"claude-relay/internal/models"
+	"claude-relay/internal/testutil"
+)
+
+// fakeSSH puts an ssh on PATH that runs the remote command locally with HOME
+// set to the returned directory.
+func fakeSSH(t *testing.T) string {
+	return testutil.FakeCommand(t, "ssh", `HOME="$dir" exec sh -c "$2"`+"\n")
+}
+
+func TestLockRemote(t *testing.T) {
+	home := fakeSSH(t)
+	target := models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev"}
+	ctx := context.Background()
+	lockDir := filepath.Join(home, ".claude-relay.lock")
+
+	holder := locks.NewHolder("deploy", "alice")
+	unlock, err := LockRemote(ctx, target, holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written models.LockHolder
+	data, _ := os.ReadFile(filepath.Join(lockDir, "holder.json"))
+	if json.Unmarshal(data, &written) != nil || written != holder {
+		t.Errorf("holder.json = %s", data)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(home, ".claude-relay.lock.*")); len(leftovers) != 0 {
+		t.Errorf("temporary files left: %v", leftovers)
+	}
+
+	_, err = LockRemote(ctx, target, locks.NewHolder("restore", "bob"))
+	var le *locks.LockedError
+	if !errors.As(err, &le) || le.Holder.ID != holder.ID || !le.Remote {
+		t.Fatalf("second LockRemote: got %v", err)
+	}
+
+	unlock()
+	if _, err := os.Stat(lockDir); !os.IsNotExist(err) {
+		t.Errorf("lock left after unlock: %v", err)
+	}
+}
+
+func TestLockRemoteWithoutHolder(t *testing.T) {
+	home := fakeSSH(t)
+	target := models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev"}
+	ctx := context.Background()
+	lockDir := filepath.Join(home, ".claude-relay.lock")
+
+	// Just created by another machine that has not moved its holder in yet.
+	if err := os.Mkdir(lockDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LockRemote(ctx, target, locks.NewHolder("deploy", "bob"))
+	var le *locks.LockedError
+	if !errors.As(err, &le) || le.Holder.ID != "" {
+		t.Fatalf("fresh lock without holder: got %v", err)
+	}
+	if _, err := os.Stat(lockDir); err != nil {
+		t.Fatalf("fresh lock removed: %v", err)
+	}
+
+	// Left behind long ago.
+	old := time.Now().Add(-2 * locks.StaleAfter)
+	os.WriteFile(filepath.Join(lockDir, "holder.json"), []byte(`{"id":`), 0644)
+	os.Chtimes(lockDir, old, old)
+	unlock, err := LockRemote(ctx, target, locks.NewHolder("deploy", "bob"))
+	if err != nil {
+		t.Fatalf("abandoned lock without holder: %v", err)
+	}
+	unlock()
+}
+
+func TestLockRemoteTakesOverStaleHolder(t *testing.T) {
+	home := fakeSSH(t)
+	target := models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev"}
+	lockDir := filepath.Join(home, ".claude-relay.lock")
+
+	stale := locks.NewHolder("deploy", "alice")
+	stale.Host = "elsewhere"
+	stale.Since = time.Now().Add(-2 * locks.StaleAfter).UTC().Format(time.RFC3339)
+	data, _ := json.Marshal(stale)
+	os.Mkdir(lockDir, 0755)
+	os.WriteFile(filepath.Join(lockDir, "holder.json"), data, 0644)
+
+	holder := locks.NewHolder("deploy", "bob")
+	unlock, err := LockRemote(context.Background(), target, holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	var written models.LockHolder
+	data, _ = os.ReadFile(filepath.Join(lockDir, "holder.json"))
+	if json.Unmarshal(data, &written) != nil || written.ID != holder.ID {
+		t.Errorf("holder.json = %s", data)
+	}
+}
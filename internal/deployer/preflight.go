@@ -0,0 +1,113 @@
+package deployer
+
+import (
+	"fmt"
+	"strings"
+
+	"claude-relay/internal/mapping"
+	"claude-relay/internal/models"
+	"claude-relay/internal/relay"
+)
+
+// Preflight checks the models a deploy would write against the relay catalog
+// (cached, see relay.CachedModels): every mapping target, the fallback model
+// and the tier defaults as they end up in settings.json. A model the relay
+// does not serve is an error, since Claude then hangs or fails with
+// AbortError; deprecated models, tier mistakes and an expired or exhausted
+// API key (see relay.CachedAccount) are warnings.
+func Preflight(target models.Target, cfg *models.Config) *models.PreflightReport {
+	report := &models.PreflightReport{Target: target.Name, Issues: []models.PreflightIssue{}}
+	add := func(level, code, field, model, format string, args ...any) {
+		report.Issues = append(report.Issues, models.PreflightIssue{
+			Level: level, Code: code, Field: field, Model: model, Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if _, err := mapping.Compile(cfg); err != nil {
+		add(models.PreflightError, "invalid_mapping", "mapping_rules", "", "%v", err)
+	}
+
+	var byID map[string]models.RelayModel
+	catalog, err := relay.CachedModels(cfg)
+	switch {
+	case err != nil:
+		add(models.PreflightWarning, "catalog_unavailable", "base_url", "", "could not fetch the relay model list, models were not checked: %v", err)
+	case len(catalog) == 0:
+		add(models.PreflightWarning, "catalog_empty", "base_url", "", "the relay lists no models, models were not checked")
+	default:
+		report.Models = len(catalog)
+		byID = make(map[string]models.RelayModel, len(catalog))
+		for _, m := range catalog {
+			byID[m.ID] = m
+		}
+	}
+
+	// The key's balance is only known to New API / One API style relays.
+	if acct, err := relay.CachedAccount(cfg); err == nil {
+		switch {
+		case acct.Expired:
+			add(models.PreflightWarning, "key_expired", "api_key", "", "%s", relay.AccountProblem(acct))
+		case acct.Exhausted:
+			add(models.PreflightWarning, "key_exhausted", "api_key", "", "%s", relay.AccountProblem(acct))
+		}
+	}
+
+	checkModel := func(field, id string) {
+		if byID == nil || id == "" {
+			return
+		}
+		m, ok := byID[id]
+		switch {
+		case !ok:
+			add(models.PreflightError, "model_missing", field, id, "%s is not served by the relay", id)
+		case m.Deprecated:
+			add(models.PreflightWarning, "model_deprecated", field, id, "%s is deprecated", id)
+		}
+	}
+	checkTier := func(field, id, want string) {
+		got := relay.ParseModelID(id).Tier
+		if got == "" || want == "" || got == want {
+			return
+		}
+		msg := fmt.Sprintf("%s is in the %s tier but is used for %s", id, got, want)
+		if tierRank[got] > tierRank[want] {
+			msg += "; requests meant for the faster tier will be slow"
+		}
+		add(models.PreflightWarning, "tier_mismatch", field, id, "%s", msg)
+	}
+
+	for _, mm := range cfg.ModelMappings {
+		field := "model_mappings[" + mm.VSCodeID + "]"
+		checkModel(field, mm.RelayID)
+		checkTier(field, mm.RelayID, relay.ParseModelID(mm.VSCodeID).Tier)
+	}
+	for i, r := range cfg.MappingRules {
+		if !strings.Contains(r.Target, "$") {
+			checkModel(fmt.Sprintf("mapping_rules[%d]", i), r.Target)
+		}
+	}
+	checkModel("fallback_model", cfg.FallbackModel)
+
+	// Invalid rules are reported above as invalid_mapping.
+	if opus, sonnet, haiku, err := mapping.Defaults(cfg); err == nil {
+		for _, d := range []struct{ field, id, tier string }{
+			{"default_opus_model", opus, "opus"},
+			{"default_sonnet_model", sonnet, "sonnet"},
+			{"default_haiku_model", haiku, "haiku"},
+		} {
+			checkModel(d.field, d.id)
+			checkTier(d.field, d.id, d.tier)
+		}
+	}
+
+	report.OK = true
+	for _, is := range report.Issues {
+		if is.Level == models.PreflightError {
+			report.OK = false
+		}
+	}
+	return report
+}
+
+// tierRank orders tiers by cost and latency.
+var tierRank = map[string]int{"haiku": 1, "sonnet": 2, "opus": 3}
@@ -2,186 +2,384 @@ package deployer
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os/exec"
-	"sort"
 	"strings"
+	"time"
 
+	"claude-relay/internal/docker"
+	"claude-relay/internal/kube"
+	"claude-relay/internal/mapping"
 	"claude-relay/internal/models"
 )
 
+// stepTimeout bounds each command run on a target, so that a hung ssh,
+// gh codespace ssh, docker exec or kubectl exec fails the step instead of
+// blocking the deploy forever.
+const stepTimeout = 2 * time.Minute
+
+// remoteExtensionDirs are the extension directories searched on remote
+// targets: VS Code Server, Codespaces and code-server.
+var remoteExtensionDirs = []string{
+	"~/.vscode-server/extensions",
+	"~/.vscode-remote/extensions",
+	"~/.local/share/code-server/extensions",
+}
+
+// remoteFindCmd lists the newest copilot-chat dist file of that name on a
+// remote target.
+func remoteFindCmd(file string) string {
+	globs := make([]string, len(remoteExtensionDirs))
+	for i, dir := range remoteExtensionDirs {
+		globs[i] = dir + "/github.copilot-chat-*/dist/" + file
+	}
+	return "ls -t " + strings.Join(globs, " ") + " 2>/dev/null | head -1"
+}
+
 // remoteExec runs a command on the remote target and returns stdout.
-func remoteExec(target models.Target, command string) (string, error) {
+func remoteExec(ctx context.Context, target models.Target, command string) (string, error) {
+	out, err := remoteRun(ctx, target, command, nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// pinnedKey carries the container or pod an operation runs in; see Pin.
+type pinnedKey struct{}
+
+type pinned struct {
+	target string
+	name   string // docker container or kubernetes pod
+	user   string // docker exec user
+}
+
+// Pin resolves the container of a docker target, or the pod of a kubernetes
+// target, once and returns a context in which every command on that target
+// runs there. Operations pin at their start, so the lock and all steps land
+// on the same container or pod: one that goes away midway fails the
+// remaining steps rather than having them resolve to its replacement. Other
+// target types, and a target already pinned in ctx, leave ctx unchanged.
+func Pin(ctx context.Context, target models.Target) (context.Context, error) {
+	if target.Type != models.TargetDocker && target.Type != models.TargetKubernetes {
+		return ctx, nil
+	}
+	if p, ok := ctx.Value(pinnedKey{}).(pinned); ok && p.target == target.Name {
+		return ctx, nil
+	}
+	resolveCtx, cancel := context.WithTimeout(ctx, stepTimeout)
+	defer cancel()
+	p := pinned{target: target.Name}
+	var err error
+	if target.Type == models.TargetDocker {
+		p.name, p.user, err = docker.Resolve(resolveCtx, target)
+	} else {
+		p.name, err = kube.Resolve(resolveCtx, target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logf(ctx, "using %s on %s", p.name, target.Name)
+	return context.WithValue(ctx, pinnedKey{}, p), nil
+}
+
+// remoteRun runs a command on the remote target with stdin attached and
+// returns stdout untouched. Docker and kubernetes targets run in the
+// container or pod pinned in ctx, or resolve one for this command alone.
+func remoteRun(ctx context.Context, target models.Target, command string, stdin io.Reader) ([]byte, error) {
+	ctx, err := Pin(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	p, _ := ctx.Value(pinnedKey{}).(pinned)
+	stepCtx, cancel := context.WithTimeout(ctx, stepTimeout)
+	defer cancel()
 	var cmd *exec.Cmd
 
 	switch target.Type {
 	case models.TargetSSH:
-		cmd = exec.Command("ssh", target.Host, command)
+		cmd = exec.CommandContext(stepCtx, "ssh", target.Host, command)
 	case models.TargetCodespace:
-		cmd = exec.Command("gh", "codespace", "ssh", "-c", target.Host, "--", command)
+		cmd = exec.CommandContext(stepCtx, "gh", "codespace", "ssh", "-c", target.Host, "--", command)
+	case models.TargetDocker:
+		cmd = docker.Command(stepCtx, p.name, p.user, command)
+	case models.TargetKubernetes:
+		cmd = kube.Command(stepCtx, target, p.name, command)
 	default:
-		return "", fmt.Errorf("unsupported remote type: %s", target.Type)
+		return nil, fmt.Errorf("unsupported remote type: %s", target.Type)
 	}
 
 	var stdout, stderr bytes.Buffer
+	cmd.Stdin = stdin
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
+	// ssh can leave a child holding the output pipes after it is killed.
+	cmd.WaitDelay = 5 * time.Second
 
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if stepCtx.Err() != nil {
+			return nil, fmt.Errorf("no answer from %s within %s", target.Name, stepTimeout)
+		}
 		errMsg := strings.TrimSpace(stderr.String())
 		if errMsg == "" {
 			errMsg = err.Error()
 		}
-		return "", fmt.Errorf("%s", errMsg)
+		return nil, fmt.Errorf("%s", errMsg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// shellQuote quotes s as a single word for the remote shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// remoteMergeJSON merges fragment into the JSON file at path on the remote
+// target, keeping every key the fragment does not mention, and applies set
+// (if any) like mergeJSONFile does locally. path is a shell expression such
+// as "$HOME/.claude/settings.json". A file that exists but cannot be parsed
+// (e.g. JSONC with comments) is left alone and reported as an error rather
+// than overwritten.
+func remoteMergeJSON(ctx context.Context, target models.Target, path string, fragment []byte, set *managedSet, indent int) error {
+	cmd, err := mergeJSONCommand(path, fragment, set, indent)
+	if err != nil {
+		return err
 	}
-	return strings.TrimSpace(stdout.String()), nil
+	_, err = remoteExec(ctx, target, cmd)
+	return err
 }
 
-// deployRemote handles deployment to SSH or Codespace targets.
-func deployRemote(target models.Target, cfg *models.Config) error {
-	// 1. Build mappings
-	mappings := make(map[string]string)
-	for _, m := range cfg.ModelMappings {
-		mappings[m.VSCodeID] = m.RelayID
+// mergeJSONCommand builds the shell command used by remoteMergeJSON.
+func mergeJSONCommand(path string, fragment []byte, set *managedSet, indent int) (string, error) {
+	setJSON, err := json.Marshal(set)
+	if err != nil {
+		return "", err
 	}
+	script := fmt.Sprintf(`import base64,json,os,sys
+path=sys.argv[1]
+frag=json.loads(base64.b64decode('%s').decode('utf-8'))
+ms=json.loads(base64.b64decode('%s').decode('utf-8'))
+side=path+'%s'
+cur={}
+if os.path.exists(path):
+    with open(path) as f: txt=f.read()
+    if txt.strip():
+        try: cur=json.loads(txt)
+        except ValueError as e: sys.exit('cannot parse %%s: %%s' %% (path,e))
+cur.update(frag)
+if ms:
+    prev=[]
+    if os.path.exists(side):
+        with open(side) as f: prev=json.load(f)
+    ents=ms['entries'] or {}
+    names=sorted(ents)
+    p=cur
+    for k in ms['path'][:-1]:
+        if not isinstance(p.get(k),dict):
+            if not names: p=None; break
+            p[k]={}
+        p=p[k]
+    key=ms['path'][-1]
+    if p is None: pass
+    elif ms['list']:
+        if key in p or names:
+            drop=set(prev)|set(names)
+            old=p.get(key) if isinstance(p.get(key),list) else []
+            p[key]=[v for v in old if not (isinstance(v,str) and v in drop)]+names
+    elif isinstance(p.get(key),dict) or names:
+        if not isinstance(p.get(key),dict): p[key]={}
+        for n in prev: p[key].pop(n,None)
+        p[key].update(ents)
+d=os.path.dirname(path)
+if not os.path.isdir(d): os.makedirs(d)
+with open(path,'w') as f: json.dump(cur,f,indent=%d)
+if ms:
+    with open(side,'w') as f: json.dump(names,f)
+`, base64.StdEncoding.EncodeToString(fragment), base64.StdEncoding.EncodeToString(setJSON), managedSuffix, indent)
+
+	return fmt.Sprintf("python3 - \"%s\" << 'EOFCLAUDERELAY'\n%sEOFCLAUDERELAY", path, script), nil
+}
 
-	// Build JS pairs
-	var pairs []string
-	for k, v := range mappings {
-		pairs = append(pairs, fmt.Sprintf(`"%s":"%s"`, k, v))
+// cliPatchCommand builds the remote python patch for cli.js. The map JS is
+// passed base64-encoded through a quoted heredoc so that none of its quotes,
+// dollars or backslashes are touched by the remote shell.
+func cliPatchCommand(cliPath, mapJS string) string {
+	script := fmt.Sprintf(`import base64,sys
+path=sys.argv[1]
+js=base64.b64decode('%s').decode('utf-8')
+with open(path,'r') as f: c=f.read()
+marker='%s'
+if marker in c:
+    idx=c.index(marker)
+    imp=c.find('import{',idx)
+    if imp<0: imp=c.index('import ',idx)
+    c=c[:idx]+c[imp:]
+c=c.replace('import{createRequire',marker+js+'import{createRequire',1)
+c=c.replace('function Gu(A){return A.replace(/\\[(1|2)m\\]/gi,"")}','function Gu(A){return globalThis.__cliMap(A.replace(/\\[(1|2)m\\]/gi,""))}',1)
+c=c.replace('async function nH({apiKey:A,maxRetries:Q,model:B,fetchOverride:G}){let Z=','async function nH({apiKey:A,maxRetries:Q,model:B,fetchOverride:G}){B=globalThis.__cliMap(B||"");let Z=',1)
+with open(path,'w') as f: f.write(c)
+print('cli.js patched')
+`, base64.StdEncoding.EncodeToString([]byte(mapJS)), cliPatchMarker)
+
+	return fmt.Sprintf("python3 - \"%s\" << 'EOFCLAUDERELAY'\n%sEOFCLAUDERELAY", cliPath, script)
+}
+
+// deployRemote handles deployment to SSH, Codespace, docker and kubernetes
+// targets.
+func deployRemote(ctx context.Context, target models.Target, cfg *models.Config) error {
+	// 1. Compile mapping table and rules
+	mapper, err := mapping.Compile(cfg)
+	if err != nil {
+		return fmt.Errorf("compile model mappings: %w", err)
 	}
-	sort.Strings(pairs)
-	jsMap := strings.Join(pairs, ",")
 
 	// 2. Find extension.js and restore if patched (cleanup legacy patches)
 	//    NOTE: We NO LONGER patch extension.js because it affects ALL Copilot models
-	findCmd := `ls -t ~/.vscode-server/extensions/github.copilot-chat-*/dist/extension.js 2>/dev/null | head -1 || ` +
-		`ls -t ~/.vscode-remote/extensions/github.copilot-chat-*/dist/extension.js 2>/dev/null | head -1`
-	extPath, _ := remoteExec(target, findCmd)
+	findCmd := remoteFindCmd("extension.js")
+	logf(ctx, "looking for the Copilot extension on %s", target.Name)
+	extPath, _ := remoteExec(ctx, target, findCmd)
 	if extPath != "" {
 		backupPath := extPath + ".claude-relay-backup"
 		// Check if patched and backup exists, then restore
 		checkCmd := fmt.Sprintf("grep -q 'claude-relay-patch-begin' '%s' && test -f '%s' && cp '%s' '%s' && echo restored || echo skip", extPath, backupPath, backupPath, extPath)
-		remoteExec(target, checkCmd)
+		remoteExec(ctx, target, checkCmd)
 	}
 
 	// 3. Find and patch cli.js on remote
 	//    CRITICAL: cli.js is the ONLY file that should be patched.
 	//    It handles actual API calls in Agent mode and is isolated to Claude Agent.
-	findCLICmd := `ls -t ~/.vscode-server/extensions/github.copilot-chat-*/dist/cli.js 2>/dev/null | head -1 || ` +
-		`ls -t ~/.vscode-remote/extensions/github.copilot-chat-*/dist/cli.js 2>/dev/null | head -1`
-	cliPath, cliErr := remoteExec(target, findCLICmd)
+	findCLICmd := remoteFindCmd("cli.js")
+	cliPath, cliErr := remoteExec(ctx, target, findCLICmd)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if cliErr != nil || cliPath == "" {
 		return fmt.Errorf("cli.js not found on %s: %v", target.Name, cliErr)
 	}
 
 	// Create backup (only if none exists)
 	cliBackup := cliPath + ".claude-relay-backup"
-	remoteExec(target, fmt.Sprintf("test -f '%s' || cp '%s' '%s'", cliBackup, cliPath, cliBackup))
-
-	// Build globalThis model map for cli.js
-	cliMapJS := fmt.Sprintf(
-		`globalThis.__cliModelMap={%s};globalThis.__cliMap=function(m){return(globalThis.__cliModelMap[m]||m)};`,
-		jsMap,
-	)
-
-	// Inject model map at file header (before first import)
-	// Then patch known function patterns
-	cliPatchCmd := fmt.Sprintf(`python3 -c "
-import sys
-with open('%s','r') as f: c=f.read()
-marker='/* claude-relay-cli-patch */'
-# Remove old patch
-if marker in c:
-    idx=c.index(marker)
-    imp=c.index('import{',idx) if 'import{' in c[idx:] else c.index('import ',idx)
-    c=c[:idx]+c[imp:]
-# Inject new patch
-c=c.replace('import{createRequire',marker+'%s'+'import{createRequire',1)
-# Patch Gu function
-c=c.replace('function Gu(A){return A.replace(/\\\[(1|2)m\\\]/gi,\"\")}','function Gu(A){return globalThis.__cliMap(A.replace(/\\\[(1|2)m\\\]/gi,\"\"))}',1)
-# Patch nH function
-c=c.replace('async function nH({apiKey:A,maxRetries:Q,model:B,fetchOverride:G}){let Z=','async function nH({apiKey:A,maxRetries:Q,model:B,fetchOverride:G}){B=globalThis.__cliMap(B||\"\")\;let Z=',1)
-with open('%s','w') as f: f.write(c)
-print('cli.js patched')
-" 2>&1`, cliPath, cliMapJS, cliPath)
+	remoteExec(ctx, target, fmt.Sprintf("test -f '%s' || cp '%s' '%s'", cliBackup, cliPath, cliBackup))
 
-	if _, err := remoteExec(target, cliPatchCmd); err != nil {
+	// Inject model map at file header (before first import), then patch
+	// known function patterns. Pods get the local patcher's result copied in,
+	// so they need no python3 for this step.
+	logf(ctx, "patching %s", cliPath)
+	if target.Type == models.TargetKubernetes {
+		err = patchRemoteCLICopy(ctx, target, cliPath, cliBackup, mapper)
+	} else {
+		_, err = remoteExec(ctx, target, cliPatchCommand(cliPath, mapper.JS()))
+	}
+	if err != nil {
 		return fmt.Errorf("cli.js patch failed: %w", err)
 	}
 
-	// 4. Write claude settings remotely
+	// 4. Merge claude settings remotely (keys we do not manage are kept)
 	settingsJSON, err := GenerateClaudeSettingsJSON(cfg)
 	if err != nil {
 		return fmt.Errorf("generate settings: %w", err)
 	}
-	writeCmd := fmt.Sprintf("mkdir -p ~/.claude && cat > ~/.claude/settings.json << 'EOFCLAUDE'\n%s\nEOFCLAUDE", string(settingsJSON))
-	if _, err := remoteExec(target, writeCmd); err != nil {
+	if _, err := remoteExec(ctx, target, remoteSnapshotCmd(remoteClaudeSettingsPath)); err != nil {
+		return fmt.Errorf("snapshot settings: %w", err)
+	}
+	logf(ctx, "writing %s", remoteClaudeSettingsPath)
+	if err := remoteMergeJSON(ctx, target, remoteClaudeSettingsPath, settingsJSON, nil, 2); err != nil {
 		return fmt.Errorf("write settings: %w", err)
 	}
 
+	// 5. Merge VSCode Machine settings (MCP) remotely
+	vscodeValues, vscodeServers := vscodeSettings(cfg.MCPServers)
+	vscodeJSON, err := json.Marshal(vscodeValues)
+	if err != nil {
+		return fmt.Errorf("generate vscode settings: %w", err)
+	}
+	vscodePath := remoteVSCodeSettingsPath(target.Type)
+	if _, err := remoteExec(ctx, target, remoteSnapshotCmd(vscodePath)); err != nil {
+		return fmt.Errorf("snapshot vscode settings: %w", err)
+	}
+	logf(ctx, "writing %s", vscodePath)
+	if err := remoteMergeJSON(ctx, target, vscodePath, vscodeJSON, vscodeServers, 4); err != nil {
+		return fmt.Errorf("write vscode settings: %w", err)
+	}
+
 	return nil
 }
 
 // statusRemote checks deployment status on a remote target.
-func statusRemote(target models.Target) (*models.DeployStatus, error) {
+func statusRemote(ctx context.Context, target models.Target) (*models.DeployStatus, error) {
 	status := &models.DeployStatus{Target: target.Name}
 
 	// Check extension.js for legacy patch status
-	findCmd := `ls -t ~/.vscode-server/extensions/github.copilot-chat-*/dist/extension.js 2>/dev/null | head -1 || ` +
-		`ls -t ~/.vscode-remote/extensions/github.copilot-chat-*/dist/extension.js 2>/dev/null | head -1`
-	extPath, _ := remoteExec(target, findCmd)
+	findCmd := remoteFindCmd("extension.js")
+	extPath, _ := remoteExec(ctx, target, findCmd)
 	if extPath == "" {
 		status.ExtPath = "not found"
 	} else {
 		status.ExtPath = extPath
 		// Note: Patched=true here means legacy patch exists and should be cleaned up
-		out, _ := remoteExec(target, fmt.Sprintf("grep -c 'claude-relay-patch-begin' '%s' 2>/dev/null || echo 0", extPath))
-		status.Patched = out != "0"
-		out, _ = remoteExec(target, fmt.Sprintf("test -f '%s.claude-relay-backup' && echo yes || echo no", extPath))
+		out, _ := remoteExec(ctx, target, fmt.Sprintf("grep -q 'claude-relay-patch-begin' '%s' 2>/dev/null && echo yes || echo no", extPath))
+		status.Patched = out == "yes"
+		out, _ = remoteExec(ctx, target, fmt.Sprintf("test -f '%s.claude-relay-backup' && echo yes || echo no", extPath))
 		status.BackupExists = out == "yes"
 	}
 
 	// Check cli.js patch status (this is the main patch)
-	findCLICmd := `ls -t ~/.vscode-server/extensions/github.copilot-chat-*/dist/cli.js 2>/dev/null | head -1 || ` +
-		`ls -t ~/.vscode-remote/extensions/github.copilot-chat-*/dist/cli.js 2>/dev/null | head -1`
-	cliPath, _ := remoteExec(target, findCLICmd)
+	findCLICmd := remoteFindCmd("cli.js")
+	cliPath, _ := remoteExec(ctx, target, findCLICmd)
 	if cliPath != "" {
 		status.CLIPath = cliPath
-		out, _ := remoteExec(target, fmt.Sprintf("grep -c 'claude-relay-cli-patch' '%s' 2>/dev/null || echo 0", cliPath))
-		status.CLIPatched = out != "0"
-		out, _ = remoteExec(target, fmt.Sprintf("test -f '%s.claude-relay-backup' && echo yes || echo no", cliPath))
+		out, _ := remoteExec(ctx, target, fmt.Sprintf("grep -q 'claude-relay-cli-patch' '%s' 2>/dev/null && echo yes || echo no", cliPath))
+		status.CLIPatched = out == "yes"
+		out, _ = remoteExec(ctx, target, fmt.Sprintf("test -f '%s.claude-relay-backup' && echo yes || echo no", cliPath))
 		status.CLIBackupExists = out == "yes"
 	}
 
 	// Check config
-	out, _ := remoteExec(target, "test -f ~/.claude/settings.json && echo yes || echo no")
+	out, _ := remoteExec(ctx, target, "test -f ~/.claude/settings.json && echo yes || echo no")
 	status.ConfigExists = out == "yes"
 
+	out, _ = remoteExec(ctx, target, remoteSnapshotStateCmd(remoteClaudeSettingsPath))
+	status.ClaudeSettingsState = models.SettingsState(out)
+	out, _ = remoteExec(ctx, target, remoteSnapshotStateCmd(remoteVSCodeSettingsPath(target.Type)))
+	status.VSCodeSettingsState = models.SettingsState(out)
+
 	return status, nil
 }
 
 // restoreRemote restores backups on a remote target.
-func restoreRemote(target models.Target) error {
+func restoreRemote(ctx context.Context, target models.Target) error {
+	// Put settings files back to their pre-deploy snapshot
+	if _, err := remoteExec(ctx, target, remoteRestoreSnapshotCmd(remoteClaudeSettingsPath)); err != nil {
+		return fmt.Errorf("restore settings: %w", err)
+	}
+	if _, err := remoteExec(ctx, target, remoteRestoreSnapshotCmd(remoteVSCodeSettingsPath(target.Type))); err != nil {
+		return fmt.Errorf("restore vscode settings: %w", err)
+	}
+
 	// Restore extension.js if backup exists (legacy cleanup)
-	findExtCmd := `ls -t ~/.vscode-server/extensions/github.copilot-chat-*/dist/extension.js 2>/dev/null | head -1 || ` +
-		`ls -t ~/.vscode-remote/extensions/github.copilot-chat-*/dist/extension.js 2>/dev/null | head -1`
-	extPath, _ := remoteExec(target, findExtCmd)
+	findExtCmd := remoteFindCmd("extension.js")
+	extPath, _ := remoteExec(ctx, target, findExtCmd)
 	if extPath != "" {
 		backupPath := extPath + ".claude-relay-backup"
-		remoteExec(target, fmt.Sprintf("test -f '%s' && cp '%s' '%s'", backupPath, backupPath, extPath))
+		remoteExec(ctx, target, fmt.Sprintf("test -f '%s' && cp '%s' '%s'", backupPath, backupPath, extPath))
 	}
 
 	// Restore cli.js (this is the main patch)
-	findCLICmd := `ls -t ~/.vscode-server/extensions/github.copilot-chat-*/dist/cli.js 2>/dev/null | head -1 || ` +
-		`ls -t ~/.vscode-remote/extensions/github.copilot-chat-*/dist/cli.js 2>/dev/null | head -1`
-	cliPath, err := remoteExec(target, findCLICmd)
+	findCLICmd := remoteFindCmd("cli.js")
+	cliPath, err := remoteExec(ctx, target, findCLICmd)
 	if err != nil || cliPath == "" {
 		return fmt.Errorf("cli.js not found on %s", target.Name)
 	}
 
 	cliBackup := cliPath + ".claude-relay-backup"
-	if _, err := remoteExec(target, fmt.Sprintf("test -f '%s' && cp '%s' '%s'", cliBackup, cliBackup, cliPath)); err != nil {
+	if _, err := remoteExec(ctx, target, fmt.Sprintf("test -f '%s' && cp '%s' '%s'", cliBackup, cliBackup, cliPath)); err != nil {
 		return fmt.Errorf("restore cli.js failed: %w", err)
 	}
 
@@ -0,0 +1,96 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"claude-relay/internal/locks"
+	"claude-relay/internal/models"
+	"claude-relay/internal/testutil"
+)
+
+// fakeDockerExec puts a docker on PATH whose "ps" prints the container
+// named in the returned directory's current file, and whose "exec -i -u
+// user container sh -c cmd" runs cmd locally with HOME set to that
+// directory.
+func fakeDockerExec(t *testing.T) string {
+	return testutil.FakeCommand(t, "docker", `case "$1" in
+ps) cat "$dir/current" ;;
+exec) HOME="$dir" exec sh -c "$8" ;;
+*) echo "unexpected docker $*" >&2; exit 1 ;;
+esac
+`)
+}
+
+// dockerCalls sums up the docker calls logged in dir as "ps" or "exec"
+// followed by the container.
+func dockerCalls(t *testing.T, dir string) []string {
+	var calls []string
+	for _, c := range testutil.Calls(t, dir) {
+		f := strings.Fields(c)
+		if f[0] == "exec" {
+			calls = append(calls, "exec "+f[4])
+		} else {
+			calls = append(calls, f[0])
+		}
+	}
+	return calls
+}
+
+func TestPin(t *testing.T) {
+	home := fakeDockerExec(t)
+	current := filepath.Join(home, "current")
+	if err := os.WriteFile(current, []byte("first\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := models.Target{Name: "app", Type: models.TargetDocker, LocalFolder: "/src/app", User: "vscode"}
+
+	ctx, err := Pin(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := Pin(ctx, target); err != nil || again != ctx {
+		t.Errorf("second Pin: got %v", err)
+	}
+	// The devcontainer is rebuilt midway; the operation stays on the first.
+	if err := os.WriteFile(current, []byte("second\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := LockRemote(ctx, target, locks.NewHolder("deploy", "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := remoteExec(ctx, target, "true"); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	want := []string{"ps", "exec first", "exec first", "exec first"}
+	if got := dockerCalls(t, home); !reflect.DeepEqual(got, want) {
+		t.Errorf("docker calls: %q, want %q", got, want)
+	}
+
+	// Unpinned, a command resolves on its own.
+	if _, err := remoteExec(context.Background(), target, "true"); err != nil {
+		t.Fatal(err)
+	}
+	if got := dockerCalls(t, home); !reflect.DeepEqual(got[len(got)-2:], []string{"ps", "exec second"}) {
+		t.Errorf("unpinned docker calls: %q", got)
+	}
+}
+
+func TestPinNoContainer(t *testing.T) {
+	home := fakeDockerExec(t)
+	if err := os.WriteFile(filepath.Join(home, "current"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := models.Target{Name: "app", Type: models.TargetDocker, LocalFolder: "/src/app"}
+	if _, err := LockRemote(context.Background(), target, locks.NewHolder("deploy", "alice")); err == nil ||
+		err.Error() != "lock app: no running devcontainer for /src/app" {
+		t.Errorf("LockRemote: got %v", err)
+	}
+}
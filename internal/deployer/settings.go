@@ -5,17 +5,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 
+	"claude-relay/internal/mapping"
 	"claude-relay/internal/models"
 )
 
 // WriteClaudeSettings writes/updates ~/.claude/settings.json.
 func WriteClaudeSettings(cfg *models.Config) error {
-	home, err := os.UserHomeDir()
+	path, err := claudeSettingsPath()
 	if err != nil {
 		return err
 	}
-	path := filepath.Join(home, ".claude", "settings.json")
 
 	// Read existing to preserve other keys
 	existing := make(map[string]any)
@@ -24,14 +25,9 @@ func WriteClaudeSettings(cfg *models.Config) error {
 	}
 
 	// Build env block
-	env := map[string]string{
-		"ANTHROPIC_BASE_URL":             cfg.BaseURL,
-		"ANTHROPIC_API_KEY":              cfg.APIKey,
-		"ANTHROPIC_DEFAULT_OPUS_MODEL":   cfg.DefaultOpus,
-		"ANTHROPIC_DEFAULT_SONNET_MODEL": cfg.DefaultSonnet,
-		"ANTHROPIC_DEFAULT_HAIKU_MODEL":  cfg.DefaultHaiku,
-		"ANTHROPIC_SMALL_FAST_MODEL":     cfg.DefaultHaiku,
-		"API_TIMEOUT_MS":                 "3000000",
+	env, err := claudeEnv(cfg)
+	if err != nil {
+		return err
 	}
 	existing["env"] = env
 
@@ -50,6 +46,9 @@ func WriteClaudeSettings(cfg *models.Config) error {
 		existing["mcpServers"] = mcpServers
 	}
 
+	// Team-managed permissions and hooks blocks
+	setClaudeRuleBlocks(existing, cfg)
+
 	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
 		return err
 	}
@@ -60,58 +59,208 @@ func WriteClaudeSettings(cfg *models.Config) error {
 	return os.WriteFile(path, data, 0600)
 }
 
-// WriteVSCodeSettings writes MCP config to VSCode's settings.json.
-func WriteVSCodeSettings(targetType models.TargetType, mcpServers []models.MCPServer) error {
-	path := vscodeSettingsPath(targetType)
-
+// mergeJSONFile replaces the given top-level keys in the JSON file at path,
+// preserving all other keys, applies set (if any) and creates the file if
+// needed.
+func mergeJSONFile(path string, values map[string]any, set *managedSet, indent string, perm os.FileMode) error {
 	existing := make(map[string]any)
 	if data, err := os.ReadFile(path); err == nil {
-		json.Unmarshal(data, &existing)
+		if err := json.Unmarshal(data, &existing); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+	for k, v := range values {
+		existing[k] = v
+	}
+	var names []string
+	if set != nil {
+		var previous []string
+		if data, err := os.ReadFile(path + managedSuffix); err == nil {
+			if err := json.Unmarshal(data, &previous); err != nil {
+				return fmt.Errorf("parse %s: %w", path+managedSuffix, err)
+			}
+		}
+		names = set.apply(existing, previous)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(existing, "", indent)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+	if set == nil {
+		return nil
+	}
+	data, err = json.Marshal(names)
+	if err != nil {
+		return err
 	}
+	return os.WriteFile(path+managedSuffix, data, 0600)
+}
 
-	// MCP feature flag
-	existing["github.copilot.chat.cli.mcp.enabled"] = true
+// managedSuffix names the sidecar listing the entries claude-relay last
+// wrote into a settings file.
+const managedSuffix = ".claude-relay-managed"
 
-	// Build MCP servers
-	servers := make(map[string]any)
-	for _, s := range mcpServers {
-		if !s.Enabled {
-			continue
-		}
-		servers[s.Name] = map[string]any{
-			"command": s.Command,
-			"args":    s.Args,
+// managedSet is the part of a settings file claude-relay shares with the
+// user: an object of MCP servers by name at Path, or with List an array of
+// server names. The names written last time are kept in a
+// <file>.claude-relay-managed sidecar, so a deploy drops the entries the
+// relay no longer writes (disabled or removed servers) and leaves every
+// entry it never wrote alone.
+type managedSet struct {
+	Path    []string       `json:"path"`
+	Entries map[string]any `json:"entries"`
+	List    bool           `json:"list"`
+}
+
+// apply updates doc and returns the names to record in the sidecar. A
+// missing object or array is only created when there is something to put
+// in it. mergeJSONCommand does the same on remote targets.
+func (m *managedSet) apply(doc map[string]any, previous []string) []string {
+	names := make([]string, 0, len(m.Entries))
+	for name := range m.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parent := doc
+	for _, k := range m.Path[:len(m.Path)-1] {
+		child, ok := parent[k].(map[string]any)
+		if !ok {
+			if len(names) == 0 {
+				return names
+			}
+			child = make(map[string]any)
+			parent[k] = child
 		}
+		parent = child
 	}
-	if len(servers) > 0 {
-		if _, ok := existing["mcp"]; !ok {
-			existing["mcp"] = map[string]any{}
+	key := m.Path[len(m.Path)-1]
+
+	if m.List {
+		if _, ok := parent[key]; !ok && len(names) == 0 {
+			return names
 		}
-		mcpBlock, ok := existing["mcp"].(map[string]any)
-		if !ok {
-			mcpBlock = map[string]any{}
+		drop := make(map[string]bool)
+		for _, name := range previous {
+			drop[name] = true
+		}
+		for _, name := range names {
+			drop[name] = true
 		}
-		mcpBlock["servers"] = servers
-		existing["mcp"] = mcpBlock
+		list := []any{}
+		cur, _ := parent[key].([]any)
+		for _, v := range cur {
+			if s, ok := v.(string); ok && drop[s] {
+				continue
+			}
+			list = append(list, v)
+		}
+		for _, name := range names {
+			list = append(list, name)
+		}
+		parent[key] = list
+		return names
 	}
 
-	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
-		return err
+	obj, ok := parent[key].(map[string]any)
+	if !ok {
+		if len(names) == 0 {
+			return names
+		}
+		obj = make(map[string]any)
+		parent[key] = obj
+	}
+	for _, name := range previous {
+		delete(obj, name)
 	}
-	data, err := json.MarshalIndent(existing, "", "    ")
+	for name, v := range m.Entries {
+		obj[name] = v
+	}
+	return names
+}
+
+// WriteVSCodeSettings writes MCP config to VSCode's settings.json. MCP
+// servers added there by hand are kept.
+func WriteVSCodeSettings(targetType models.TargetType, mcpServers []models.MCPServer) error {
+	values, set := vscodeSettings(mcpServers)
+	return mergeJSONFile(vscodeSettingsPath(targetType), values, set, "    ", 0644)
+}
+
+// vscodeSettings returns the keys claude-relay sets in VS Code settings and
+// its share of mcp.servers.
+func vscodeSettings(mcpServers []models.MCPServer) (map[string]any, *managedSet) {
+	values := map[string]any{
+		"github.copilot.chat.cli.mcp.enabled": true,
+	}
+	return values, &managedSet{Path: []string{"mcp", "servers"}, Entries: mcpServersBlock(mcpServers)}
+}
+
+// claudeEnv builds the env block of ~/.claude/settings.json. The tier
+// defaults go through the same mapping rules as cli.js, so a default given as
+// a VS Code ID or matched by a rule ends up as the relay ID; an empty default
+// takes the fallback model. With http.mirror_env the relay proxy is passed on
+// to Claude as well; the CA bundle is not, as Node only reads
+// NODE_EXTRA_CA_CERTS at startup, before settings.json is loaded. Invalid
+// mapping rules are an error rather than silently leaving the defaults
+// unmapped.
+func claudeEnv(cfg *models.Config) (map[string]string, error) {
+	opus, sonnet, haiku, err := mapping.Defaults(cfg)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("compile model mappings: %w", err)
+	}
+	env := map[string]string{
+		"ANTHROPIC_BASE_URL":             cfg.BaseURL,
+		"ANTHROPIC_API_KEY":              cfg.APIKey,
+		"ANTHROPIC_DEFAULT_OPUS_MODEL":   opus,
+		"ANTHROPIC_DEFAULT_SONNET_MODEL": sonnet,
+		"ANTHROPIC_DEFAULT_HAIKU_MODEL":  haiku,
+		"ANTHROPIC_SMALL_FAST_MODEL":     haiku,
+		"API_TIMEOUT_MS":                 "3000000",
+	}
+	if h := cfg.HTTP; h != nil && h.MirrorEnv && h.Proxy != "" && h.Proxy != models.ProxyDirect {
+		env["HTTPS_PROXY"] = h.Proxy
+	}
+	return env, nil
+}
+
+// setClaudeRuleBlocks sets the permissions and hooks blocks when configured.
+// Unconfigured blocks are left as they are in the target file.
+func setClaudeRuleBlocks(settings map[string]any, cfg *models.Config) {
+	if cfg.Permissions != nil {
+		settings["permissions"] = cfg.Permissions
+	}
+	if len(cfg.Hooks) > 0 {
+		settings["hooks"] = cfg.Hooks
 	}
-	return os.WriteFile(path, data, 0644)
 }
 
 // ClaudeSettingsExist checks if ~/.claude/settings.json exists.
 func ClaudeSettingsExist() bool {
-	home, _ := os.UserHomeDir()
-	_, err := os.Stat(filepath.Join(home, ".claude", "settings.json"))
+	path, err := claudeSettingsPath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
 	return err == nil
 }
 
+func claudeSettingsPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".claude", "settings.json"), nil
+}
+
+// remoteClaudeSettingsPath is ~/.claude/settings.json as a shell expression.
+const remoteClaudeSettingsPath = "$HOME/.claude/settings.json"
+
 func vscodeSettingsPath(t models.TargetType) string {
 	home, _ := os.UserHomeDir()
 	switch t {
@@ -129,18 +278,28 @@ func vscodeSettingsPath(t models.TargetType) string {
 	}
 }
 
+// remoteVSCodeSettingsPath returns the VS Code Machine settings path on a
+// remote target as a shell expression (expanded by the remote shell). Pods
+// may run code-server instead of VS Code Server; its data directory wins
+// when present.
+func remoteVSCodeSettingsPath(t models.TargetType) string {
+	switch t {
+	case models.TargetCodespace:
+		return "$HOME/.vscode-remote/data/Machine/settings.json"
+	case models.TargetKubernetes:
+		return `$(if test -d "$HOME/.local/share/code-server"; then echo "$HOME/.local/share/code-server"; else echo "$HOME/.vscode-server/data"; fi)/Machine/settings.json`
+	}
+	return "$HOME/.vscode-server/data/Machine/settings.json"
+}
+
 // GenerateClaudeSettingsJSON returns the JSON bytes for remote deployment.
 func GenerateClaudeSettingsJSON(cfg *models.Config) ([]byte, error) {
+	env, err := claudeEnv(cfg)
+	if err != nil {
+		return nil, err
+	}
 	settings := map[string]any{
-		"env": map[string]string{
-			"ANTHROPIC_BASE_URL":             cfg.BaseURL,
-			"ANTHROPIC_API_KEY":              cfg.APIKey,
-			"ANTHROPIC_DEFAULT_OPUS_MODEL":   cfg.DefaultOpus,
-			"ANTHROPIC_DEFAULT_SONNET_MODEL": cfg.DefaultSonnet,
-			"ANTHROPIC_DEFAULT_HAIKU_MODEL":  cfg.DefaultHaiku,
-			"ANTHROPIC_SMALL_FAST_MODEL":     cfg.DefaultHaiku,
-			"API_TIMEOUT_MS":                 "3000000",
-		},
+		"env": env,
 	}
 
 	mcpServers := make(map[string]any)
@@ -156,33 +315,7 @@ func GenerateClaudeSettingsJSON(cfg *models.Config) ([]byte, error) {
 	if len(mcpServers) > 0 {
 		settings["mcpServers"] = mcpServers
 	}
+	setClaudeRuleBlocks(settings, cfg)
 
 	return json.MarshalIndent(settings, "", "  ")
 }
-
-// GenerateVSCodeSettingsJSON returns the MCP portion for VSCode settings.
-func GenerateVSCodeSettingsJSON(mcpServers []models.MCPServer) (string, error) {
-	servers := make(map[string]any)
-	for _, s := range mcpServers {
-		if !s.Enabled {
-			continue
-		}
-		servers[s.Name] = map[string]any{
-			"command": s.Command,
-			"args":    s.Args,
-		}
-	}
-
-	data := map[string]any{
-		"github.copilot.chat.cli.mcp.enabled": true,
-		"mcp": map[string]any{
-			"servers": servers,
-		},
-	}
-
-	b, err := json.MarshalIndent(data, "", "    ")
-	if err != nil {
-		return "", fmt.Errorf("generate vscode settings: %w", err)
-	}
-	return string(b), nil
-}
@@ -0,0 +1,188 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"claude-relay/internal/models"
+	"claude-relay/internal/testutil"
+)
+
+func TestManagedSetApply(t *testing.T) {
+	server := map[string]any{"command": "npx"}
+	tests := []struct {
+		name      string
+		doc       string
+		set       managedSet
+		previous  []string
+		want      string
+		wantNames []string
+	}{
+		{"creates the object", `{"x":1}`,
+			managedSet{Path: []string{"mcp", "servers"}, Entries: map[string]any{"fs": server}}, nil,
+			`{"x":1,"mcp":{"servers":{"fs":{"command":"npx"}}}}`, []string{"fs"}},
+		{"keeps servers added by hand", `{"mcp":{"other":true,"servers":{"mine":{},"fs":{"command":"old"}}}}`,
+			managedSet{Path: []string{"mcp", "servers"}, Entries: map[string]any{"fs": server}}, []string{"fs"},
+			`{"mcp":{"other":true,"servers":{"mine":{},"fs":{"command":"npx"}}}}`, []string{"fs"}},
+		{"drops servers no longer written", `{"mcpServers":{"mine":{},"fs":{},"git":{}}}`,
+			managedSet{Path: []string{"mcpServers"}, Entries: map[string]any{"git": server}}, []string{"fs", "git"},
+			`{"mcpServers":{"mine":{},"git":{"command":"npx"}}}`, []string{"git"}},
+		{"drops all when none are enabled", `{"mcpServers":{"mine":{},"fs":{}}}`,
+			managedSet{Path: []string{"mcpServers"}, Entries: map[string]any{}}, []string{"fs"},
+			`{"mcpServers":{"mine":{}}}`, []string{}},
+		{"creates nothing when empty", `{"x":1}`,
+			managedSet{Path: []string{"mcp", "servers"}, Entries: map[string]any{}}, nil,
+			`{"x":1}`, []string{}},
+		{"list", `{"enabledMcpjsonServers":["mine","fs","git"]}`,
+			managedSet{Path: []string{"enabledMcpjsonServers"}, Entries: map[string]any{"git": true, "db": true}, List: true}, []string{"fs", "git"},
+			`{"enabledMcpjsonServers":["mine","db","git"]}`, []string{"db", "git"}},
+		{"list emptied", `{"enabledMcpjsonServers":["fs"]}`,
+			managedSet{Path: []string{"enabledMcpjsonServers"}, Entries: map[string]any{}, List: true}, []string{"fs"},
+			`{"enabledMcpjsonServers":[]}`, []string{}},
+		{"no list created when empty", `{}`,
+			managedSet{Path: []string{"enabledMcpjsonServers"}, Entries: map[string]any{}, List: true}, nil,
+			`{}`, []string{}},
+	}
+	for _, tt := range tests {
+		var doc, want map[string]any
+		if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+			t.Fatal(err)
+		}
+		names := tt.set.apply(doc, tt.previous)
+		// Compare through JSON so typed entries match decoded ones.
+		got, _ := json.Marshal(doc)
+		wantJSON, _ := json.Marshal(want)
+		if string(got) != string(wantJSON) {
+			t.Errorf("%s: doc = %s, want %s", tt.name, got, wantJSON)
+		}
+		if !reflect.DeepEqual(names, tt.wantNames) {
+			t.Errorf("%s: names = %q, want %q", tt.name, names, tt.wantNames)
+		}
+	}
+}
+
+// readJSON decodes the JSON file at path.
+func readJSON(t *testing.T, path string) map[string]any {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return doc
+}
+
+// vscodeServerNames returns the names under mcp.servers.
+func vscodeServerNames(t *testing.T, path string) []string {
+	t.Helper()
+	mcp, _ := readJSON(t, path)["mcp"].(map[string]any)
+	servers, _ := mcp["servers"].(map[string]any)
+	names := []string{}
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestWriteVSCodeSettings(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path := vscodeSettingsPath(models.TargetSSH)
+	testutil.WriteFile(t, path, `{"editor.tabSize": 2, "mcp": {"servers": {"mine": {"command": "mine"}}}}`)
+
+	servers := []models.MCPServer{
+		{Name: "fs", Enabled: true, Command: "npx"},
+		{Name: "git", Enabled: true, Command: "uvx"},
+		{Name: "off", Command: "npx"},
+	}
+	if err := WriteVSCodeSettings(models.TargetSSH, servers); err != nil {
+		t.Fatal(err)
+	}
+	if got := vscodeServerNames(t, path); !reflect.DeepEqual(got, []string{"fs", "git", "mine"}) {
+		t.Errorf("after deploy: %q", got)
+	}
+	if doc := readJSON(t, path); doc["editor.tabSize"] != 2.0 || doc["github.copilot.chat.cli.mcp.enabled"] != true {
+		t.Errorf("other keys: %v", doc)
+	}
+
+	// Disabling a server removes it; the one added by hand stays.
+	servers[1].Enabled = false
+	if err := WriteVSCodeSettings(models.TargetSSH, servers); err != nil {
+		t.Fatal(err)
+	}
+	if got := vscodeServerNames(t, path); !reflect.DeepEqual(got, []string{"fs", "mine"}) {
+		t.Errorf("after disabling git: %q", got)
+	}
+	servers[0].Enabled = false
+	if err := WriteVSCodeSettings(models.TargetSSH, servers); err != nil {
+		t.Fatal(err)
+	}
+	if got := vscodeServerNames(t, path); !reflect.DeepEqual(got, []string{"mine"}) {
+		t.Errorf("after disabling all: %q", got)
+	}
+
+	// A file that is not plain JSON is refused, not overwritten.
+	testutil.WriteFile(t, path, "{\n  // comment\n}")
+	if err := WriteVSCodeSettings(models.TargetSSH, servers); err == nil {
+		t.Error("JSONC file overwritten")
+	}
+}
+
+func TestRemoteMergeJSON(t *testing.T) {
+	home := fakeSSH(t)
+	target := models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev"}
+	ctx := context.Background()
+	remotePath := remoteVSCodeSettingsPath(models.TargetSSH)
+	path := filepath.Join(home, ".vscode-server", "data", "Machine", "settings.json")
+	testutil.WriteFile(t, path, `{"editor.tabSize": 2, "mcp": {"servers": {"mine": {"command": "mine"}}}}`)
+
+	deploy := func(servers []models.MCPServer) {
+		t.Helper()
+		values, set := vscodeSettings(servers)
+		fragment, _ := json.Marshal(values)
+		if err := remoteMergeJSON(ctx, target, remotePath, fragment, set, 4); err != nil {
+			t.Fatal(err)
+		}
+	}
+	servers := []models.MCPServer{
+		{Name: "fs", Enabled: true, Command: "npx", Args: []string{"-y", "fs"}},
+		{Name: "git", Enabled: true, Command: "uvx"},
+	}
+	deploy(servers)
+	if got := vscodeServerNames(t, path); !reflect.DeepEqual(got, []string{"fs", "git", "mine"}) {
+		t.Errorf("after deploy: %q", got)
+	}
+	if doc := readJSON(t, path); doc["editor.tabSize"] != 2.0 || doc["github.copilot.chat.cli.mcp.enabled"] != true {
+		t.Errorf("other keys: %v", doc)
+	}
+	servers[0].Enabled, servers[1].Enabled = false, false
+	deploy(servers)
+	if got := vscodeServerNames(t, path); !reflect.DeepEqual(got, []string{"mine"}) {
+		t.Errorf("after disabling all: %q", got)
+	}
+	if data, _ := os.ReadFile(path + managedSuffix); string(data) != "[]" {
+		t.Errorf("sidecar = %s", data)
+	}
+
+	// Restoring the snapshot drops the sidecar too.
+	testutil.WriteFile(t, path+snapshotBackupSuffix, "{}")
+	if _, err := remoteExec(ctx, target, remoteRestoreSnapshotCmd(remotePath)); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{path + managedSuffix, path + snapshotBackupSuffix} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s left after restore", filepath.Base(p))
+		}
+	}
+}
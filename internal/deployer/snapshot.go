@@ -0,0 +1,124 @@
+package deployer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"claude-relay/internal/models"
+)
+
+// Settings files (as opposed to cli.js) are snapshotted before claude-relay
+// first writes to them, and put back verbatim on restore:
+//
+//	<file>.claude-relay-backup   original content of a file that existed
+//	<file>.claude-relay-created  marker for a file that did not exist yet
+//
+// The snapshot is dropped on restore so the next deploy takes a fresh one
+// (user edits made between restore and redeploy are preserved), and so is
+// the <file>.claude-relay-managed sidecar of mergeJSONFile.
+const (
+	snapshotBackupSuffix  = ".claude-relay-backup"
+	snapshotCreatedSuffix = ".claude-relay-created"
+)
+
+// snapshotFile records the original state of path unless a snapshot exists.
+func snapshotFile(path string) error {
+	if hasSnapshot(path) {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		return os.WriteFile(path+snapshotCreatedSuffix, nil, 0600)
+	}
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("snapshot %s: %w", path, err)
+	}
+	return os.WriteFile(path+snapshotBackupSuffix, data, info.Mode().Perm())
+}
+
+// restoreSnapshot puts path back into its pre-deploy state. It is a no-op
+// when no snapshot exists.
+func restoreSnapshot(path string) error {
+	backup := path + snapshotBackupSuffix
+	if info, err := os.Stat(backup); err == nil {
+		data, err := os.ReadFile(backup)
+		if err != nil {
+			return fmt.Errorf("restore %s: %w", path, err)
+		}
+		if err := os.WriteFile(path, data, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := removeIfExists(path + managedSuffix); err != nil {
+			return err
+		}
+		return os.Remove(backup)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("restore %s: %w", path, err)
+	}
+	created := path + snapshotCreatedSuffix
+	if _, err := os.Stat(created); err == nil {
+		if err := removeIfExists(path); err != nil {
+			return err
+		}
+		if err := removeIfExists(path + managedSuffix); err != nil {
+			return err
+		}
+		return os.Remove(created)
+	}
+	return nil
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func hasSnapshot(path string) bool {
+	for _, suffix := range []string{snapshotBackupSuffix, snapshotCreatedSuffix} {
+		if _, err := os.Stat(path + suffix); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotState reports whether path is managed by claude-relay.
+func snapshotState(path string) models.SettingsState {
+	if hasSnapshot(path) {
+		return models.SettingsManaged
+	}
+	if _, err := os.Stat(path); err == nil {
+		return models.SettingsOriginal
+	}
+	return models.SettingsMissing
+}
+
+// --- Remote equivalents (path is a shell expression, e.g. "$HOME/...") ---
+
+func remoteSnapshotCmd(path string) string {
+	return fmt.Sprintf(`p="%s"; test -e "$p%s" || test -e "$p%s" || `+
+		`{ if test -f "$p"; then cp -p "$p" "$p%s"; else mkdir -p "$(dirname "$p")" && : > "$p%s"; fi; }`,
+		path, snapshotBackupSuffix, snapshotCreatedSuffix, snapshotBackupSuffix, snapshotCreatedSuffix)
+}
+
+func remoteRestoreSnapshotCmd(path string) string {
+	return fmt.Sprintf(`p="%s"; if test -f "$p%s"; then cp -p "$p%s" "$p" && rm -f "$p%s" "$p%s"; `+
+		`elif test -e "$p%s"; then rm -f "$p" "$p%s" "$p%s"; fi`,
+		path, snapshotBackupSuffix, snapshotBackupSuffix, managedSuffix, snapshotBackupSuffix,
+		snapshotCreatedSuffix, managedSuffix, snapshotCreatedSuffix)
+}
+
+func remoteSnapshotStateCmd(path string) string {
+	return fmt.Sprintf(`p="%s"; if test -e "$p%s" || test -e "$p%s"; then echo %s; elif test -f "$p"; then echo %s; else echo %s; fi`,
+		path, snapshotBackupSuffix, snapshotCreatedSuffix, models.SettingsManaged, models.SettingsOriginal, models.SettingsMissing)
+}
@@ -0,0 +1,205 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"claude-relay/internal/models"
+	"claude-relay/internal/testutil"
+)
+
+// fakeCLIJS is the smallest cli.js PatchCLI accepts.
+const fakeCLIJS = `import{createRequire}from"node:module";function Gu(A){return A.replace(/\[(1|2)m\]/gi,"")}` + "\n"
+
+const userClaudeSettings = `{"theme": "dark", "env": {"ANTHROPIC_BASE_URL": "https://mine.example.com"}}`
+
+// snapshotLeftovers returns the snapshot files and sidecars left in dir.
+func snapshotLeftovers(t *testing.T, dir string) []string {
+	t.Helper()
+	var found []string
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		switch filepath.Ext(path) {
+		case snapshotBackupSuffix, snapshotCreatedSuffix, managedSuffix:
+			found = append(found, path)
+		}
+		return nil
+	})
+	return found
+}
+
+func snapshotTestConfig() *models.Config {
+	return &models.Config{
+		BaseURL:    "https://relay.example.com",
+		APIKey:     "sk-test",
+		MCPServers: []models.MCPServer{{Name: "fs", Enabled: true, Command: "npx", Args: []string{"${HOME}"}}},
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "settings.json")
+	if err := os.WriteFile(path, []byte("original"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshotState(path); got != models.SettingsOriginal {
+		t.Errorf("state before snapshot = %s", got)
+	}
+
+	if err := snapshotFile(path); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WriteFile(t, path, "deployed")
+	// A second snapshot keeps the first one.
+	if err := snapshotFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshotState(path); got != models.SettingsManaged {
+		t.Errorf("state after snapshot = %s", got)
+	}
+	testutil.WriteFile(t, path+managedSuffix, "[]")
+
+	if err := restoreSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if data, _ := os.ReadFile(path); err != nil || string(data) != "original" || info.Mode().Perm() != 0600 {
+		t.Errorf("restored %s (%v, %v)", data, info.Mode(), err)
+	}
+	if left := snapshotLeftovers(t, dir); len(left) != 0 {
+		t.Errorf("left after restore: %q", left)
+	}
+	if got := snapshotState(path); got != models.SettingsOriginal {
+		t.Errorf("state after restore = %s", got)
+	}
+	// Without a snapshot, restore leaves the file alone.
+	if err := restoreSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "original" {
+		t.Errorf("second restore changed the file: %s", data)
+	}
+}
+
+func TestSnapshotCreatedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data", "Machine", "settings.json")
+	if got := snapshotState(path); got != models.SettingsMissing {
+		t.Errorf("state before snapshot = %s", got)
+	}
+	if err := snapshotFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + snapshotCreatedSuffix); err != nil {
+		t.Fatalf("no created marker: %v", err)
+	}
+	testutil.WriteFile(t, path, "{}")
+	testutil.WriteFile(t, path+managedSuffix, "[]")
+
+	if err := restoreSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("created file left after restore: %v", err)
+	}
+	if left := snapshotLeftovers(t, dir); len(left) != 0 {
+		t.Errorf("left after restore: %q", left)
+	}
+	if got := snapshotState(path); got != models.SettingsMissing {
+		t.Errorf("state after restore = %s", got)
+	}
+}
+
+func TestDeployRestoreLocal(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	cliPath := filepath.Join(home, ".vscode", "extensions", "github.copilot-chat-0.37.0", "dist", "cli.js")
+	claudePath := filepath.Join(home, ".claude", "settings.json")
+	vscodePath := vscodeSettingsPath(models.TargetLocal)
+	testutil.WriteFile(t, cliPath, fakeCLIJS)
+	testutil.WriteFile(t, claudePath, userClaudeSettings)
+	target := models.Target{Name: "local", Type: models.TargetLocal}
+	ctx := context.Background()
+
+	if err := Deploy(ctx, target, snapshotTestConfig()); err != nil {
+		t.Fatal(err)
+	}
+	env, _ := readJSON(t, claudePath)["env"].(map[string]any)
+	if env["ANTHROPIC_BASE_URL"] != "https://relay.example.com" || readJSON(t, claudePath)["theme"] != "dark" {
+		t.Errorf("claude settings = %v", readJSON(t, claudePath))
+	}
+	if got := vscodeServerNames(t, vscodePath); len(got) != 1 || got[0] != "fs" {
+		t.Errorf("vscode servers = %q", got)
+	}
+	status, err := Status(ctx, target, &models.Config{})
+	if err != nil || status.ClaudeSettingsState != models.SettingsManaged || status.VSCodeSettingsState != models.SettingsManaged || !status.CLIPatched {
+		t.Errorf("status after deploy = %+v, %v", status, err)
+	}
+
+	if err := Restore(ctx, target); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(claudePath); string(data) != userClaudeSettings {
+		t.Errorf("claude settings after restore = %s", data)
+	}
+	if _, err := os.Stat(vscodePath); !os.IsNotExist(err) {
+		t.Errorf("vscode settings created by the deploy left after restore: %v", err)
+	}
+	if data, _ := os.ReadFile(cliPath); string(data) != fakeCLIJS {
+		t.Errorf("cli.js after restore = %s", data)
+	}
+	if left := snapshotLeftovers(t, filepath.Join(home, ".claude")); len(left) != 0 {
+		t.Errorf("left after restore: %q", left)
+	}
+	if left := snapshotLeftovers(t, filepath.Dir(vscodePath)); len(left) != 0 {
+		t.Errorf("left after restore: %q", left)
+	}
+}
+
+func TestDeployRestoreRemote(t *testing.T) {
+	home := fakeSSH(t)
+	cliPath := filepath.Join(home, ".vscode-server", "extensions", "github.copilot-chat-0.37.0", "dist", "cli.js")
+	claudePath := filepath.Join(home, ".claude", "settings.json")
+	vscodePath := filepath.Join(home, ".vscode-server", "data", "Machine", "settings.json")
+	testutil.WriteFile(t, cliPath, fakeCLIJS)
+	testutil.WriteFile(t, claudePath, userClaudeSettings)
+	target := models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev"}
+	ctx := context.Background()
+
+	if err := Deploy(ctx, target, snapshotTestConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if env, _ := readJSON(t, claudePath)["env"].(map[string]any); env["ANTHROPIC_BASE_URL"] != "https://relay.example.com" {
+		t.Errorf("claude settings = %v", readJSON(t, claudePath))
+	}
+	if got := vscodeServerNames(t, vscodePath); len(got) != 1 || got[0] != "fs" {
+		t.Errorf("vscode servers = %q", got)
+	}
+	status, err := Status(ctx, target, &models.Config{})
+	if err != nil || status.ClaudeSettingsState != models.SettingsManaged || status.VSCodeSettingsState != models.SettingsManaged || !status.CLIPatched {
+		t.Errorf("status after deploy = %+v, %v", status, err)
+	}
+
+	if err := Restore(ctx, target); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(claudePath); string(data) != userClaudeSettings {
+		t.Errorf("claude settings after restore = %s", data)
+	}
+	if _, err := os.Stat(vscodePath); !os.IsNotExist(err) {
+		t.Errorf("vscode settings created by the deploy left after restore: %v", err)
+	}
+	if data, _ := os.ReadFile(cliPath); string(data) != fakeCLIJS {
+		t.Errorf("cli.js after restore = %s", data)
+	}
+	// cli.js keeps its backup; the settings snapshots are dropped.
+	left := append(snapshotLeftovers(t, filepath.Join(home, ".claude")), snapshotLeftovers(t, filepath.Dir(vscodePath))...)
+	if len(left) != 0 {
+		t.Errorf("left after restore: %q", left)
+	}
+	status, err = Status(ctx, target, &models.Config{})
+	if err != nil || status.ClaudeSettingsState != models.SettingsOriginal || status.VSCodeSettingsState != models.SettingsMissing {
+		t.Errorf("status after restore = %+v, %v", status, err)
+	}
+}
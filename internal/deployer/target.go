@@ -0,0 +1,231 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"claude-relay/internal/models"
+)
+
+// testedTools are the commands a connection test looks for: python3 runs
+// the remote deploy scripts, the others start the usual MCP servers.
+var testedTools = []string{"python3", "node", "uvx", "npx"}
+
+// ValidateTarget checks a target definition before it is saved.
+func ValidateTarget(t models.Target) error {
+	if t.Name == "" || t.Type == "" {
+		return fmt.Errorf("name and type are required")
+	}
+	// The name appears in URLs and lock file names.
+	if strings.ContainsAny(t.Name, "/\\\n") || strings.TrimSpace(t.Name) != t.Name {
+		return fmt.Errorf("name contains unsupported characters")
+	}
+	switch t.Type {
+	case models.TargetLocal:
+		if t.Host != "" {
+			return fmt.Errorf("local targets have no host")
+		}
+	case models.TargetSSH, models.TargetCodespace:
+		if t.Host == "" {
+			return fmt.Errorf("host is required for %s targets", t.Type)
+		}
+	case models.TargetDocker:
+		if t.Host == "" && t.LocalFolder == "" {
+			return fmt.Errorf("host (container name) or local_folder is required for docker targets")
+		}
+		if t.LocalFolder != "" && !filepath.IsAbs(t.LocalFolder) {
+			return fmt.Errorf("local_folder must be absolute")
+		}
+		if strings.HasPrefix(t.User, "-") || strings.ContainsAny(t.User, " \t\n") {
+			return fmt.Errorf("user contains unsupported characters")
+		}
+	case models.TargetKubernetes:
+		if t.Host == "" && t.Selector == "" {
+			return fmt.Errorf("host (pod name) or selector is required for kubernetes targets")
+		}
+		// These are kubectl arguments too.
+		for _, f := range [][2]string{{"namespace", t.Namespace}, {"selector", t.Selector}, {"container", t.Container}, {"kube_context", t.KubeContext}} {
+			if strings.HasPrefix(f[1], "-") || strings.Contains(f[1], "\n") {
+				return fmt.Errorf("%s contains unsupported characters", f[0])
+			}
+		}
+	default:
+		return fmt.Errorf("unknown target type %q", t.Type)
+	}
+	// Only docker exec takes a user; ssh would silently log in as the
+	// default one.
+	if t.User != "" && t.Type != models.TargetDocker {
+		if t.Type == models.TargetSSH {
+			return fmt.Errorf("user is only supported for docker targets; give ssh targets a host of user@host")
+		}
+		return fmt.Errorf("user is only supported for docker targets")
+	}
+	// The host is passed to ssh, gh, docker or kubectl as an argument; keep
+	// it from being read as an option.
+	if strings.HasPrefix(t.Host, "-") || strings.ContainsAny(t.Host, " \t\n\"'`$\\;") {
+		return fmt.Errorf("host contains unsupported characters")
+	}
+	if t.Workspace != "" {
+		if !strings.HasPrefix(t.Workspace, "/") && !strings.HasPrefix(t.Workspace, "~/") {
+			return fmt.Errorf("workspace must be absolute or start with ~/")
+		}
+		if strings.ContainsAny(t.Workspace, "\"`$\\\n") {
+			return fmt.Errorf("workspace contains unsupported characters")
+		}
+	}
+	return nil
+}
+
+// TestTarget connects to a target and collects the facts a deploy depends
+// on. An unreachable target is not an error: the facts say so.
+func TestTarget(ctx context.Context, target models.Target) *models.TargetFacts {
+	facts := &models.TargetFacts{TestedAt: time.Now().UTC().Format(time.RFC3339)}
+	start := time.Now()
+	var err error
+	if target.Type == models.TargetLocal {
+		err = testLocal(ctx, facts)
+	} else {
+		err = testRemote(ctx, target, facts)
+	}
+	if err != nil {
+		facts.Error = err.Error()
+		return facts
+	}
+	facts.Reachable = true
+	facts.LatencyMS = time.Since(start).Milliseconds()
+	return facts
+}
+
+func testLocal(ctx context.Context, facts *models.TargetFacts) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	facts.OS, facts.Arch, facts.Home = runtime.GOOS, runtime.GOARCH, home
+
+	for _, name := range testedTools {
+		tool := models.ToolInfo{Name: name}
+		if path, err := exec.LookPath(name); err == nil {
+			tool.Found = true
+			stepCtx, cancel := context.WithTimeout(ctx, stepTimeout)
+			out, _ := exec.CommandContext(stepCtx, path, "--version").CombinedOutput()
+			cancel()
+			tool.Version = firstLine(string(out))
+		}
+		facts.Tools = append(facts.Tools, tool)
+	}
+
+	var dirs []string
+	for _, glob := range cliGlobs(home, false) {
+		matches, _ := filepath.Glob(strings.TrimSuffix(glob, "/dist/cli.js"))
+		dirs = append(dirs, matches...)
+	}
+	facts.CopilotChat = copilotVersions(dirs)
+	return nil
+}
+
+// testRemote gathers the facts in a single round trip.
+func testRemote(ctx context.Context, target models.Target, facts *models.TargetFacts) error {
+	script := []string{
+		`echo "os=$(uname -s)"`,
+		`echo "arch=$(uname -m)"`,
+		`echo "home=$HOME"`,
+		`for t in ` + strings.Join(testedTools, " ") + `; do if command -v $t >/dev/null 2>&1; then echo "tool:$t=$($t --version 2>&1 | head -1)"; fi; done`,
+		`for d in ` + strings.Join(remoteExtensionDirs, "/github.copilot-chat-* ") + `/github.copilot-chat-*; do if [ -d "$d" ]; then echo "copilot=$d"; fi; done`,
+	}
+	out, err := remoteExec(ctx, target, strings.Join(script, "; "))
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]string)
+	var dirs []string
+	for _, line := range strings.Split(out, "\n") {
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch {
+		case k == "os":
+			facts.OS = strings.ToLower(v)
+		case k == "arch":
+			facts.Arch = goArch(v)
+		case k == "home":
+			facts.Home = v
+		case k == "copilot":
+			dirs = append(dirs, v)
+		case strings.HasPrefix(k, "tool:"):
+			found[strings.TrimPrefix(k, "tool:")] = v
+		}
+	}
+	if facts.Home == "" {
+		return fmt.Errorf("could not determine home directory on %s", target.Name)
+	}
+	for _, name := range testedTools {
+		version, ok := found[name]
+		facts.Tools = append(facts.Tools, models.ToolInfo{Name: name, Found: ok, Version: firstLine(version)})
+	}
+	facts.CopilotChat = copilotVersions(dirs)
+	return nil
+}
+
+// goArch maps uname -m output to GOARCH names.
+func goArch(machine string) string {
+	switch machine {
+	case "x86_64":
+		return "amd64"
+	case "aarch64", "arm64":
+		return "arm64"
+	case "armv7l", "armv6l":
+		return "arm"
+	case "i386", "i686":
+		return "386"
+	}
+	return machine
+}
+
+// copilotVersions returns the copilot-chat versions of extension
+// directories, newest first and without duplicates.
+func copilotVersions(dirs []string) []string {
+	var versions []string
+	for _, d := range dirs {
+		v := strings.TrimPrefix(filepath.Base(d), "github.copilot-chat-")
+		if !slices.Contains(versions, v) {
+			versions = append(versions, v)
+		}
+	}
+	slices.SortFunc(versions, func(a, b string) int { return compareVersions(b, a) })
+	return versions
+}
+
+// compareVersions compares dotted versions numerically, ignoring anything
+// after the numbers (e.g. a platform suffix).
+func compareVersions(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x, _ = strconv.Atoi(strings.SplitN(pa[i], "-", 2)[0])
+		}
+		if i < len(pb) {
+			y, _ = strconv.Atoi(strings.SplitN(pb[i], "-", 2)[0])
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(line)
+}
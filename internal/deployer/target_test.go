@@ -0,0 +1,116 @@
+package deployer
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"claude-relay/internal/models"
+	"claude-relay/internal/testutil"
+)
+
+func TestValidateTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		target models.Target
+		want   string // error substring, empty for a valid target
+	}{
+		{"local", models.Target{Name: "local", Type: models.TargetLocal}, ""},
+		{"ssh", models.Target{Name: "dev", Type: models.TargetSSH, Host: "alice@dev.example.com"}, ""},
+		{"codespace", models.Target{Name: "cs", Type: models.TargetCodespace, Host: "fluffy-space-abc"}, ""},
+		{"docker by folder", models.Target{Name: "app", Type: models.TargetDocker, LocalFolder: "/src/app", User: "vscode"}, ""},
+		{"kubernetes", models.Target{Name: "ide", Type: models.TargetKubernetes, Selector: "app=code-server", Namespace: "dev"}, ""},
+		{"workspace", models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev", Workspace: "~/src/app"}, ""},
+
+		{"no name", models.Target{Type: models.TargetLocal}, "name and type are required"},
+		{"slash in name", models.Target{Name: "a/b", Type: models.TargetLocal}, "name contains"},
+		{"padded name", models.Target{Name: " dev", Type: models.TargetLocal}, "name contains"},
+		{"unknown type", models.Target{Name: "x", Type: "ftp"}, "unknown target type"},
+		{"local with host", models.Target{Name: "local", Type: models.TargetLocal, Host: "x"}, "local targets have no host"},
+		{"ssh without host", models.Target{Name: "dev", Type: models.TargetSSH}, "host is required"},
+		{"option as host", models.Target{Name: "dev", Type: models.TargetSSH, Host: "-oProxyCommand=x"}, "host contains"},
+		{"shell in host", models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev;id"}, "host contains"},
+		{"docker without container", models.Target{Name: "app", Type: models.TargetDocker}, "local_folder is required"},
+		{"relative folder", models.Target{Name: "app", Type: models.TargetDocker, LocalFolder: "src/app"}, "must be absolute"},
+		{"option as docker user", models.Target{Name: "app", Type: models.TargetDocker, Host: "app", User: "-u"}, "user contains"},
+		{"kubernetes without pod", models.Target{Name: "ide", Type: models.TargetKubernetes}, "selector is required"},
+		{"option as namespace", models.Target{Name: "ide", Type: models.TargetKubernetes, Host: "p", Namespace: "--all"}, "namespace contains"},
+		{"relative workspace", models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev", Workspace: "src"}, "workspace must be"},
+
+		// Only docker exec takes a user.
+		{"ssh with user", models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev", User: "alice"}, "host of user@host"},
+		{"codespace with user", models.Target{Name: "cs", Type: models.TargetCodespace, Host: "cs", User: "codespace"}, "only supported for docker"},
+		{"kubernetes with user", models.Target{Name: "ide", Type: models.TargetKubernetes, Host: "p", User: "coder"}, "only supported for docker"},
+		{"local with user", models.Target{Name: "local", Type: models.TargetLocal, User: "root"}, "only supported for docker"},
+	}
+	for _, tt := range tests {
+		err := ValidateTarget(tt.target)
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestTestTargetRemote(t *testing.T) {
+	// ssh answers as a remote host would.
+	dir := testutil.FakeCommand(t, "ssh", `cat "$dir/answer"`+"\n")
+	testutil.WriteFile(t, filepath.Join(dir, "answer"), `os=Linux
+arch=aarch64
+home=/home/alice
+tool:python3=Python 3.12.3
+tool:node=v20.11.1
+copilot=/home/alice/.vscode-server/extensions/github.copilot-chat-0.22.4
+copilot=/home/alice/.vscode-server/extensions/github.copilot-chat-0.23.1
+copilot=/home/alice/.local/share/code-server/extensions/github.copilot-chat-0.22.4
+`)
+	target := models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev"}
+
+	facts := TestTarget(context.Background(), target)
+	if !facts.Reachable || facts.Error != "" || facts.TestedAt == "" {
+		t.Fatalf("facts = %+v", facts)
+	}
+	if facts.OS != "linux" || facts.Arch != "arm64" || facts.Home != "/home/alice" {
+		t.Errorf("os, arch, home = %q, %q, %q", facts.OS, facts.Arch, facts.Home)
+	}
+	wantTools := []models.ToolInfo{
+		{Name: "python3", Found: true, Version: "Python 3.12.3"},
+		{Name: "node", Found: true, Version: "v20.11.1"},
+		{Name: "uvx"},
+		{Name: "npx"},
+	}
+	if !reflect.DeepEqual(facts.Tools, wantTools) {
+		t.Errorf("tools = %+v", facts.Tools)
+	}
+	if !reflect.DeepEqual(facts.CopilotChat, []string{"0.23.1", "0.22.4"}) {
+		t.Errorf("copilot-chat = %q", facts.CopilotChat)
+	}
+	if c := testutil.Calls(t, dir); len(c) != 1 || !strings.HasPrefix(c[0], "dev ") {
+		t.Errorf("ssh calls = %q", c)
+	}
+
+	// Unreachable targets are reported in the facts, not as an error.
+	testutil.FakeCommand(t, "ssh", "echo 'ssh: connect to host dev port 22: Connection refused' >&2; exit 255\n")
+	facts = TestTarget(context.Background(), target)
+	if facts.Reachable || facts.Error != "ssh: connect to host dev port 22: Connection refused" {
+		t.Errorf("unreachable: %+v", facts)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int // sign
+	}{
+		{"0.23.1", "0.22.4", 1},
+		{"0.9.0", "0.10.0", -1},
+		{"0.22.4-linux-x64", "0.22.4", 1},
+	}
+	for _, tt := range tests {
+		got := compareVersions(tt.a, tt.b)
+		if got > 0 && tt.want <= 0 || got < 0 && tt.want >= 0 || got == 0 && tt.want != 0 {
+			t.Errorf("compareVersions(%q, %q) = %d", tt.a, tt.b, got)
+		}
+	}
+}
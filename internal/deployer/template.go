@@ -0,0 +1,175 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"claude-relay/internal/models"
+)
+
+// Template variables usable in MCPServer.Command and MCPServer.Args:
+//
+//	${HOME}         home directory on the target
+//	${TARGET_NAME}  name of the target being deployed
+//	${WORKSPACE}    target.Workspace, or a per-type default (see below)
+//	${env:NAME}     environment variable NAME as seen on the target
+//
+// Variables are resolved per target at deploy time, so one config can carry
+// e.g. a filesystem MCP server rooted at ${HOME} for every machine.
+var templateVarRe = regexp.MustCompile(`\$\{(env:)?([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// templateVars maps variable names (HOME, env:PATH, ...) to resolved values.
+type templateVars map[string]string
+
+// expandTemplate substitutes the variables in s. An unknown variable, most
+// likely a typo, is an error rather than being written to the target as is.
+func expandTemplate(s string, vars templateVars) (string, error) {
+	var unknown string
+	out := templateVarRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := templateVarRe.FindStringSubmatch(m)
+		if v, ok := vars[sub[1]+sub[2]]; ok {
+			return v
+		}
+		if unknown == "" {
+			unknown = m
+		}
+		return m
+	})
+	if unknown != "" {
+		return "", fmt.Errorf("unknown template variable %s", unknown)
+	}
+	return out, nil
+}
+
+// templateEnvNames returns the env:NAME variables referenced by the servers.
+func templateEnvNames(servers []models.MCPServer) []string {
+	seen := make(map[string]bool)
+	var names []string
+	collect := func(s string) {
+		for _, sub := range templateVarRe.FindAllStringSubmatch(s, -1) {
+			if sub[1] == "env:" && !seen[sub[2]] {
+				seen[sub[2]] = true
+				names = append(names, sub[2])
+			}
+		}
+	}
+	for _, s := range servers {
+		collect(s.Command)
+		for _, a := range s.Args {
+			collect(a)
+		}
+	}
+	return names
+}
+
+// localTemplateVars resolves template variables on this machine.
+func localTemplateVars(target models.Target, servers []models.MCPServer) (templateVars, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	vars := templateVars{
+		"HOME":        home,
+		"TARGET_NAME": target.Name,
+		"WORKSPACE":   target.Workspace,
+	}
+	if vars["WORKSPACE"] == "" {
+		vars["WORKSPACE"] = home
+	} else if rest, ok := strings.CutPrefix(vars["WORKSPACE"], "~/"); ok {
+		vars["WORKSPACE"] = home + "/" + rest
+	}
+	for _, name := range templateEnvNames(servers) {
+		vars["env:"+name] = os.Getenv(name)
+	}
+	return vars, nil
+}
+
+// remoteTemplateVars resolves template variables on a remote target with a
+// single round trip. Codespaces default ${WORKSPACE} to the folder VS Code
+// opens ($CODESPACE_VSCODE_FOLDER); other targets default to ${HOME}.
+func remoteTemplateVars(ctx context.Context, target models.Target, servers []models.MCPServer) (templateVars, error) {
+	names := templateEnvNames(servers)
+	lines := []string{`echo "HOME=$HOME"`, `echo "CODESPACE_VSCODE_FOLDER=$CODESPACE_VSCODE_FOLDER"`}
+	for _, name := range names {
+		// name is validated by templateVarRe, so it is safe to interpolate.
+		lines = append(lines, fmt.Sprintf(`echo "env:%s=$%s"`, name, name))
+	}
+	out, err := remoteExec(ctx, target, strings.Join(lines, "; "))
+	if err != nil {
+		return nil, fmt.Errorf("resolve template variables on %s: %w", target.Name, err)
+	}
+
+	remote := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			remote[k] = v
+		}
+	}
+	if remote["HOME"] == "" {
+		return nil, fmt.Errorf("could not determine home directory on %s", target.Name)
+	}
+
+	vars := templateVars{
+		"HOME":        remote["HOME"],
+		"TARGET_NAME": target.Name,
+		"WORKSPACE":   target.Workspace,
+	}
+	if vars["WORKSPACE"] == "" {
+		vars["WORKSPACE"] = remote["HOME"]
+		if target.Type == models.TargetCodespace && remote["CODESPACE_VSCODE_FOLDER"] != "" {
+			vars["WORKSPACE"] = remote["CODESPACE_VSCODE_FOLDER"]
+		}
+	} else if rest, ok := strings.CutPrefix(vars["WORKSPACE"], "~/"); ok {
+		vars["WORKSPACE"] = remote["HOME"] + "/" + rest
+	}
+	for _, name := range names {
+		vars["env:"+name] = remote["env:"+name]
+	}
+	return vars, nil
+}
+
+// resolveTemplateVars picks local or remote resolution for the target.
+func resolveTemplateVars(ctx context.Context, target models.Target, servers []models.MCPServer) (templateVars, error) {
+	if target.Type == models.TargetLocal {
+		return localTemplateVars(target, servers)
+	}
+	return remoteTemplateVars(ctx, target, servers)
+}
+
+// resolveMCPServers returns a copy of servers with templates expanded.
+func resolveMCPServers(servers []models.MCPServer, vars templateVars) ([]models.MCPServer, error) {
+	resolved := make([]models.MCPServer, len(servers))
+	for i, s := range servers {
+		var err error
+		if s.Command, err = expandTemplate(s.Command, vars); err != nil {
+			return nil, fmt.Errorf("MCP server %s: command: %w", s.Name, err)
+		}
+		args := make([]string, len(s.Args))
+		for j, a := range s.Args {
+			if args[j], err = expandTemplate(a, vars); err != nil {
+				return nil, fmt.Errorf("MCP server %s: args[%d]: %w", s.Name, j, err)
+			}
+		}
+		s.Args = args
+		resolved[i] = s
+	}
+	return resolved, nil
+}
+
+// resolveConfig returns a shallow copy of cfg whose MCP servers are resolved
+// for the given target. The original config is left
+// untouched.
+func resolveConfig(ctx context.Context, target models.Target, cfg *models.Config) (*models.Config, templateVars, error) {
+	vars, err := resolveTemplateVars(ctx, target, cfg.MCPServers)
+	if err != nil {
+		return nil, nil, err
+	}
+	resolved := *cfg
+	if resolved.MCPServers, err = resolveMCPServers(cfg.MCPServers, vars); err != nil {
+		return nil, nil, err
+	}
+	return &resolved, vars, nil
+}
@@ -0,0 +1,101 @@
+package deployer
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"claude-relay/internal/models"
+	"claude-relay/internal/testutil"
+)
+
+func templateTestServers() []models.MCPServer {
+	return []models.MCPServer{
+		{Name: "fs", Command: "${HOME}/bin/fs", Args: []string{"--root", "${WORKSPACE}", "--name=${TARGET_NAME}"}},
+		{Name: "gh", Command: "gh-mcp", Args: []string{"${env:RELAY_TEST_TOKEN}", "${env:RELAY_TEST_TOKEN}", "$HOME"}},
+	}
+}
+
+func TestTemplateEnvNames(t *testing.T) {
+	servers := append(templateTestServers(), models.MCPServer{Name: "x", Command: "${env:RELAY_TEST_OTHER}"})
+	if got := templateEnvNames(servers); !reflect.DeepEqual(got, []string{"RELAY_TEST_TOKEN", "RELAY_TEST_OTHER"}) {
+		t.Errorf("templateEnvNames = %q", got)
+	}
+}
+
+func TestResolveConfigLocal(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("RELAY_TEST_TOKEN", "ghp_x")
+	cfg := &models.Config{MCPServers: templateTestServers()}
+	ctx := context.Background()
+
+	target := models.Target{Name: "local", Type: models.TargetLocal}
+	resolved, _, err := resolveConfig(ctx, target, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, gh := resolved.MCPServers[0], resolved.MCPServers[1]
+	if fs.Command != home+"/bin/fs" || !reflect.DeepEqual(fs.Args, []string{"--root", home, "--name=local"}) {
+		t.Errorf("fs = %+v", fs)
+	}
+	// Only ${...} is a template; a bare $HOME is passed through.
+	if !reflect.DeepEqual(gh.Args, []string{"ghp_x", "ghp_x", "$HOME"}) {
+		t.Errorf("gh args = %q", gh.Args)
+	}
+	if cfg.MCPServers[0].Command != "${HOME}/bin/fs" || cfg.MCPServers[0].Args[1] != "${WORKSPACE}" {
+		t.Errorf("original config changed: %+v", cfg.MCPServers[0])
+	}
+
+	target.Workspace = "~/src/app"
+	if resolved, _, err = resolveConfig(ctx, target, cfg); err != nil || resolved.MCPServers[0].Args[1] != home+"/src/app" {
+		t.Errorf("~/ workspace: %+v, %v", resolved, err)
+	}
+}
+
+func TestResolveConfigRemote(t *testing.T) {
+	home := fakeSSH(t)
+	t.Setenv("RELAY_TEST_TOKEN", "ghp_remote")
+	t.Setenv("CODESPACE_VSCODE_FOLDER", "/workspaces/app")
+	cfg := &models.Config{MCPServers: templateTestServers()}
+	ctx := context.Background()
+
+	target := models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev"}
+	resolved, vars, err := resolveConfig(ctx, target, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["HOME"] != home || vars["WORKSPACE"] != home || vars["env:RELAY_TEST_TOKEN"] != "ghp_remote" {
+		t.Errorf("vars = %v", vars)
+	}
+	if fs := resolved.MCPServers[0]; fs.Command != home+"/bin/fs" || fs.Args[2] != "--name=dev" {
+		t.Errorf("fs = %+v", fs)
+	}
+
+	// Codespaces default ${WORKSPACE} to the folder VS Code opens.
+	testutil.FakeCommand(t, "gh", `HOME='`+home+`' exec sh -c "$6"`+"\n")
+	target = models.Target{Name: "cs", Type: models.TargetCodespace, Host: "cs-1"}
+	if _, vars, err = resolveConfig(ctx, target, cfg); err != nil || vars["WORKSPACE"] != "/workspaces/app" {
+		t.Errorf("codespace: %v, %v", vars, err)
+	}
+	target.Workspace = "~/src/app"
+	if _, vars, err = resolveConfig(ctx, target, cfg); err != nil || vars["WORKSPACE"] != home+"/src/app" {
+		t.Errorf("~/ workspace: %v, %v", vars, err)
+	}
+}
+
+func TestUnknownTemplateVariable(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	target := models.Target{Name: "local", Type: models.TargetLocal}
+	for _, s := range []models.MCPServer{
+		{Name: "fs", Command: "${HOEM}/bin/fs"},
+		{Name: "fs", Command: "npx", Args: []string{"${HOME}", "${workspace}"}},
+	} {
+		cfg := &models.Config{MCPServers: []models.MCPServer{s}}
+		_, _, err := resolveConfig(context.Background(), target, cfg)
+		if err == nil || !strings.Contains(err.Error(), "MCP server fs") || !strings.Contains(err.Error(), "unknown template variable") {
+			t.Errorf("%+v: %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"claude-relay/internal/models"
+)
+
+// gitFileStatus classifies a project file for the API key safety check.
+type gitFileStatus string
+
+const (
+	gitNoRepo    gitFileStatus = "norepo"
+	gitTracked   gitFileStatus = "tracked"
+	gitIgnored   gitFileStatus = "ignored"
+	gitUnignored gitFileStatus = "unignored"
+)
+
+// ValidateWorkspace checks a workspace definition before it is saved.
+func ValidateWorkspace(ws models.Workspace) error {
+	if ws.Name == "" || ws.Target == "" || ws.Path == "" {
+		return fmt.Errorf("name, target and path are required")
+	}
+	if !strings.HasPrefix(ws.Path, "/") && !strings.HasPrefix(ws.Path, "~/") && !filepath.IsAbs(ws.Path) {
+		return fmt.Errorf("path must be absolute or start with ~/")
+	}
+	// The path is interpolated into remote shell commands.
+	if strings.ContainsAny(ws.Path, "\"`$\\\n") {
+		return fmt.Errorf("path contains unsupported characters")
+	}
+	switch ws.SettingsFile {
+	case "", models.ProjectSettings, models.ProjectLocalSettings:
+	default:
+		return fmt.Errorf("settings_file must be %q or %q", models.ProjectSettings, models.ProjectLocalSettings)
+	}
+	return nil
+}
+
+// DeployWorkspace writes project-level .claude/<settings file> and .mcp.json
+// into ws.Path on the target. The settings file carries the API key, so it
+// is only written when git ignores it (or the project is not a git repo).
+func DeployWorkspace(ctx context.Context, target models.Target, ws models.Workspace, cfg *models.Config) error {
+	if err := ValidateWorkspace(ws); err != nil {
+		return err
+	}
+	ctx, err := Pin(ctx, target)
+	if err != nil {
+		return err
+	}
+	settingsFile := ws.SettingsFile
+	if settingsFile == "" {
+		settingsFile = models.ProjectLocalSettings
+	}
+	settingsRel := ".claude/" + settingsFile
+
+	// ${WORKSPACE} resolves to the project directory.
+	target.Workspace = ws.Path
+	projectCfg, _, err := resolveConfig(ctx, target, WorkspaceConfig(ws, cfg))
+	if err != nil {
+		return err
+	}
+
+	env, err := claudeEnv(projectCfg)
+	if err != nil {
+		return err
+	}
+	settings := map[string]any{"env": env}
+	servers := mcpServersBlock(projectCfg.MCPServers)
+	// enabledMcpjsonServers approves the relay's servers in .mcp.json.
+	enabled := make(map[string]any, len(servers))
+	for name := range servers {
+		enabled[name] = true
+	}
+	enabledSet := &managedSet{Path: []string{"enabledMcpjsonServers"}, Entries: enabled, List: true}
+	serversSet := &managedSet{Path: []string{"mcpServers"}, Entries: servers}
+
+	if target.Type == models.TargetLocal {
+		dir := localWorkspacePath(ws.Path)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("project directory not found: %s", dir)
+		}
+		if err := checkKeySafe(localGitFileStatus(ctx, dir, settingsRel)); err != nil {
+			return fmt.Errorf("%s: %w", settingsRel, err)
+		}
+		settingsPath := filepath.Join(dir, ".claude", settingsFile)
+		mcpPath := filepath.Join(dir, ".mcp.json")
+		for _, path := range []string{settingsPath, mcpPath} {
+			if err := snapshotFile(path); err != nil {
+				return fmt.Errorf("snapshot %s: %w", path, err)
+			}
+		}
+		logf(ctx, "writing %s", settingsPath)
+		if err := mergeJSONFile(settingsPath, settings, enabledSet, "  ", 0600); err != nil {
+			return fmt.Errorf("write %s: %w", settingsRel, err)
+		}
+		logf(ctx, "writing %s", mcpPath)
+		if err := mergeJSONFile(mcpPath, nil, serversSet, "  ", 0644); err != nil {
+			return fmt.Errorf("write .mcp.json: %w", err)
+		}
+		return nil
+	}
+
+	dir := remoteWorkspacePath(ws.Path)
+	out, err := remoteExec(ctx, target, remoteGitFileStatusCmd(dir, settingsRel))
+	if err != nil {
+		return fmt.Errorf("check %s on %s: %w", settingsRel, target.Name, err)
+	}
+	if err := checkKeySafe(gitFileStatus(out), nil); err != nil {
+		return fmt.Errorf("%s: %w", settingsRel, err)
+	}
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	for _, path := range []string{dir + "/" + settingsRel, dir + "/.mcp.json"} {
+		if _, err := remoteExec(ctx, target, remoteSnapshotCmd(path)); err != nil {
+			return fmt.Errorf("snapshot %s: %w", path, err)
+		}
+	}
+	logf(ctx, "writing %s/%s", dir, settingsRel)
+	if err := remoteMergeJSON(ctx, target, dir+"/"+settingsRel, settingsJSON, enabledSet, 2); err != nil {
+		return fmt.Errorf("write %s: %w", settingsRel, err)
+	}
+	logf(ctx, "writing %s/.mcp.json", dir)
+	if err := remoteMergeJSON(ctx, target, dir+"/.mcp.json", []byte("{}"), serversSet, 2); err != nil {
+		return fmt.Errorf("write .mcp.json: %w", err)
+	}
+	return nil
+}
+
+// RestoreWorkspace puts the project files DeployWorkspace wrote back to
+// their state before the first workspace deploy.
+func RestoreWorkspace(ctx context.Context, target models.Target, ws models.Workspace) error {
+	settingsFile := ws.SettingsFile
+	if settingsFile == "" {
+		settingsFile = models.ProjectLocalSettings
+	}
+	if target.Type == models.TargetLocal {
+		dir := localWorkspacePath(ws.Path)
+		for _, path := range []string{filepath.Join(dir, ".claude", settingsFile), filepath.Join(dir, ".mcp.json")} {
+			if err := restoreSnapshot(path); err != nil {
+				return fmt.Errorf("restore %s: %w", path, err)
+			}
+		}
+		return nil
+	}
+	ctx, err := Pin(ctx, target)
+	if err != nil {
+		return err
+	}
+	dir := remoteWorkspacePath(ws.Path)
+	for _, path := range []string{dir + "/.claude/" + settingsFile, dir + "/.mcp.json"} {
+		if _, err := remoteExec(ctx, target, remoteRestoreSnapshotCmd(path)); err != nil {
+			return fmt.Errorf("restore %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// WorkspaceConfig layers the workspace overrides on top of the global config.
+// Workspace MCP servers replace global ones with the same name.
+func WorkspaceConfig(ws models.Workspace, cfg *models.Config) *models.Config {
+	out := *cfg
+	if ws.DefaultOpus != "" {
+		out.DefaultOpus = ws.DefaultOpus
+	}
+	if ws.DefaultSonnet != "" {
+		out.DefaultSonnet = ws.DefaultSonnet
+	}
+	if ws.DefaultHaiku != "" {
+		out.DefaultHaiku = ws.DefaultHaiku
+	}
+
+	override := make(map[string]models.MCPServer)
+	for _, s := range ws.MCPServers {
+		override[s.Name] = s
+	}
+	out.MCPServers = nil
+	for _, s := range cfg.MCPServers {
+		if o, ok := override[s.Name]; ok {
+			s = o
+			delete(override, s.Name)
+		}
+		out.MCPServers = append(out.MCPServers, s)
+	}
+	for _, s := range ws.MCPServers {
+		if _, ok := override[s.Name]; ok {
+			out.MCPServers = append(out.MCPServers, s)
+		}
+	}
+	return &out
+}
+
+func mcpServersBlock(servers []models.MCPServer) map[string]any {
+	block := make(map[string]any)
+	for _, s := range servers {
+		if !s.Enabled {
+			continue
+		}
+		block[s.Name] = map[string]any{
+			"command": s.Command,
+			"args":    s.Args,
+		}
+	}
+	return block
+}
+
+// checkKeySafe refuses to write secrets into a file git would commit.
+func checkKeySafe(status gitFileStatus, err error) error {
+	if err != nil {
+		return err
+	}
+	switch status {
+	case gitNoRepo, gitIgnored:
+		return nil
+	case gitTracked:
+		return fmt.Errorf("file is tracked by git; refusing to write the API key into it")
+	case gitUnignored:
+		return fmt.Errorf("file is not gitignored; refusing to write the API key (add it to .gitignore)")
+	default:
+		return fmt.Errorf("cannot determine git status: %s", status)
+	}
+}
+
+func localGitFileStatus(ctx context.Context, dir, rel string) (gitFileStatus, error) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		// Without git we cannot prove the file is ignored; only allow it
+		// when the project is clearly not a repository.
+		if _, statErr := os.Stat(filepath.Join(dir, ".git")); statErr == nil {
+			return "", fmt.Errorf("git is required to check %s", dir)
+		}
+		return gitNoRepo, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, stepTimeout)
+	defer cancel()
+	if exec.CommandContext(ctx, git, "-C", dir, "rev-parse", "--is-inside-work-tree").Run() != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return gitNoRepo, nil
+	}
+	if exec.CommandContext(ctx, git, "-C", dir, "ls-files", "--error-unmatch", rel).Run() == nil {
+		return gitTracked, nil
+	}
+	err = exec.CommandContext(ctx, git, "-C", dir, "check-ignore", "-q", rel).Run()
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return "", ctx.Err()
+	case err == nil:
+		return gitIgnored, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return gitUnignored, nil
+	default:
+		return "", fmt.Errorf("git check-ignore: %w", err)
+	}
+}
+
+func remoteGitFileStatusCmd(dir, rel string) string {
+	return fmt.Sprintf(`cd "%s" || exit 1; `+
+		`if ! command -v git >/dev/null 2>&1; then if test -e .git; then echo nogit; else echo %s; fi; exit 0; fi; `+
+		`git rev-parse --is-inside-work-tree >/dev/null 2>&1 || { echo %s; exit 0; }; `+
+		`if git ls-files --error-unmatch "%s" >/dev/null 2>&1; then echo %s; `+
+		`elif git check-ignore -q "%s"; then echo %s; else echo %s; fi`,
+		dir, gitNoRepo, gitNoRepo, rel, gitTracked, rel, gitIgnored, gitUnignored)
+}
+
+func localWorkspacePath(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, rest)
+	}
+	return path
+}
+
+func remoteWorkspacePath(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return "$HOME/" + rest
+	}
+	return path
+}
@@ -0,0 +1,144 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"claude-relay/internal/models"
+	"claude-relay/internal/testutil"
+)
+
+const userMCPJSON = `{"mcpServers": {"mine": {"command": "mine"}}}`
+
+// mcpServerNames returns the names under mcpServers in a .mcp.json.
+func mcpServerNames(t *testing.T, path string) []string {
+	t.Helper()
+	servers, _ := readJSON(t, path)["mcpServers"].(map[string]any)
+	names := []string{}
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// enabledServers returns enabledMcpjsonServers of a settings file.
+func enabledServers(t *testing.T, path string) []any {
+	t.Helper()
+	list, ok := readJSON(t, path)["enabledMcpjsonServers"].([]any)
+	if !ok {
+		t.Fatalf("%s: no enabledMcpjsonServers list", path)
+	}
+	return list
+}
+
+func workspaceTestConfig() *models.Config {
+	return &models.Config{
+		BaseURL: "https://relay.example.com",
+		APIKey:  "sk-test",
+		MCPServers: []models.MCPServer{
+			{Name: "fs", Enabled: true, Command: "npx", Args: []string{"${WORKSPACE}"}},
+			{Name: "git", Enabled: true, Command: "uvx"},
+		},
+	}
+}
+
+func TestDeployWorkspaceLocal(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	mcpPath := filepath.Join(dir, ".mcp.json")
+	settingsPath := filepath.Join(dir, ".claude", models.ProjectLocalSettings)
+	testutil.WriteFile(t, mcpPath, userMCPJSON)
+	target := models.Target{Name: "local", Type: models.TargetLocal}
+	ws := models.Workspace{Name: "app", Target: "local", Path: dir}
+	cfg := workspaceTestConfig()
+	ctx := context.Background()
+
+	if err := DeployWorkspace(ctx, target, ws, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := mcpServerNames(t, mcpPath); !reflect.DeepEqual(got, []string{"fs", "git", "mine"}) {
+		t.Errorf(".mcp.json servers = %q", got)
+	}
+	fs := readJSON(t, mcpPath)["mcpServers"].(map[string]any)["fs"].(map[string]any)
+	if args := fs["args"].([]any); args[0] != dir {
+		t.Errorf("${WORKSPACE} = %v", args[0])
+	}
+	if got := enabledServers(t, settingsPath); !reflect.DeepEqual(got, []any{"fs", "git"}) {
+		t.Errorf("enabledMcpjsonServers = %v", got)
+	}
+
+	// With no server enabled, the relay's entries go and the user's stay.
+	cfg.MCPServers[0].Enabled, cfg.MCPServers[1].Enabled = false, false
+	if err := DeployWorkspace(ctx, target, ws, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := mcpServerNames(t, mcpPath); !reflect.DeepEqual(got, []string{"mine"}) {
+		t.Errorf(".mcp.json servers after disabling = %q", got)
+	}
+	if got := enabledServers(t, settingsPath); len(got) != 0 {
+		t.Errorf("enabledMcpjsonServers after disabling = %v", got)
+	}
+
+	if err := RestoreWorkspace(ctx, target, ws); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(mcpPath); string(data) != userMCPJSON {
+		t.Errorf(".mcp.json after restore = %s", data)
+	}
+	if _, err := os.Stat(settingsPath); !os.IsNotExist(err) {
+		t.Errorf("created settings file left after restore: %v", err)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "*.claude-relay-*"))
+	more, _ := filepath.Glob(filepath.Join(dir, ".claude", "*.claude-relay-*"))
+	if len(leftovers)+len(more) != 0 {
+		t.Errorf("left after restore: %q %q", leftovers, more)
+	}
+}
+
+func TestDeployWorkspaceRemote(t *testing.T) {
+	home := fakeSSH(t)
+	dir := filepath.Join(home, "src", "app")
+	mcpPath := filepath.Join(dir, ".mcp.json")
+	settingsPath := filepath.Join(dir, ".claude", models.ProjectSettings)
+	testutil.WriteFile(t, mcpPath, userMCPJSON)
+	target := models.Target{Name: "dev", Type: models.TargetSSH, Host: "dev"}
+	ws := models.Workspace{Name: "app", Target: "dev", Path: "~/src/app", SettingsFile: models.ProjectSettings}
+	cfg := workspaceTestConfig()
+	ctx := context.Background()
+
+	if err := DeployWorkspace(ctx, target, ws, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := mcpServerNames(t, mcpPath); !reflect.DeepEqual(got, []string{"fs", "git", "mine"}) {
+		t.Errorf(".mcp.json servers = %q", got)
+	}
+	if got := enabledServers(t, settingsPath); !reflect.DeepEqual(got, []any{"fs", "git"}) {
+		t.Errorf("enabledMcpjsonServers = %v", got)
+	}
+
+	cfg.MCPServers[1].Enabled = false
+	if err := DeployWorkspace(ctx, target, ws, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := mcpServerNames(t, mcpPath); !reflect.DeepEqual(got, []string{"fs", "mine"}) {
+		t.Errorf(".mcp.json servers after disabling git = %q", got)
+	}
+	if got := enabledServers(t, settingsPath); !reflect.DeepEqual(got, []any{"fs"}) {
+		t.Errorf("enabledMcpjsonServers after disabling git = %v", got)
+	}
+
+	if err := RestoreWorkspace(ctx, target, ws); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(mcpPath); string(data) != userMCPJSON {
+		t.Errorf(".mcp.json after restore = %s", data)
+	}
+	if _, err := os.Stat(settingsPath); !os.IsNotExist(err) {
+		t.Errorf("created settings file left after restore: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Package docker finds the container behind a docker target through the
+// docker CLI. A target names its container directly, or names the project
+// folder of a devcontainer, which is found by the devcontainer.local_folder
+// label that VS Code and the devcontainer CLI put on it; that survives the
+// container being rebuilt under a new name.
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"claude-relay/internal/models"
+)
+
+const (
+	// LocalFolderLabel is the label holding a devcontainer's project folder
+	// on the host.
+	LocalFolderLabel = "devcontainer.local_folder"
+	// metadataLabel holds the devcontainer.json settings merged into the
+	// image, among them remoteUser.
+	metadataLabel = "devcontainer.metadata"
+)
+
+// dockerTimeout bounds a single docker call other than exec.
+const dockerTimeout = 30 * time.Second
+
+// List returns the running devcontainers.
+func List(ctx context.Context) ([]models.Container, error) {
+	out, err := run(ctx, "ps", "-q", "--filter", "label="+LocalFolderLabel)
+	if err != nil {
+		return nil, err
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return []models.Container{}, nil
+	}
+	return inspect(ctx, ids)
+}
+
+// Resolve returns the container to exec into for target and the user to
+// run as: target.User, else a devcontainer's remoteUser, else the image
+// default (empty).
+func Resolve(ctx context.Context, target models.Target) (container, user string, err error) {
+	container = target.Host
+	if target.LocalFolder != "" {
+		out, err := run(ctx, "ps", "-q", "--filter", "label="+LocalFolderLabel+"="+target.LocalFolder)
+		if err != nil {
+			return "", "", err
+		}
+		ids := strings.Fields(string(out))
+		if len(ids) == 0 {
+			return "", "", fmt.Errorf("no running devcontainer for %s", target.LocalFolder)
+		}
+		container = ids[0]
+	}
+	if target.User != "" {
+		return container, target.User, nil
+	}
+	list, err := inspect(ctx, []string{container})
+	if err != nil {
+		return "", "", err
+	}
+	return container, list[0].User, nil
+}
+
+// Command returns the docker exec command running a shell command in
+// container as user. Stdin is passed through.
+func Command(ctx context.Context, container, user, command string) *exec.Cmd {
+	args := []string{"exec", "-i"}
+	if user != "" {
+		args = append(args, "-u", user)
+	}
+	return exec.CommandContext(ctx, "docker", append(args, container, "sh", "-c", command)...)
+}
+
+func inspect(ctx context.Context, ids []string) ([]models.Container, error) {
+	out, err := run(ctx, append([]string{"inspect"}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	var raw []struct {
+		ID     string `json:"Id"`
+		Name   string `json:"Name"`
+		Config struct {
+			Image  string            `json:"Image"`
+			User   string            `json:"User"`
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+		State struct {
+			Status string `json:"Status"`
+		} `json:"State"`
+	}
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return nil, fmt.Errorf("parse docker inspect: %w", err)
+	}
+	list := make([]models.Container, 0, len(raw))
+	for _, c := range raw {
+		user := remoteUser(c.Config.Labels[metadataLabel])
+		if user == "" {
+			user = c.Config.User
+		}
+		list = append(list, models.Container{
+			ID:          c.ID[:min(12, len(c.ID))],
+			Name:        strings.TrimPrefix(c.Name, "/"),
+			Image:       c.Config.Image,
+			State:       c.State.Status,
+			LocalFolder: c.Config.Labels[LocalFolderLabel],
+			User:        user,
+		})
+	}
+	return list, nil
+}
+
+// remoteUser reads remoteUser from devcontainer metadata, a JSON array of
+// settings in which later entries win.
+func remoteUser(metadata string) string {
+	var entries []struct {
+		RemoteUser string `json:"remoteUser"`
+	}
+	if json.Unmarshal([]byte(metadata), &entries) != nil {
+		return ""
+	}
+	user := ""
+	for _, e := range entries {
+		if e.RemoteUser != "" {
+			user = e.RemoteUser
+		}
+	}
+	return user
+}
+
+// run runs the docker CLI and returns its stdout.
+func run(ctx context.Context, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, dockerTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("docker %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("docker %s: %w", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"claude-relay/internal/models"
+	"claude-relay/internal/testutil"
+)
+
+// fakeDocker puts a docker on PATH that prints ps for "ps" and inspect.json
+// for "inspect". It returns the directory holding those files.
+func fakeDocker(t *testing.T) string {
+	return testutil.FakeCommand(t, "docker", `case "$1" in
+ps) cat "$dir/ps" 2>/dev/null || true ;;
+inspect) cat "$dir/inspect.json" ;;
+*) echo "unexpected docker $*" >&2; exit 1 ;;
+esac
+`)
+}
+
+// inspectJSON is docker inspect output for one container with the given
+// config user and devcontainer metadata.
+func inspectJSON(user, metadata string) string {
+	return fmt.Sprintf(`[{"Id":"0123456789abcdef","Name":"/app_devcontainer","Config":{"Image":"mcr.microsoft.com/devcontainers/go","User":%q,"Labels":{"devcontainer.local_folder":"/home/alice/app","devcontainer.metadata":%q}},"State":{"Status":"running"}}]`,
+		user, metadata)
+}
+
+func TestResolveByLocalFolder(t *testing.T) {
+	dir := fakeDocker(t)
+	testutil.WriteFile(t, filepath.Join(dir, "ps"), "0123456789ab\nfedcba987654\n")
+	testutil.WriteFile(t, filepath.Join(dir, "inspect.json"), inspectJSON("root", `[{"remoteUser":"vscode"}]`))
+
+	container, user, err := Resolve(context.Background(), models.Target{Type: models.TargetDocker, LocalFolder: "/home/alice/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container != "0123456789ab" || user != "vscode" {
+		t.Errorf("Resolve = %q, %q", container, user)
+	}
+	want := []string{
+		"ps -q --filter label=devcontainer.local_folder=/home/alice/app",
+		"inspect 0123456789ab",
+	}
+	if got := testutil.Calls(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("docker calls:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestResolveByName(t *testing.T) {
+	dir := fakeDocker(t)
+	// The target's user wins without asking docker.
+	container, user, err := Resolve(context.Background(), models.Target{Type: models.TargetDocker, Host: "app", User: "node"})
+	if err != nil || container != "app" || user != "node" {
+		t.Errorf("Resolve = %q, %q, %v", container, user, err)
+	}
+	if c := testutil.Calls(t, dir); c != nil {
+		t.Errorf("docker called: %q", c)
+	}
+}
+
+func TestResolveNoContainer(t *testing.T) {
+	fakeDocker(t)
+	_, _, err := Resolve(context.Background(), models.Target{Type: models.TargetDocker, LocalFolder: "/home/alice/app"})
+	if err == nil || err.Error() != "no running devcontainer for /home/alice/app" {
+		t.Errorf("Resolve: got %v", err)
+	}
+}
+
+func TestResolveUser(t *testing.T) {
+	tests := []struct {
+		name, configUser, metadata, want string
+	}{
+		{"remoteUser", "root", `[{"remoteUser":"vscode"}]`, "vscode"},
+		// Later entries, from devcontainer.json, override the image's.
+		{"later entry wins", "root", `[{"remoteUser":"vscode"},{"id":"feature"},{"remoteUser":"alice"}]`, "alice"},
+		{"no remoteUser", "root", `[{"containerUser":"bob"}]`, "root"},
+		{"bad metadata", "root", `{`, "root"},
+		{"nothing", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := fakeDocker(t)
+			testutil.WriteFile(t, filepath.Join(dir, "inspect.json"), inspectJSON(tt.configUser, tt.metadata))
+			_, user, err := Resolve(context.Background(), models.Target{Type: models.TargetDocker, Host: "app"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user != tt.want {
+				t.Errorf("user = %q, want %q", user, tt.want)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := fakeDocker(t)
+	if list, err := List(context.Background()); err != nil || len(list) != 0 {
+		t.Errorf("no containers: got %v, %v", list, err)
+	}
+
+	testutil.WriteFile(t, filepath.Join(dir, "ps"), "0123456789ab\n")
+	testutil.WriteFile(t, filepath.Join(dir, "inspect.json"), inspectJSON("", `[{"remoteUser":"vscode"}]`))
+	list, err := List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.Container{{
+		ID:          "0123456789ab",
+		Name:        "app_devcontainer",
+		Image:       "mcr.microsoft.com/devcontainers/go",
+		State:       "running",
+		LocalFolder: "/home/alice/app",
+		User:        "vscode",
+	}}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("List = %+v", list)
+	}
+}
@@ -0,0 +1,176 @@
+// Package jobs runs long operations such as deploys in the background, so
+// that API requests return at once with a job that can be polled for its
+// state and log, or canceled.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"claude-relay/internal/models"
+)
+
+// jobTimeout bounds a whole job; the steps inside it have their own,
+// shorter timeouts. It must stay below locks.StaleAfter, since jobs hold
+// target locks without refreshing them. A variable so tests can shorten it.
+var jobTimeout = 30 * time.Minute
+
+// keepFinished is how many finished jobs are kept for polling.
+const keepFinished = 100
+
+var (
+	ErrNotFound = errors.New("job not found")
+	ErrFinished = errors.New("job already finished")
+)
+
+// Func is the work of a job. It should stop when ctx is done and report its
+// steps through logf. The returned message summarizes a success.
+type Func func(ctx context.Context, logf func(format string, args ...any)) (string, error)
+
+type job struct {
+	models.Job
+	cancel context.CancelFunc
+}
+
+var (
+	mu    sync.Mutex
+	jobs  = make(map[string]*job)
+	order []string // IDs, oldest first
+)
+
+// Start runs fn in the background as a new job described by j (kind,
+// target, user...) and returns its initial state.
+func Start(j models.Job, fn Func) models.Job {
+	timeout := jobTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	j.ID = newID()
+	j.State = models.JobRunning
+	j.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	j.Log = nil
+	entry := &job{Job: j, cancel: cancel}
+
+	mu.Lock()
+	jobs[j.ID] = entry
+	order = append(order, j.ID)
+	prune()
+	snapshot := entry.snapshot()
+	mu.Unlock()
+
+	go func() {
+		defer cancel()
+		logf := func(format string, args ...any) { appendLog(entry, fmt.Sprintf(format, args...)) }
+		msg, err := fn(ctx, logf)
+
+		mu.Lock()
+		defer mu.Unlock()
+		entry.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+		switch {
+		case err == nil:
+			entry.State = models.JobSucceeded
+			entry.Message = msg
+		case errors.Is(ctx.Err(), context.Canceled):
+			entry.State = models.JobCanceled
+			entry.Error = "canceled"
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			entry.State = models.JobFailed
+			entry.Error = fmt.Sprintf("timed out after %s", timeout)
+		default:
+			entry.State = models.JobFailed
+			entry.Error = err.Error()
+		}
+	}()
+	return snapshot
+}
+
+// Get returns the current state of a job, including its log.
+func Get(id string) (models.Job, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	entry, ok := jobs[id]
+	if !ok {
+		return models.Job{}, ErrNotFound
+	}
+	return entry.snapshot(), nil
+}
+
+// List returns every kept job, newest first, without logs.
+func List() []models.Job {
+	mu.Lock()
+	defer mu.Unlock()
+	list := make([]models.Job, 0, len(order))
+	for i := len(order) - 1; i >= 0; i-- {
+		j := jobs[order[i]].Job
+		j.Log = nil
+		list = append(list, j)
+	}
+	return list
+}
+
+// Cancel asks a running job to stop. The job reaches the canceled state
+// once its current step has been interrupted.
+func Cancel(id string) (models.Job, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	entry, ok := jobs[id]
+	if !ok {
+		return models.Job{}, ErrNotFound
+	}
+	if entry.State != models.JobRunning {
+		return entry.snapshot(), ErrFinished
+	}
+	entry.cancel()
+	entry.Log = append(entry.Log, logEntry("cancel requested"))
+	return entry.snapshot(), nil
+}
+
+func appendLog(entry *job, msg string) {
+	mu.Lock()
+	defer mu.Unlock()
+	entry.Log = append(entry.Log, logEntry(msg))
+}
+
+func logEntry(msg string) models.JobLogEntry {
+	return models.JobLogEntry{Time: time.Now().UTC().Format(time.RFC3339), Message: msg}
+}
+
+// snapshot copies the job so callers can use it without holding mu.
+func (j *job) snapshot() models.Job {
+	s := j.Job
+	s.Log = slices.Clone(j.Log)
+	return s
+}
+
+// prune drops the oldest finished jobs beyond keepFinished. Called with mu
+// held.
+func prune() {
+	finished := 0
+	for _, id := range order {
+		if jobs[id].State != models.JobRunning {
+			finished++
+		}
+	}
+	kept := order[:0]
+	for _, id := range order {
+		if finished > keepFinished && jobs[id].State != models.JobRunning {
+			delete(jobs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	order = kept
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
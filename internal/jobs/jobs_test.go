@@ -0,0 +1,163 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"claude-relay/internal/locks"
+	"claude-relay/internal/models"
+)
+
+// reset drops the jobs of earlier tests.
+func reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	clear(jobs)
+	order = nil
+}
+
+// wait polls the job until it leaves the running state.
+func wait(t *testing.T, id string) models.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j, err := Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.State != models.JobRunning {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s still running: %+v", id, j)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSucceedAndFail(t *testing.T) {
+	reset()
+	j := Start(models.Job{Kind: "deploy", Target: "dev"}, func(ctx context.Context, logf func(string, ...any)) (string, error) {
+		logf("step %d", 1)
+		return "done", nil
+	})
+	if j.State != models.JobRunning || j.ID == "" || j.Target != "dev" {
+		t.Errorf("started = %+v", j)
+	}
+	j = wait(t, j.ID)
+	if j.State != models.JobSucceeded || j.Message != "done" || j.FinishedAt == "" ||
+		len(j.Log) != 1 || j.Log[0].Message != "step 1" {
+		t.Errorf("succeeded = %+v", j)
+	}
+
+	j = Start(models.Job{}, func(ctx context.Context, logf func(string, ...any)) (string, error) {
+		return "", errors.New("cli.js not found")
+	})
+	if j = wait(t, j.ID); j.State != models.JobFailed || j.Error != "cli.js not found" {
+		t.Errorf("failed = %+v", j)
+	}
+	if _, err := Get("nope"); err != ErrNotFound {
+		t.Errorf("Get unknown: %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	reset()
+	started := make(chan struct{})
+	j := Start(models.Job{Kind: "deploy"}, func(ctx context.Context, logf func(string, ...any)) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	<-started
+
+	got, err := Cancel(j.ID)
+	if err != nil || got.State != models.JobRunning || got.Log[len(got.Log)-1].Message != "cancel requested" {
+		t.Fatalf("Cancel = %+v, %v", got, err)
+	}
+	j = wait(t, j.ID)
+	if j.State != models.JobCanceled || j.Error != "canceled" {
+		t.Errorf("canceled = %+v", j)
+	}
+
+	// A second cancel finds the job finished and leaves it alone.
+	got, err = Cancel(j.ID)
+	if err != ErrFinished || got.State != models.JobCanceled || len(got.Log) != len(j.Log) {
+		t.Errorf("second Cancel = %+v, %v", got, err)
+	}
+	if _, err := Cancel("nope"); err != ErrNotFound {
+		t.Errorf("Cancel unknown: %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	reset()
+	defer func(d time.Duration) { jobTimeout = d }(jobTimeout)
+	jobTimeout = 20 * time.Millisecond
+
+	j := Start(models.Job{}, func(ctx context.Context, logf func(string, ...any)) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	if j = wait(t, j.ID); j.State != models.JobFailed || j.Error != "timed out after 20ms" {
+		t.Errorf("timed out = %+v", j)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	reset()
+	release := make(chan struct{})
+	running := Start(models.Job{Kind: "slow"}, func(ctx context.Context, logf func(string, ...any)) (string, error) {
+		<-release
+		return "", nil
+	})
+	defer close(release)
+
+	var ids []string
+	for i := 0; i < keepFinished+5; i++ {
+		j := Start(models.Job{}, func(ctx context.Context, logf func(string, ...any)) (string, error) {
+			return "", nil
+		})
+		wait(t, j.ID)
+		ids = append(ids, j.ID)
+	}
+	// Start prunes before the new job runs, so one finished job more than
+	// keepFinished stays until the next Start.
+	last := Start(models.Job{}, func(ctx context.Context, logf func(string, ...any)) (string, error) {
+		return "", nil
+	})
+	wait(t, last.ID)
+
+	list := List()
+	if len(list) != keepFinished+2 {
+		t.Errorf("kept %d jobs, want %d", len(list), keepFinished+2)
+	}
+	if list[0].ID != last.ID || list[len(list)-1].ID != running.ID {
+		t.Errorf("order: newest %s, oldest %s", list[0].ID, list[len(list)-1].ID)
+	}
+	// The oldest finished jobs went; the running one stayed.
+	for _, id := range ids[:5] {
+		if _, err := Get(id); err != ErrNotFound {
+			t.Errorf("job %s kept", id)
+		}
+	}
+	if _, err := Get(ids[5]); err != nil {
+		t.Errorf("job %s dropped: %v", ids[5], err)
+	}
+	if j, err := Get(running.ID); err != nil || j.State != models.JobRunning {
+		t.Errorf("running job: %+v, %v", j, err)
+	}
+	for _, j := range list {
+		if j.Log != nil {
+			t.Errorf("List returned a log for %s", j.ID)
+		}
+	}
+}
+
+func TestTimeoutBelowStaleLocks(t *testing.T) {
+	if jobTimeout >= locks.StaleAfter {
+		t.Errorf("jobTimeout %s does not stay below locks.StaleAfter %s", jobTimeout, locks.StaleAfter)
+	}
+}
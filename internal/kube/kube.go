@@ -0,0 +1,86 @@
+// Package kube finds the pod behind a kubernetes target and runs commands
+// in it through the kubectl CLI, so the usual kubeconfig, contexts and
+// auth plugins apply. Any kubectl on PATH works, which lets a fake one
+// stand in for a cluster.
+package kube
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"claude-relay/internal/models"
+)
+
+// kubectlTimeout bounds a single kubectl call other than exec.
+const kubectlTimeout = 30 * time.Second
+
+// Resolve returns the pod to exec into for target: target.Host, or the
+// first running pod matching target.Selector, oldest first so that the
+// choice is stable while pods come and go.
+func Resolve(ctx context.Context, target models.Target) (string, error) {
+	if target.Host != "" {
+		return target.Host, nil
+	}
+	out, err := run(ctx, target, "get", "pods", "-l", target.Selector,
+		"--field-selector", "status.phase=Running", "--sort-by", ".metadata.creationTimestamp",
+		"-o", "jsonpath={.items[*].metadata.name}")
+	if err != nil {
+		return "", err
+	}
+	pods := strings.Fields(string(out))
+	if len(pods) == 0 {
+		return "", fmt.Errorf("no running pod matches %s in %s", target.Selector, namespace(target))
+	}
+	return pods[0], nil
+}
+
+// Command returns the kubectl exec command running a shell command in pod.
+// Stdin is passed through, so the command can read a tar stream.
+func Command(ctx context.Context, target models.Target, pod, command string) *exec.Cmd {
+	args := append(globalArgs(target), "exec", "-i", pod)
+	if target.Container != "" {
+		args = append(args, "-c", target.Container)
+	}
+	return exec.CommandContext(ctx, "kubectl", append(args, "--", "sh", "-c", command)...)
+}
+
+// globalArgs selects the context and namespace of target.
+func globalArgs(target models.Target) []string {
+	var args []string
+	if target.KubeContext != "" {
+		args = append(args, "--context", target.KubeContext)
+	}
+	if target.Namespace != "" {
+		args = append(args, "-n", target.Namespace)
+	}
+	return args
+}
+
+// namespace describes the namespace of target for messages.
+func namespace(target models.Target) string {
+	if target.Namespace == "" {
+		return "the current namespace"
+	}
+	return "namespace " + target.Namespace
+}
+
+// run runs kubectl for target and returns its stdout.
+func run(ctx context.Context, target models.Target, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, kubectlTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "kubectl", append(globalArgs(target), args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("kubectl %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("kubectl %s: %w", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
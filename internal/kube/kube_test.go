@@ -0,0 +1,92 @@
+package kube
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"claude-relay/internal/models"
+	"claude-relay/internal/testutil"
+)
+
+// fakeKubectl puts a kubectl on PATH that prints the returned directory's
+// pods file, or fails with its fail file.
+func fakeKubectl(t *testing.T) string {
+	return testutil.FakeCommand(t, "kubectl", `if [ -f "$dir/fail" ]; then cat "$dir/fail" >&2; exit 1; fi
+cat "$dir/pods" 2>/dev/null || true
+`)
+}
+
+func TestResolve(t *testing.T) {
+	dir := fakeKubectl(t)
+	testutil.WriteFile(t, filepath.Join(dir, "pods"), "code-server-7d9f-abcde code-server-7d9f-fghij")
+	target := models.Target{Type: models.TargetKubernetes, Selector: "app=code-server", Namespace: "dev", KubeContext: "staging"}
+
+	pod, err := Resolve(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod != "code-server-7d9f-abcde" {
+		t.Errorf("pod = %q", pod)
+	}
+	want := []string{"--context staging -n dev get pods -l app=code-server --field-selector status.phase=Running" +
+		" --sort-by .metadata.creationTimestamp -o jsonpath={.items[*].metadata.name}"}
+	if got := testutil.Calls(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("kubectl called with\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestResolveByName(t *testing.T) {
+	dir := fakeKubectl(t)
+	pod, err := Resolve(context.Background(), models.Target{Type: models.TargetKubernetes, Host: "code-server-0"})
+	if err != nil || pod != "code-server-0" {
+		t.Errorf("Resolve = %q, %v", pod, err)
+	}
+	if c := testutil.Calls(t, dir); c != nil {
+		t.Errorf("kubectl called for a named pod: %q", c)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	dir := fakeKubectl(t)
+	target := models.Target{Type: models.TargetKubernetes, Selector: "app=code-server"}
+	if _, err := Resolve(context.Background(), target); err == nil ||
+		err.Error() != "no running pod matches app=code-server in the current namespace" {
+		t.Errorf("no pods: got %v", err)
+	}
+	target.Namespace = "dev"
+	if _, err := Resolve(context.Background(), target); err == nil || !strings.HasSuffix(err.Error(), "in namespace dev") {
+		t.Errorf("no pods in namespace: got %v", err)
+	}
+
+	testutil.WriteFile(t, filepath.Join(dir, "fail"), `error: context "staging" does not exist`)
+	if _, err := Resolve(context.Background(), target); err == nil ||
+		err.Error() != `kubectl get: error: context "staging" does not exist` {
+		t.Errorf("kubectl failure: got %v", err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		target models.Target
+		want   []string
+	}{
+		{"defaults", models.Target{},
+			[]string{"kubectl", "exec", "-i", "pod-1", "--", "sh", "-c", "echo hi"}},
+		{"everything", models.Target{KubeContext: "staging", Namespace: "dev", Container: "ide"},
+			[]string{"kubectl", "--context", "staging", "-n", "dev", "exec", "-i", "pod-1", "-c", "ide", "--", "sh", "-c", "echo hi"}},
+		{"container only", models.Target{Container: "ide"},
+			[]string{"kubectl", "exec", "-i", "pod-1", "-c", "ide", "--", "sh", "-c", "echo hi"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := Command(context.Background(), tt.target, "pod-1", "echo hi")
+			if !reflect.DeepEqual(cmd.Args, tt.want) {
+				t.Errorf("args = %q\nwant %q", cmd.Args, tt.want)
+			}
+		})
+	}
+}
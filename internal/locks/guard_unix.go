@@ -0,0 +1,25 @@
+//go:build !windows
+
+package locks
+
+import (
+	"os"
+	"syscall"
+)
+
+// guard holds an exclusive flock on path until the returned function is
+// called. The kernel drops it if the process dies.
+func guard(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
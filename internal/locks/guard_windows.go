@@ -0,0 +1,37 @@
+package locks
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// errorSharingViolation is ERROR_SHARING_VIOLATION, which package syscall
+// does not define.
+const errorSharingViolation syscall.Errno = 32
+
+// guardTimeout bounds the wait for another process's guard; it is only held
+// while a lock file is checked and replaced.
+const guardTimeout = 10 * time.Second
+
+// guard opens path without sharing until the returned function is called.
+// Windows closes the handle, and so releases the guard, if the process dies.
+func guard(path string) (func(), error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(guardTimeout)
+	for {
+		h, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+			syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+		if err == nil {
+			return func() { syscall.CloseHandle(h) }, nil
+		}
+		if !errors.Is(err, errorSharingViolation) || time.Now().After(deadline) {
+			return nil, fmt.Errorf("open %s: %w", path, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
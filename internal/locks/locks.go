@@ -0,0 +1,182 @@
+// Package locks keeps two operations from changing the same target at
+// once. A lock is a file in ~/.claude-relay/locks created exclusively, so it
+// holds across every claude-relay process on this machine; remote targets
+// are additionally locked on the target itself (see deployer.LockRemote).
+// Lock files are only checked and replaced under an OS-level guard on the
+// directory, so two processes cannot both take over the same stale lock.
+package locks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"claude-relay/internal/config"
+	"claude-relay/internal/models"
+)
+
+// StaleAfter is the age after which a lock is assumed abandoned, e.g. by a
+// machine that went away mid-deploy. Holders do not refresh their lock, so
+// it must outlast the longest holder: jobs are bounded by the 30-minute job
+// timeout and restore or asset sync requests by the server's
+// lockedRequestTimeout. Raise it together with either.
+const StaleAfter = time.Hour
+
+// LockedError reports that a target is held by someone else.
+type LockedError struct {
+	Target string
+	Holder models.LockHolder
+	Remote bool // the lock on the target itself, taken from another machine
+}
+
+func (e *LockedError) Error() string {
+	where := ""
+	if e.Remote {
+		where = " on the target"
+	}
+	if e.Holder.ID == "" {
+		return fmt.Sprintf("target %s is locked%s by a claude-relay that has not recorded itself yet", e.Target, where)
+	}
+	return fmt.Sprintf("target %s is locked%s: %s by %s (%s, pid %d) since %s",
+		e.Target, where, e.Holder.Op, e.Holder.User, e.Holder.Host, e.Holder.PID, e.Holder.Since)
+}
+
+// Lock is a held target lock.
+type Lock struct {
+	Holder models.LockHolder
+	path   string
+	once   sync.Once
+}
+
+// mu orders acquisitions within this process; the guard file orders them
+// across processes.
+var mu sync.Mutex
+
+// guardFile is the file in the locks directory held while a lock file is
+// checked and replaced.
+const guardFile = ".guard"
+
+// NewHolder describes an operation about to take a lock.
+func NewHolder(op, user string) models.LockHolder {
+	host, _ := os.Hostname()
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return models.LockHolder{
+		ID:    hex.EncodeToString(b),
+		Op:    op,
+		User:  user,
+		Host:  host,
+		PID:   os.Getpid(),
+		Since: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// Acquire locks target for holder, or returns a *LockedError naming the
+// current holder. Stale locks are taken over.
+func Acquire(target string, holder models.LockHolder) (*Lock, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	dir := config.LocksDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, url.PathEscape(target)+".lock")
+	data, err := json.Marshal(holder)
+	if err != nil {
+		return nil, err
+	}
+	// The holder is written to a temporary file first and then linked into
+	// place, so a lock file never exists without its contents.
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	unguard, err := guard(filepath.Join(dir, guardFile))
+	if err != nil {
+		return nil, err
+	}
+	defer unguard()
+
+	err = os.Link(tmp.Name(), path)
+	if err == nil {
+		return &Lock{Holder: holder, path: path}, nil
+	}
+	if !errors.Is(err, fs.ErrExist) {
+		return nil, err
+	}
+	current, err := readHolder(path)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && !Stale(*current) {
+		return nil, &LockedError{Target: target, Holder: *current}
+	}
+	// Abandoned or corrupt: replace it. Nobody else can change it while we
+	// hold the guard.
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	return &Lock{Holder: holder, path: path}, nil
+}
+
+// Release gives the lock up. It is safe to call more than once.
+func (l *Lock) Release() {
+	l.once.Do(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		unguard, err := guard(filepath.Join(filepath.Dir(l.path), guardFile))
+		if err != nil {
+			return
+		}
+		defer unguard()
+		if current, _ := readHolder(l.path); current != nil && current.ID == l.Holder.ID {
+			os.Remove(l.path)
+		}
+	})
+}
+
+// Stale reports whether a lock has been abandoned: its process on this
+// machine has exited, or it is older than StaleAfter.
+func Stale(h models.LockHolder) bool {
+	if since, err := time.Parse(time.RFC3339, h.Since); err != nil || time.Since(since) > StaleAfter {
+		return true
+	}
+	host, _ := os.Hostname()
+	return h.Host == host && !processAlive(h.PID)
+}
+
+// readHolder reads a lock file; a nil holder means it could not be parsed.
+func readHolder(path string) (*models.LockHolder, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var h models.LockHolder
+	if json.Unmarshal(data, &h) != nil {
+		return nil, nil
+	}
+	return &h, nil
+}
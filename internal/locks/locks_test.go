@@ -0,0 +1,149 @@
+package locks
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"claude-relay/internal/config"
+	"claude-relay/internal/models"
+)
+
+func setupHome(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	config.Init()
+}
+
+func writeHolder(t *testing.T, target string, h models.LockHolder) {
+	t.Helper()
+	if err := os.MkdirAll(config.LocksDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(h)
+	if err := os.WriteFile(filepath.Join(config.LocksDir(), target+".lock"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireRelease(t *testing.T) {
+	setupHome(t)
+	first, err := Acquire("dev", NewHolder("deploy", "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Acquire("dev", NewHolder("restore", "bob"))
+	var le *LockedError
+	if !errors.As(err, &le) || le.Holder.ID != first.Holder.ID || le.Remote {
+		t.Fatalf("second Acquire: got %v", err)
+	}
+	// Other targets are independent.
+	other, err := Acquire("prod", NewHolder("deploy", "bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Release()
+
+	first.Release()
+	first.Release()
+	again, err := Acquire("dev", NewHolder("restore", "bob"))
+	if err != nil {
+		t.Fatalf("after Release: %v", err)
+	}
+	again.Release()
+}
+
+func TestAcquireTakesOverStaleLocks(t *testing.T) {
+	setupHome(t)
+	old := NewHolder("deploy", "alice")
+	old.Since = time.Now().Add(-2 * StaleAfter).UTC().Format(time.RFC3339)
+	writeHolder(t, "old", old)
+
+	dead := NewHolder("deploy", "alice")
+	dead.PID = 1 << 30
+	writeHolder(t, "dead", dead)
+
+	if err := os.WriteFile(filepath.Join(config.LocksDir(), "corrupt.lock"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"old", "dead", "corrupt"} {
+		l, err := Acquire(target, NewHolder("deploy", "bob"))
+		if err != nil {
+			t.Errorf("%s: %v", target, err)
+			continue
+		}
+		// The old holder's Release must not remove the new lock.
+		(&Lock{Holder: old, path: l.path}).Release()
+		if h, _ := readHolder(l.path); h == nil || h.ID != l.Holder.ID {
+			t.Errorf("%s: lock file holds %+v", target, h)
+		}
+		l.Release()
+	}
+}
+
+// TestAcquireStaleRace takes over one stale lock from many goroutines at
+// once; exactly one wins.
+func TestAcquireStaleRace(t *testing.T) {
+	setupHome(t)
+	old := NewHolder("deploy", "alice")
+	old.Since = time.Now().Add(-2 * StaleAfter).UTC().Format(time.RFC3339)
+	writeHolder(t, "dev", old)
+
+	var (
+		wg   sync.WaitGroup
+		won  sync.Map
+		wins int
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, err := Acquire("dev", NewHolder("deploy", "bob"))
+			if err == nil {
+				won.Store(l.Holder.ID, l)
+			}
+		}()
+	}
+	wg.Wait()
+	won.Range(func(_, _ any) bool { wins++; return true })
+	if wins != 1 {
+		t.Errorf("%d goroutines took the lock, want 1", wins)
+	}
+}
+
+func TestGuardExcludes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), guardFile)
+	unguard, err := guard(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		second, err := guard(path)
+		if err != nil {
+			t.Error(err)
+		} else {
+			second()
+		}
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("guard taken twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unguard()
+	<-acquired
+}
+
+func TestLockedErrorWithoutHolder(t *testing.T) {
+	err := &LockedError{Target: "dev", Remote: true}
+	if got := err.Error(); got != "target dev is locked on the target by a claude-relay that has not recorded itself yet" {
+		t.Errorf("Error() = %q", got)
+	}
+}
@@ -0,0 +1,14 @@
+package locks
+
+import "os"
+
+// processAlive reports whether a process with the given PID exists; on
+// Windows FindProcess fails for processes that have exited.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,265 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"claude-relay/internal/models"
+)
+
+// Mapper resolves VS Code model IDs to relay IDs. Resolution order:
+//
+//  1. exact ModelMappings entries
+//  2. MappingRules by descending priority (ties keep config order)
+//  3. IDs that are already relay targets are kept: mapping values, literal
+//     rule targets, the fallback and the tier defaults, which the patched
+//     CLI also passes through the mapper
+//  4. FallbackModel, if set
+//
+// The same order is compiled into the JS injected into cli.js (see JS), so
+// Go-side previews and the patched CLI always agree.
+type Mapper struct {
+	exact    map[string]string
+	rules    []compiledRule
+	known    map[string]bool
+	fallback string
+}
+
+type compiledRule struct {
+	rule   models.MappingRule
+	re     *regexp.Regexp
+	source string // anchored regex source, shared by Go and JS
+}
+
+// Compile builds a Mapper from the config, validating every rule.
+func Compile(cfg *models.Config) (*Mapper, error) {
+	m := &Mapper{exact: make(map[string]string), fallback: cfg.FallbackModel}
+	for _, mm := range cfg.ModelMappings {
+		if mm.VSCodeID != "" {
+			m.exact[mm.VSCodeID] = mm.RelayID
+		}
+	}
+
+	for i, r := range cfg.MappingRules {
+		source, err := ruleSource(r)
+		if err != nil {
+			return nil, fmt.Errorf("mapping_rules[%d] %q: %w", i, r.Pattern, err)
+		}
+		re, err := regexp.Compile(source)
+		if err != nil {
+			return nil, fmt.Errorf("mapping_rules[%d] %q: %w", i, r.Pattern, err)
+		}
+		if err := validateTarget(r.Target, re.NumSubexp()); err != nil {
+			return nil, fmt.Errorf("mapping_rules[%d] target %q: %w", i, r.Target, err)
+		}
+		m.rules = append(m.rules, compiledRule{rule: r, re: re, source: source})
+	}
+	sort.SliceStable(m.rules, func(i, j int) bool {
+		return m.rules[i].rule.Priority > m.rules[j].rule.Priority
+	})
+
+	m.known = make(map[string]bool)
+	for _, id := range m.exact {
+		m.known[id] = true
+	}
+	for _, r := range m.rules {
+		if !strings.Contains(r.rule.Target, "$") {
+			m.known[r.rule.Target] = true
+		}
+	}
+	for _, id := range []string{cfg.DefaultOpus, cfg.DefaultSonnet, cfg.DefaultHaiku} {
+		m.known[m.Default(id)] = true
+	}
+	m.known[m.fallback] = true
+	delete(m.known, "")
+	return m, nil
+}
+
+// Validate reports whether the config's mapping rules compile.
+func Validate(cfg *models.Config) error {
+	_, err := Compile(cfg)
+	return err
+}
+
+// Map resolves id. The second result is false when nothing matched and id
+// is returned unchanged.
+func (m *Mapper) Map(id string) (string, bool) {
+	if id == "" {
+		return id, false
+	}
+	if v, ok := m.Rewrite(id); ok {
+		return v, true
+	}
+	if m.known[id] {
+		return id, false
+	}
+	if m.fallback != "" {
+		return m.fallback, true
+	}
+	return id, false
+}
+
+// Rewrite is Map without the fallback. It is used for values that are
+// already meant to be relay IDs, such as the tier defaults, which should be
+// normalized by rules but never replaced by the catch-all.
+func (m *Mapper) Rewrite(id string) (string, bool) {
+	if v, ok := m.exact[id]; ok {
+		return v, true
+	}
+	for _, r := range m.rules {
+		if sub := r.re.FindStringSubmatch(id); sub != nil {
+			return expandTarget(r.rule.Target, sub), true
+		}
+	}
+	return id, false
+}
+
+// Fallback returns the catch-all relay model, if any.
+func (m *Mapper) Fallback() string { return m.fallback }
+
+// Default resolves a tier default: rules apply as in Rewrite, and an empty
+// default takes the fallback model.
+func (m *Mapper) Default(id string) string {
+	if id == "" {
+		return m.fallback
+	}
+	id, _ = m.Rewrite(id)
+	return id
+}
+
+// Defaults returns the opus, sonnet and haiku defaults of cfg as they are
+// deployed to settings.json (see Default).
+func Defaults(cfg *models.Config) (opus, sonnet, haiku string, err error) {
+	m, err := Compile(cfg)
+	if err != nil {
+		return "", "", "", err
+	}
+	return m.Default(cfg.DefaultOpus), m.Default(cfg.DefaultSonnet), m.Default(cfg.DefaultHaiku), nil
+}
+
+// JS returns the globalThis snippet injected at the top of cli.js. It keeps
+// the historical globalThis.__cliModelMap / __cliMap names.
+func (m *Mapper) JS() string {
+	exact, _ := json.Marshal(m.exact) // map keys are sorted: deterministic output
+	rules := make([][2]string, len(m.rules))
+	for i, r := range m.rules {
+		rules[i] = [2]string{r.source, r.rule.Target}
+	}
+	rulesJSON, _ := json.Marshal(rules)
+	known, _ := json.Marshal(m.known)
+	fallback, _ := json.Marshal(m.fallback)
+	return fmt.Sprintf(
+		`globalThis.__cliModelMap=%s;globalThis.__cliRules=%s;globalThis.__cliKnown=%s;globalThis.__cliFallback=%s;`+
+			`globalThis.__cliMap=function(m){if(!m)return m;`+
+			`if(Object.prototype.hasOwnProperty.call(globalThis.__cliModelMap,m))return globalThis.__cliModelMap[m];`+
+			`for(var i=0;i<globalThis.__cliRules.length;i++){var r=new RegExp(globalThis.__cliRules[i][0]);if(r.test(m))return m.replace(r,globalThis.__cliRules[i][1])}`+
+			`if(Object.prototype.hasOwnProperty.call(globalThis.__cliKnown,m))return m;`+
+			`return globalThis.__cliFallback||m};`,
+		exact, rulesJSON, known, fallback,
+	)
+}
+
+// ruleSource turns a rule into an anchored regex source that behaves the
+// same in Go's RE2 and JavaScript.
+func ruleSource(r models.MappingRule) (string, error) {
+	if r.Pattern == "" {
+		return "", fmt.Errorf("empty pattern")
+	}
+	switch r.Kind {
+	case models.RuleExact:
+		return "^" + regexp.QuoteMeta(r.Pattern) + "$", nil
+	case models.RuleGlob, "":
+		var b strings.Builder
+		b.WriteString("^")
+		for _, c := range r.Pattern {
+			switch c {
+			case '*':
+				b.WriteString("(.*)")
+			case '?':
+				b.WriteString("(.)")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		b.WriteString("$")
+		return b.String(), nil
+	case models.RuleRegex:
+		// Reject syntax that RE2 and JS interpret differently (named groups,
+		// inline flags, \A \z \Q).
+		if strings.Contains(strings.ReplaceAll(r.Pattern, "(?:", ""), "(?") {
+			return "", fmt.Errorf("only (?: groups are supported, not named groups or flags")
+		}
+		for _, esc := range []string{`\A`, `\z`, `\Q`, `\E`} {
+			if strings.Contains(r.Pattern, esc) {
+				return "", fmt.Errorf("%s is not supported", esc)
+			}
+		}
+		return "^(?:" + r.Pattern + ")$", nil
+	default:
+		return "", fmt.Errorf("kind must be exact, glob or regex")
+	}
+}
+
+var targetRefRe = regexp.MustCompile(`\$(\$|[0-9]+)?`)
+
+// validateTarget allows only $1..$N group references and $$ in targets,
+// the subset with identical meaning in Go and JS String.replace.
+func validateTarget(target string, groups int) error {
+	if target == "" {
+		return fmt.Errorf("empty target")
+	}
+	for _, ref := range targetRefRe.FindAllStringSubmatch(target, -1) {
+		switch {
+		case ref[1] == "$":
+		case ref[1] == "":
+			return fmt.Errorf("bare $; use $$ for a literal dollar sign")
+		default:
+			n := int(ref[1][0] - '0')
+			if n == 0 || n > groups {
+				return fmt.Errorf("$%c refers to a missing group (pattern has %d)", ref[1][0], groups)
+			}
+		}
+	}
+	return nil
+}
+
+// expandTarget substitutes $N and $$ like JS String.prototype.replace does
+// for a whole-string match: a two-digit reference is used when that group
+// exists, otherwise only the first digit is a reference.
+func expandTarget(target string, sub []string) string {
+	var b strings.Builder
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		if c != '$' || i+1 >= len(target) {
+			b.WriteByte(c)
+			continue
+		}
+		next := target[i+1]
+		if next == '$' {
+			b.WriteByte('$')
+			i++
+			continue
+		}
+		if next < '0' || next > '9' {
+			b.WriteByte(c)
+			continue
+		}
+		n := int(next - '0')
+		width := 1
+		if i+2 < len(target) && target[i+2] >= '0' && target[i+2] <= '9' {
+			if nn := n*10 + int(target[i+2]-'0'); nn < len(sub) {
+				n, width = nn, 2
+			}
+		}
+		if n > 0 && n < len(sub) {
+			b.WriteString(sub[n])
+		} else {
+			b.WriteString(target[i : i+1+width])
+		}
+		i += width
+	}
+	return b.String()
+}
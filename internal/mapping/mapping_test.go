@@ -0,0 +1,238 @@
+package mapping
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"claude-relay/internal/models"
+)
+
+// testConfig exercises every resolution step: exact entries, rules of each
+// kind with priorities and group references, relay IDs that are kept, and a
+// fallback.
+func testConfig(fallback string) *models.Config {
+	return &models.Config{
+		ModelMappings: []models.ModelMapping{
+			{VSCodeID: "claude-opus-4.6", RelayID: "relay-opus"},
+			{VSCodeID: "", RelayID: "ignored"},
+		},
+		MappingRules: []models.MappingRule{
+			{Pattern: "claude-*-4.5", Kind: models.RuleGlob, Target: "claude-$1-4-5"},
+			{Pattern: "claude-sonnet-?.?", Target: "sonnet-$1$2"},
+			{Pattern: `claude-(haiku|sonnet)-(\d+)\.(\d+)-thinking`, Kind: models.RuleRegex, Target: "$1-$2$3-think", Priority: 10},
+			{Pattern: "gpt.4o", Kind: models.RuleExact, Target: "gpt-4o-mini"},
+			{Pattern: `price-(\d+)`, Kind: models.RuleRegex, Target: "$$$1"},
+			{Pattern: "a(b)(c)(d)(e)(f)(g)(h)(i)(j)(k)", Kind: models.RuleRegex, Target: "$10-$11-$1"},
+		},
+		DefaultSonnet: "relay-sonnet",
+		FallbackModel: fallback,
+	}
+}
+
+// mapInputs covers matches of every rule, misses, and IDs that differ only
+// in characters that are special in regexes.
+var mapInputs = []string{
+	"claude-opus-4.6",
+	"claude-haiku-4.5",
+	"claude-sonnet-4.5",
+	"claude-sonnet-3.7",
+	"claude-sonnet-4.5-thinking",
+	"claude-haiku-4.5-thinking",
+	"gpt.4o",
+	"gpt-4o",
+	"price-42",
+	"abcdefghijk",
+	"claude-opus-4.6x",
+	"relay-opus",
+	"gpt-4o-mini",
+	"relay-sonnet",
+	"unknown-model",
+	"",
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule models.MappingRule
+		want string
+	}{
+		{"empty pattern", models.MappingRule{Target: "x"}, "empty pattern"},
+		{"unknown kind", models.MappingRule{Pattern: "a", Kind: "fuzzy", Target: "x"}, "kind must be"},
+		{"bad regex", models.MappingRule{Pattern: "(", Kind: models.RuleRegex, Target: "x"}, "missing closing )"},
+		{"named group", models.MappingRule{Pattern: "(?P<n>a)", Kind: models.RuleRegex, Target: "x"}, "only (?: groups"},
+		{"inline flag", models.MappingRule{Pattern: "(?i)a", Kind: models.RuleRegex, Target: "x"}, "only (?: groups"},
+		{"anchor escape", models.MappingRule{Pattern: `\Aa`, Kind: models.RuleRegex, Target: "x"}, `\A is not supported`},
+		{"empty target", models.MappingRule{Pattern: "a"}, "empty target"},
+		{"bare dollar", models.MappingRule{Pattern: "a*", Target: "x$"}, "bare $"},
+		{"missing group", models.MappingRule{Pattern: "a*", Target: "$2"}, "missing group"},
+		{"group zero", models.MappingRule{Pattern: "a*", Target: "$0"}, "missing group"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(&models.Config{MappingRules: []models.MappingRule{tt.rule}})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+
+	if _, err := Compile(testConfig("")); err != nil {
+		t.Fatalf("valid config: %v", err)
+	}
+}
+
+func TestMap(t *testing.T) {
+	m, err := Compile(testConfig("relay-default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		id, want string
+		ok       bool
+	}{
+		{"claude-opus-4.6", "relay-opus", true},
+		{"claude-haiku-4.5", "claude-haiku-4-5", true},
+		// The first rule in config order wins among equal priorities.
+		{"claude-sonnet-4.5", "claude-sonnet-4-5", true},
+		{"claude-sonnet-3.7", "sonnet-37", true},
+		// Higher priority wins over config order.
+		{"claude-sonnet-4.5-thinking", "sonnet-45-think", true},
+		// Exact rules match literally; "." is not a wildcard.
+		{"gpt.4o", "gpt-4o-mini", true},
+		{"gpt-4o", "relay-default", true},
+		{"price-42", "$42", true},
+		// $10 is group 10 when it exists; $11 is $1 and a literal 1.
+		{"abcdefghijk", "k-b1-b", true},
+		// Rules are anchored.
+		{"claude-opus-4.6x", "relay-default", true},
+		// Relay IDs pass through: mapping values, literal rule targets and
+		// the tier defaults.
+		{"relay-opus", "relay-opus", false},
+		{"gpt-4o-mini", "gpt-4o-mini", false},
+		{"relay-sonnet", "relay-sonnet", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := m.Map(tt.id)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Map(%q) = %q, %v; want %q, %v", tt.id, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if got, ok := m.Rewrite("unknown-model"); got != "unknown-model" || ok {
+		t.Errorf("Rewrite ignores the fallback: got %q, %v", got, ok)
+	}
+	if m.Fallback() != "relay-default" {
+		t.Errorf("Fallback() = %q", m.Fallback())
+	}
+}
+
+func TestExpandTarget(t *testing.T) {
+	sub := []string{"whole", "a", "b"}
+	tests := []struct{ target, want string }{
+		{"$1-$2", "a-b"},
+		{"$$1", "$1"},
+		{"$3", "$3"},
+		{"$12", "a2"},
+		{"x$", "x$"},
+		{"$x", "$x"},
+		{"$0", "$0"},
+	}
+	for _, tt := range tests {
+		if got := expandTarget(tt.target, sub); got != tt.want {
+			t.Errorf("expandTarget(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+// TestJSMatchesGo runs the snippet injected into cli.js under node and
+// checks that it maps every input exactly as the Go mapper does.
+func TestJSMatchesGo(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node not found")
+	}
+	for _, fallback := range []string{"", "relay-default"} {
+		m, err := Compile(testConfig(fallback))
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs, _ := json.Marshal(mapInputs)
+		script := m.JS() + "process.stdout.write(JSON.stringify(" + string(inputs) + ".map(globalThis.__cliMap)));"
+		out, err := exec.Command(node, "-e", script).Output()
+		if err != nil {
+			t.Fatalf("node: %v", err)
+		}
+		var got []string
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Fatalf("node output %q: %v", out, err)
+		}
+		for i, id := range mapInputs {
+			want, _ := m.Map(id)
+			if fallback == "" {
+				// Without a fallback Map and Rewrite agree.
+				if rewritten, _ := m.Rewrite(id); rewritten != want {
+					t.Errorf("Rewrite(%q) = %q, Map = %q", id, rewritten, want)
+				}
+			}
+			if got[i] != want {
+				t.Errorf("fallback %q: JS maps %q to %q, Go to %q", fallback, id, got[i], want)
+			}
+		}
+	}
+}
+
+// TestJSKeepsDefaults checks that the patched CLI, which maps the tier
+// defaults from settings.json too, leaves them alone when a fallback is set.
+func TestJSKeepsDefaults(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node not found")
+	}
+	cfg := &models.Config{
+		MappingRules:  []models.MappingRule{{Pattern: "claude-*-4.6", Target: "relay-$1"}},
+		DefaultOpus:   "claude-opus-4.6",
+		DefaultSonnet: "relay-sonnet-4-5",
+		DefaultHaiku:  "relay-haiku-4-5",
+		FallbackModel: "relay-opus",
+	}
+	m, err := Compile(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opus, sonnet, haiku, _ := Defaults(cfg)
+	ids := []string{opus, sonnet, haiku, "gpt-4o"}
+	inputs, _ := json.Marshal(ids)
+	script := m.JS() + "process.stdout.write(JSON.stringify(" + string(inputs) + ".map(globalThis.__cliMap)));"
+	out, err := exec.Command(node, "-e", script).Output()
+	if err != nil {
+		t.Fatalf("node: %v", err)
+	}
+	var got []string
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("node output %q: %v", out, err)
+	}
+	want := []string{"relay-opus", "relay-sonnet-4-5", "relay-haiku-4-5", "relay-opus"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("JS maps %q to %q, want %q", ids, got, want)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	cfg := testConfig("relay-default")
+	cfg.DefaultOpus, cfg.DefaultSonnet = "claude-opus-4.6", "claude-sonnet-9-9"
+	opus, sonnet, haiku, err := Defaults(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rules apply, unmatched defaults are kept and only an empty one takes
+	// the fallback.
+	if opus != "relay-opus" || sonnet != "claude-sonnet-9-9" || haiku != "relay-default" {
+		t.Errorf("Defaults = %q, %q, %q", opus, sonnet, haiku)
+	}
+
+	cfg.MappingRules = append(cfg.MappingRules, models.MappingRule{Pattern: "(", Kind: models.RuleRegex, Target: "x"})
+	if _, _, _, err := Defaults(cfg); err == nil {
+		t.Error("invalid rule: want an error")
+	}
+}
@@ -0,0 +1,209 @@
+package models
+
+// Request and response bodies of the web API. The server, its OpenAPI
+// document and pkg/client all use these types.
+
+// APIResponse is the body of plain success responses and of every error.
+// Errors have Status "error" and a machine-readable Code; a refused deploy
+// also carries the catalog Report (catalog_check_failed) or the Preflight
+// report (preflight_failed); a target_locked error names the Lock holder.
+type APIResponse struct {
+	Status    string            `json:"status"`
+	Code      ErrorCode         `json:"code,omitempty"`
+	Message   string            `json:"message,omitempty"`
+	Report    *AutoDetectReport `json:"report,omitempty"`
+	Preflight *PreflightReport  `json:"preflight,omitempty"`
+	Lock      *LockHolder       `json:"lock,omitempty"`
+}
+
+// ErrorCode identifies the kind of an API error, so clients need not parse
+// messages.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest     ErrorCode = "invalid_request"      // malformed body or missing fields
+	CodeValidationFailed   ErrorCode = "validation_failed"    // the config, workspace or hooks did not validate
+	CodeNotConfigured      ErrorCode = "not_configured"       // base_url and api_key are not set
+	CodeAssetSyncDisabled  ErrorCode = "asset_sync_disabled"  // asset sync is off in the config
+	CodeTargetNotFound     ErrorCode = "target_not_found"     // unknown or inaccessible target
+	CodeWorkspaceNotFound  ErrorCode = "workspace_not_found"  // unknown or inaccessible workspace
+	CodeAlreadyExists      ErrorCode = "already_exists"       // a target or workspace of that name exists
+	CodeTargetInUse        ErrorCode = "target_in_use"        // workspaces still reference the target
+	CodeCatalogCheckFailed ErrorCode = "catalog_check_failed" // auto-detect blocked the deploy; see Report
+	CodePreflightFailed    ErrorCode = "preflight_failed"     // the preflight found errors; see Preflight
+	CodeAccountUnsupported ErrorCode = "account_unsupported"  // the relay reports no balance
+	CodeRelayError         ErrorCode = "relay_error"          // the relay could not be queried
+	CodeTargetError        ErrorCode = "target_error"         // a command on the target failed
+	CodeInternal           ErrorCode = "internal"             // reading or writing local state failed
+	CodeUnauthorized       ErrorCode = "unauthorized"         // missing or wrong token or login
+	CodeForbidden          ErrorCode = "forbidden"            // the account's role is too low
+	CodeCrossOrigin        ErrorCode = "cross_origin"         // a browser request from another site
+	CodeInvalidHost        ErrorCode = "invalid_host"         // the Host header is not one the server answers to
+	CodeJobNotFound        ErrorCode = "job_not_found"        // unknown, expired or inaccessible job
+	CodeJobFinished        ErrorCode = "job_finished"         // the job cannot be canceled any more
+	CodeTargetLocked       ErrorCode = "target_locked"        // another deploy or restore holds the target; see Lock
+	CodeCodespaceStopped   ErrorCode = "codespace_stopped"    // the codespace is not running; deploy with start
+	CodeGitHubError        ErrorCode = "github_error"         // gh could not list or start codespaces
+	CodeDockerError        ErrorCode = "docker_error"         // docker could not list containers
+)
+
+// ErrorCodes lists every ErrorCode, for documentation.
+var ErrorCodes = []ErrorCode{
+	CodeInvalidRequest, CodeValidationFailed, CodeNotConfigured, CodeAssetSyncDisabled,
+	CodeTargetNotFound, CodeWorkspaceNotFound, CodeAlreadyExists, CodeTargetInUse,
+	CodeCatalogCheckFailed, CodePreflightFailed,
+	CodeAccountUnsupported, CodeRelayError, CodeTargetError, CodeInternal,
+	CodeUnauthorized, CodeForbidden, CodeCrossOrigin, CodeInvalidHost,
+	CodeJobNotFound, CodeJobFinished, CodeTargetLocked,
+	CodeCodespaceStopped, CodeGitHubError, CodeDockerError,
+}
+
+// TargetRequest names the target of a preview, preflight, status, restore
+// or asset sync call.
+type TargetRequest struct {
+	TargetName string `json:"target_name"`
+}
+
+// DeployRequest deploys to a target. Force deploys even when the catalog
+// check or the preflight would refuse; Start first starts a codespace that
+// is not running.
+type DeployRequest struct {
+	TargetName string `json:"target_name"`
+	Force      bool   `json:"force,omitempty"`
+	Start      bool   `json:"start,omitempty"`
+}
+
+// CodespaceDeployRequest deploys to every codespace of Repo (owner/name),
+// adding targets for codespaces that have none.
+type CodespaceDeployRequest struct {
+	Repo  string `json:"repo"`
+	Force bool   `json:"force,omitempty"`
+	Start bool   `json:"start,omitempty"`
+}
+
+// CodespaceDeployResult lists the targets a repo deploy added, the jobs it
+// started and the codespaces it skipped.
+type CodespaceDeployResult struct {
+	Created []string      `json:"created"`
+	Jobs    []Job         `json:"jobs"`
+	Skipped []SkippedHost `json:"skipped"`
+}
+
+// SSHImportRequest imports host aliases from ~/.ssh/config as SSH targets;
+// no hosts means every candidate.
+type SSHImportRequest struct {
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// CodespaceImportRequest adds targets for codespaces: the named ones, those
+// of Repo, or every codespace without a target if both are empty.
+type CodespaceImportRequest struct {
+	Names []string `json:"names,omitempty"`
+	Repo  string   `json:"repo,omitempty"`
+}
+
+// ContainerImportRequest adds docker targets for running devcontainers,
+// picked by project folder; no folders means every devcontainer.
+type ContainerImportRequest struct {
+	LocalFolders []string `json:"local_folders,omitempty"`
+}
+
+// TargetImportResult lists the targets an import created and the hosts it
+// skipped, with the reason.
+type TargetImportResult struct {
+	Imported []string      `json:"imported"`
+	Skipped  []SkippedHost `json:"skipped"`
+}
+
+// SkippedHost is a host an import did not turn into a target.
+type SkippedHost struct {
+	Host   string `json:"host"`
+	Reason string `json:"reason"`
+}
+
+// DetectModelsResponse is the relay catalog with suggested mappings and
+// tier defaults.
+type DetectModelsResponse struct {
+	Models          []RelayModel   `json:"models"`
+	Source          string         `json:"source"`
+	FetchedAt       string         `json:"fetched_at"`
+	Error           string         `json:"error,omitempty"`
+	Changes         *CatalogChange `json:"changes,omitempty"`
+	SuggestMappings []ModelMapping `json:"suggest_mappings"`
+	SuggestOpus     string         `json:"suggest_opus"`
+	SuggestSonnet   string         `json:"suggest_sonnet"`
+	SuggestHaiku    string         `json:"suggest_haiku"`
+	Suggestions     []Suggestion   `json:"suggestions"`
+}
+
+// AccountResponse is the relay balance of the API key. Problem is set when
+// the key is expired or exhausted.
+type AccountResponse struct {
+	Account *RelayAccount `json:"account"`
+	Problem string        `json:"problem,omitempty"`
+}
+
+// AutoDetectResponse wraps the latest catalog check; Report is null before
+// the first one.
+type AutoDetectResponse struct {
+	Report *AutoDetectReport `json:"report"`
+}
+
+// Session describes the account behind a request.
+type Session struct {
+	Name    string   `json:"name"`
+	Role    Role     `json:"role"`
+	Targets []string `json:"targets,omitempty"`
+}
+
+// Job is an operation that runs in the background, such as a deploy. The
+// API returns it at once; poll it until State is no longer running.
+// Message summarizes a success, Error a failure. Times are RFC 3339.
+type Job struct {
+	ID         string           `json:"id"`
+	Kind       string           `json:"kind"`
+	Target     string           `json:"target"`
+	Workspace  string           `json:"workspace,omitempty"`
+	User       string           `json:"user,omitempty"`
+	State      JobState         `json:"state"`
+	Message    string           `json:"message,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	Preflight  *PreflightReport `json:"preflight,omitempty"`
+	Log        []JobLogEntry    `json:"log,omitempty"`
+	CreatedAt  string           `json:"created_at"`
+	FinishedAt string           `json:"finished_at,omitempty"`
+}
+
+// JobLogEntry is one progress line of a job.
+type JobLogEntry struct {
+	Time    string `json:"time"`
+	Message string `json:"message"`
+}
+
+// JobState is the lifecycle state of a Job.
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCanceled  JobState = "canceled"
+)
+
+// Job kinds.
+const (
+	JobDeploy          = "deploy"
+	JobDeployWorkspace = "deploy_workspace"
+)
+
+// LockHolder identifies who holds a target lock: the operation, the account
+// that started it and the claude-relay process (machine and PID). ID tells
+// holders apart so that only the owner releases a lock.
+type LockHolder struct {
+	ID    string `json:"id"`
+	Op    string `json:"op"`
+	User  string `json:"user"`
+	Host  string `json:"host"`
+	PID   int    `json:"pid"`
+	Since string `json:"since"`
+}
@@ -3,13 +3,91 @@ package models
 type Config struct {
 	APIKey        string         `json:"api_key"`
 	BaseURL       string         `json:"base_url"`
+	RelayAuth     string         `json:"relay_auth,omitempty"`
+	CatalogFormat string         `json:"catalog_format,omitempty"`
+	ModelsPath    string         `json:"models_path,omitempty"`
+	CatalogTTL    string         `json:"catalog_ttl,omitempty"`
+	AccountAPI    string         `json:"account_api,omitempty"`
+	QuotaPerUSD   int64          `json:"quota_per_usd,omitempty"` // New API QuotaPerUnit, default 500000
+	HTTP          *HTTPSettings  `json:"http,omitempty"`
 	ModelMappings []ModelMapping `json:"model_mappings"`
+	MappingRules  []MappingRule  `json:"mapping_rules,omitempty"`
+	FallbackModel string         `json:"fallback_model,omitempty"`
 	DefaultOpus   string         `json:"default_opus_model"`
 	DefaultSonnet string         `json:"default_sonnet_model"`
 	DefaultHaiku  string         `json:"default_haiku_model"`
 	MCPServers    []MCPServer    `json:"mcp_servers"`
 	Targets       []Target       `json:"targets"`
-	AutoDetect    bool           `json:"auto_detect"`
+	Workspaces    []Workspace    `json:"workspaces,omitempty"`
+
+	// AutoDetect checks the configured models against the relay catalog at
+	// deploy time and, if AutoDetectInterval (a Go duration such as "6h") is
+	// set, in the background. AutoDetectPolicy decides what a failed check
+	// does: "block" (the default) or "apply" the regenerated suggestions.
+	AutoDetect         bool          `json:"auto_detect"`
+	AutoDetectPolicy   string        `json:"auto_detect_policy,omitempty"`
+	AutoDetectInterval string        `json:"auto_detect_interval,omitempty"`
+	SuggestPrefs       *SuggestPrefs `json:"suggest_prefs,omitempty"`
+
+	Permissions *Permissions             `json:"permissions,omitempty"`
+	Hooks       map[string][]HookMatcher `json:"hooks,omitempty"`
+	AssetSync   *AssetSync               `json:"asset_sync,omitempty"`
+}
+
+// HTTPSettings configures outbound connections to the relay.
+//
+// Proxy is empty to use the HTTPS_PROXY/HTTP_PROXY/NO_PROXY environment,
+// "direct" to bypass any proxy, or a proxy URL (http, https or socks5).
+// CABundle is a PEM file trusted in addition to the system roots;
+// ClientCert/ClientKey enable mTLS. Paths may use ~/ and ${HOME}.
+// Timeout bounds a whole request (default 15s), ConnectTimeout the dial.
+// With MirrorEnv, an explicit Proxy is also written to the deployed env as
+// HTTPS_PROXY. The CABundle is not: Node reads NODE_EXTRA_CA_CERTS only at
+// startup, so it must be set in the environment that launches VS Code.
+type HTTPSettings struct {
+	Proxy          string `json:"proxy,omitempty"`
+	CABundle       string `json:"ca_bundle,omitempty"`
+	ClientCert     string `json:"client_cert,omitempty"`
+	ClientKey      string `json:"client_key,omitempty"`
+	TLSMinVersion  string `json:"tls_min_version,omitempty"`
+	Timeout        string `json:"timeout,omitempty"`
+	ConnectTimeout string `json:"connect_timeout,omitempty"`
+	MirrorEnv      bool   `json:"mirror_env,omitempty"`
+}
+
+// HTTPSettings.Proxy value that disables proxying.
+const ProxyDirect = "direct"
+
+// AssetSync configures pushing shared Claude assets (commands/*.md,
+// agents/*.md and CLAUDE.md) from a local directory to every target's ~/.claude.
+type AssetSync struct {
+	Enabled       bool   `json:"enabled"`
+	Source        string `json:"source,omitempty"`
+	DeleteOrphans bool   `json:"delete_orphans"`
+}
+
+// Permissions mirrors the "permissions" block of Claude's settings.json, so
+// its JSON keys follow Claude's naming rather than this file's snake_case.
+type Permissions struct {
+	Allow                 []string `json:"allow,omitempty"`
+	Deny                  []string `json:"deny,omitempty"`
+	Ask                   []string `json:"ask,omitempty"`
+	DefaultMode           string   `json:"defaultMode,omitempty"`
+	AdditionalDirectories []string `json:"additionalDirectories,omitempty"`
+}
+
+// HookMatcher is one entry of a hook event list in Claude's settings.json.
+// Hooks are keyed by event name (PreToolUse, Stop, ...) in Config.Hooks.
+type HookMatcher struct {
+	Matcher string `json:"matcher,omitempty"`
+	Hooks   []Hook `json:"hooks"`
+}
+
+type Hook struct {
+	Type    string `json:"type"`
+	Command string `json:"command,omitempty"`
+	Prompt  string `json:"prompt,omitempty"`
+	Timeout int    `json:"timeout,omitempty"`
 }
 
 type ModelMapping struct {
@@ -17,18 +95,138 @@ type ModelMapping struct {
 	RelayID  string `json:"relay_id"`
 }
 
+// MappingRule maps model IDs by pattern. Rules are tried after the exact
+// ModelMappings table, highest Priority first; the first match wins.
+// Target may reference glob wildcards or regex groups as $1..$9.
+type MappingRule struct {
+	Pattern  string `json:"pattern"`
+	Kind     string `json:"kind"`
+	Target   string `json:"target"`
+	Priority int    `json:"priority"`
+}
+
+// MappingRule kinds.
+const (
+	RuleExact = "exact"
+	RuleGlob  = "glob"
+	RuleRegex = "regex"
+)
+
+// Target is a machine to deploy to. Host is the ssh host, the codespace
+// name, the container name or the pod name; a docker target may name a
+// devcontainer by its LocalFolder instead, and User overrides the user
+// commands run as in the container (docker only; ssh users go in Host as
+// user@host). A kubernetes target may pick its pod by
+// a label Selector in Namespace, and names the Container and the kubectl
+// KubeContext when the defaults do not fit.
 type Target struct {
-	Name string     `json:"name"`
-	Type TargetType `json:"type"`
-	Host string     `json:"host,omitempty"`
+	Name        string       `json:"name"`
+	Type        TargetType   `json:"type"`
+	Host        string       `json:"host,omitempty"`
+	LocalFolder string       `json:"local_folder,omitempty"`
+	User        string       `json:"user,omitempty"`
+	Namespace   string       `json:"namespace,omitempty"`
+	Selector    string       `json:"selector,omitempty"`
+	Container   string       `json:"container,omitempty"`
+	KubeContext string       `json:"kube_context,omitempty"`
+	Workspace   string       `json:"workspace,omitempty"`
+	Facts       *TargetFacts `json:"facts,omitempty"` // from the last connection test
 }
 
+// TargetFacts is what a connection test found on a target. It is cached on
+// the target and cleared when its connection settings change.
+type TargetFacts struct {
+	TestedAt    string     `json:"tested_at"`
+	Reachable   bool       `json:"reachable"`
+	Error       string     `json:"error,omitempty"`
+	LatencyMS   int64      `json:"latency_ms,omitempty"`
+	OS          string     `json:"os,omitempty"`   // GOOS style: linux, darwin
+	Arch        string     `json:"arch,omitempty"` // GOARCH style: amd64, arm64
+	Home        string     `json:"home,omitempty"`
+	Tools       []ToolInfo `json:"tools,omitempty"`
+	CopilotChat []string   `json:"copilot_chat,omitempty"` // installed versions, newest first
+}
+
+// ToolInfo tells whether a command MCP servers commonly need is installed.
+type ToolInfo struct {
+	Name    string `json:"name"`
+	Found   bool   `json:"found"`
+	Version string `json:"version,omitempty"`
+}
+
+// SSHHost is a host alias found in ~/.ssh/config, with the settings ssh
+// would use for it.
+type SSHHost struct {
+	Alias        string   `json:"alias"`
+	HostName     string   `json:"hostname,omitempty"`
+	User         string   `json:"user,omitempty"`
+	Port         int      `json:"port,omitempty"`
+	IdentityFile []string `json:"identity_file,omitempty"`
+	ProxyJump    string   `json:"proxy_jump,omitempty"`
+	Source       string   `json:"source"`           // file that defines the alias
+	Exists       bool     `json:"exists,omitempty"` // a target of that name exists
+}
+
+// Container is a running devcontainer as docker reports it. User is the
+// devcontainer's remoteUser (or the image user); Target names the docker
+// target for it, if there is one.
+type Container struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Image       string `json:"image"`
+	State       string `json:"state"`
+	LocalFolder string `json:"local_folder"`
+	User        string `json:"user,omitempty"`
+	Target      string `json:"target,omitempty"`
+}
+
+// Codespace is a GitHub codespace as gh lists it. Target names the
+// codespace target for it, if there is one.
+type Codespace struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name,omitempty"`
+	Repository  string `json:"repository"`
+	Branch      string `json:"branch,omitempty"`
+	State       string `json:"state"`
+	LastUsedAt  string `json:"last_used_at,omitempty"`
+	Target      string `json:"target,omitempty"`
+}
+
+// Codespace states that matter to a deploy; gh reports several more
+// (Starting, Queued, Rebuilding, ...).
+const (
+	CodespaceAvailable = "Available"
+	CodespaceShutdown  = "Shutdown"
+)
+
+// Workspace is a project directory on a target that receives project-scoped
+// .claude/settings(.local).json and .mcp.json files. Model and MCP fields
+// override or extend the global config for that project only.
+type Workspace struct {
+	Name          string      `json:"name"`
+	Target        string      `json:"target"`
+	Path          string      `json:"path"`
+	SettingsFile  string      `json:"settings_file,omitempty"`
+	DefaultOpus   string      `json:"default_opus_model,omitempty"`
+	DefaultSonnet string      `json:"default_sonnet_model,omitempty"`
+	DefaultHaiku  string      `json:"default_haiku_model,omitempty"`
+	MCPServers    []MCPServer `json:"mcp_servers,omitempty"`
+}
+
+// Project settings file names accepted in Workspace.SettingsFile.
+const (
+	ProjectSettings      = "settings.json"
+	ProjectLocalSettings = "settings.local.json"
+)
+
 type TargetType string
 
 const (
-	TargetLocal     TargetType = "local"
-	TargetSSH       TargetType = "ssh"
-	TargetCodespace TargetType = "codespace"
+	TargetLocal      TargetType = "local"
+	TargetSSH        TargetType = "ssh"
+	TargetCodespace  TargetType = "codespace"
+	TargetDocker     TargetType = "docker"
+	TargetKubernetes TargetType = "kubernetes"
 )
 
 type MCPServer struct {
@@ -47,8 +245,237 @@ type DeployStatus struct {
 	CLIPath         string `json:"cli_path,omitempty"`
 	CLIPatched      bool   `json:"cli_patched"`
 	CLIBackupExists bool   `json:"cli_backup_exists"`
+
+	ClaudeSettingsState SettingsState `json:"claude_settings_state,omitempty"`
+	VSCodeSettingsState SettingsState `json:"vscode_settings_state,omitempty"`
+
+	AssetDrift      []AssetDrift `json:"asset_drift,omitempty"`
+	AssetDriftError string       `json:"asset_drift_error,omitempty"` // the asset source could not be read
+}
+
+// AssetDrift is a synced asset whose target copy differs from the source.
+// Error explains an unreadable or unknown state.
+type AssetDrift struct {
+	Path  string `json:"path"`
+	State string `json:"state"` // missing, changed, orphan, unreadable or unknown
+	Error string `json:"error,omitempty"`
+}
+
+// AssetSyncResult reports what an asset sync changed on a target.
+type AssetSyncResult struct {
+	Target    string   `json:"target"`
+	Pushed    []string `json:"pushed"`
+	Deleted   []string `json:"deleted"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// SettingsState describes whether a settings file is still the user's own
+// or has been written by claude-relay (and can be restored).
+type SettingsState string
+
+const (
+	SettingsOriginal SettingsState = "original"
+	SettingsManaged  SettingsState = "managed"
+	SettingsMissing  SettingsState = "missing"
+)
+
+// DeployPreview shows what a deploy would write to a target, with
+// template variables in MCP server commands already resolved.
+type DeployPreview struct {
+	Target     string            `json:"target"`
+	Vars       map[string]string `json:"vars"`
+	MCPServers []MCPServer       `json:"mcp_servers"`
+	Env        map[string]string `json:"env"`
+}
+
+// UsersFile is the account list of a multi-user server
+// (~/.claude-relay/users.json by default). Passwords are checked against
+// User.PasswordHash or, for users without one, the Htpasswd file. Users that
+// only appear in the htpasswd file get DefaultRole; if it is empty they
+// cannot log in.
+type UsersFile struct {
+	Htpasswd    string `json:"htpasswd,omitempty"`
+	DefaultRole Role   `json:"default_role,omitempty"`
+	Users       []User `json:"users"`
+}
+
+// User is a web UI account. Targets lists the target names (path.Match
+// patterns) the user may see and deploy to; empty means all. Admins manage
+// the config and therefore always see every target.
+type User struct {
+	Name         string   `json:"name"`
+	PasswordHash string   `json:"password_hash,omitempty"`
+	Role         Role     `json:"role"`
+	Targets      []string `json:"targets,omitempty"`
 }
 
+// Role grants access to a set of API routes; each role includes the ones
+// below it.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"   // target list and deploy status
+	RoleOperator Role = "operator" // deploy, restore, model detection
+	RoleAdmin    Role = "admin"    // config, API key, targets and workspaces
+)
+
+// Relay auth header styles for Config.RelayAuth. Empty means auto-detect.
+const (
+	RelayAuthAuto   = "auto"
+	RelayAuthBearer = "bearer"
+	RelayAuthAPIKey = "x-api-key"
+)
+
+// RelayModel is a catalog entry normalized across relay formats. ID,
+// DisplayName, CreatedAt, OwnedBy, ContextLength and Pricing come from the
+// relay; Provider, Family, Tier, Version, Date and Capabilities are parsed
+// from the ID (e.g. "anthropic/claude-sonnet-4-5-20250929-thinking").
 type RelayModel struct {
-	ID string `json:"id"`
+	ID            string        `json:"id"`
+	DisplayName   string        `json:"display_name,omitempty"`
+	CreatedAt     string        `json:"created_at,omitempty"`
+	Type          string        `json:"type,omitempty"`
+	OwnedBy       string        `json:"owned_by,omitempty"`
+	ContextLength int           `json:"context_length,omitempty"`
+	Pricing       *ModelPricing `json:"pricing,omitempty"`
+
+	Provider     string   `json:"provider,omitempty"`
+	Family       string   `json:"family,omitempty"`
+	Tier         string   `json:"tier,omitempty"`
+	Version      string   `json:"version,omitempty"`
+	Date         string   `json:"date,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	Deprecated   bool     `json:"deprecated,omitempty"`
+}
+
+// AutoDetect policies.
+const (
+	AutoDetectBlock = "block"
+	AutoDetectApply = "apply"
+)
+
+// CatalogIssue is a configured model that is missing from the relay catalog
+// or deprecated. Field names the config entry, e.g. "default_opus_model" or
+// "model_mappings[claude-opus-4.6]".
+type CatalogIssue struct {
+	Field     string `json:"field"`
+	Current   string `json:"current"`
+	Problem   string `json:"problem"`
+	Suggested string `json:"suggested,omitempty"`
+}
+
+// AutoDetectReport is the outcome of one catalog check.
+type AutoDetectReport struct {
+	CheckedAt string         `json:"checked_at"`
+	Trigger   string         `json:"trigger"`
+	Policy    string         `json:"policy"`
+	Models    int            `json:"models"`
+	Issues    []CatalogIssue `json:"issues"`
+	Applied   bool           `json:"applied"`
+	Blocked   bool           `json:"blocked"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// PreflightIssue is one finding of the deploy preflight. Level is "error"
+// (the deploy is refused unless forced) or "warning".
+type PreflightIssue struct {
+	Level   string `json:"level"`
+	Code    string `json:"code"`
+	Field   string `json:"field"`
+	Model   string `json:"model,omitempty"`
+	Message string `json:"message"`
+}
+
+// PreflightReport lists what would go wrong when deploying the config. OK is
+// false when any issue is an error.
+type PreflightReport struct {
+	Target string           `json:"target"`
+	Models int              `json:"models"`
+	OK     bool             `json:"ok"`
+	Issues []PreflightIssue `json:"issues"`
+}
+
+// Preflight levels.
+const (
+	PreflightError   = "error"
+	PreflightWarning = "warning"
+)
+
+// SuggestPrefs tunes which variant of a model SuggestMappings picks when
+// several share the best family and version. Prefixes are vendor prefixes
+// such as "anthropic/"; suffixes are variant markers such as "-thinking" or
+// "[1m]" and match anywhere in the ID, so combined variants count too.
+type SuggestPrefs struct {
+	PreferPrefixes []string `json:"prefer_prefixes,omitempty"`
+	AvoidPrefixes  []string `json:"avoid_prefixes,omitempty"`
+	PreferSuffixes []string `json:"prefer_suffixes,omitempty"`
+	AvoidSuffixes  []string `json:"avoid_suffixes,omitempty"`
+}
+
+// Suggestion explains the model picked for one slot: a VS Code model ID
+// (mappings) or a default tier ("opus", "sonnet", "haiku").
+type Suggestion struct {
+	Slot      string      `json:"slot"`
+	RelayID   string      `json:"relay_id"`
+	Reasons   []string    `json:"reasons"`
+	RunnersUp []Candidate `json:"runners_up,omitempty"`
+}
+
+// Candidate is a scored model that lost to the pick of a Suggestion.
+type Candidate struct {
+	ID      string   `json:"id"`
+	Reasons []string `json:"reasons"`
+}
+
+// CatalogResult is a relay catalog together with where it came from.
+// Source is "live" (fetched), "cache" (within CatalogTTL), "revalidated"
+// (the relay answered 304) or "offline" (the relay could not be reached and
+// the last cached copy is returned; Error says why).
+type CatalogResult struct {
+	Models    []RelayModel   `json:"models"`
+	Source    string         `json:"source"`
+	FetchedAt string         `json:"fetched_at"`
+	Error     string         `json:"error,omitempty"`
+	Changes   *CatalogChange `json:"changes,omitempty"`
+}
+
+// CatalogChange records the models that appeared or disappeared between two
+// fetches of a relay catalog.
+type CatalogChange struct {
+	CheckedAt string   `json:"checked_at"`
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+}
+
+// RelayAccount is the balance of the configured API key as reported by the
+// relay's account endpoints. Amounts are in USD; Unlimited keys have no
+// meaningful Granted/Remaining. ExpiresAt is RFC 3339, empty if the key
+// never expires. ModelUsage is only filled by relays that expose usage logs.
+type RelayAccount struct {
+	Provider   string       `json:"provider"`
+	Name       string       `json:"name,omitempty"`
+	Unlimited  bool         `json:"unlimited"`
+	Granted    float64      `json:"granted"`
+	Used       float64      `json:"used"`
+	Remaining  float64      `json:"remaining"`
+	ExpiresAt  string       `json:"expires_at,omitempty"`
+	Expired    bool         `json:"expired"`
+	Exhausted  bool         `json:"exhausted"`
+	ModelUsage []ModelUsage `json:"model_usage,omitempty"`
+	CheckedAt  string       `json:"checked_at"`
+}
+
+// ModelUsage is the spend of one model in a RelayAccount.
+type ModelUsage struct {
+	Model            string  `json:"model"`
+	Requests         int     `json:"requests"`
+	Used             float64 `json:"used"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+}
+
+// ModelPricing is per-token pricing as reported by the relay (USD strings).
+type ModelPricing struct {
+	Prompt     string `json:"prompt,omitempty"`
+	Completion string `json:"completion,omitempty"`
 }
@@ -0,0 +1,349 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"claude-relay/internal/models"
+)
+
+// defaultQuotaPerUSD is the New API quota unit used when
+// Config.QuotaPerUSD is unset: 500000 = $1.
+const defaultQuotaPerUSD = 500000
+
+// accountCacheTTL is how long CachedAccount reuses a result.
+const accountCacheTTL = 5 * time.Minute
+
+// accountCheckTimeout bounds the lookups CachedAccount makes.
+var accountCheckTimeout = 5 * time.Second
+
+// ErrAccountUnsupported is returned by Account when the relay exposes none of
+// the known account endpoints.
+var ErrAccountUnsupported = errors.New("relay does not expose account information")
+
+// errNoEndpoint marks a missing endpoint so auto-detection tries the next API.
+var errNoEndpoint = errors.New("endpoint not found")
+
+// AccountAPI reads the key's balance from one relay implementation.
+// APIs are tried in registration order when Config.AccountAPI is empty.
+type AccountAPI interface {
+	// Name is the value used for Config.AccountAPI.
+	Name() string
+	// Fetch returns the account, or an error wrapping errNoEndpoint if the
+	// relay does not implement this API.
+	Fetch(c *accountClient) (*models.RelayAccount, error)
+}
+
+var accountAPIs = []AccountAPI{
+	newAPIAccount{},
+	oneAPIAccount{},
+}
+
+// accountClient issues authenticated GETs against the relay root.
+type accountClient struct {
+	ctx  context.Context
+	http *http.Client
+	base string
+	key  string
+	// quotaPerUSD converts New API quota units to dollars.
+	quotaPerUSD float64
+}
+
+// Account queries the relay for the remaining quota, expiry and, where
+// supported, per-model usage of the configured key. Config.AccountAPI selects
+// the API ("newapi", "oneapi", or "none" to disable); empty auto-detects.
+func Account(cfg *models.Config) (*models.RelayAccount, error) {
+	acct, err := fetchAccount(context.Background(), cfg)
+	storeAccount(cfg, acct, err)
+	return acct, err
+}
+
+type cachedAccount struct {
+	acct      *models.RelayAccount
+	err       error
+	fetchedAt time.Time
+}
+
+var (
+	accountMu    sync.Mutex
+	accountCache = map[string]cachedAccount{}
+)
+
+// accountCacheKey identifies the relay, key and account settings.
+func accountCacheKey(cfg *models.Config) string {
+	return fmt.Sprintf("%s\x00%s\x00%d", cacheKey(cfg), cfg.AccountAPI, cfg.QuotaPerUSD)
+}
+
+func storeAccount(cfg *models.Config, acct *models.RelayAccount, err error) {
+	accountMu.Lock()
+	defer accountMu.Unlock()
+	accountCache[accountCacheKey(cfg)] = cachedAccount{acct: acct, err: err, fetchedAt: time.Now()}
+}
+
+// CachedAccount is Account for callers on the deploy path: results, errors
+// included, are reused for accountCacheTTL, and a lookup that takes longer
+// than accountCheckTimeout fails instead of holding up the caller.
+func CachedAccount(cfg *models.Config) (*models.RelayAccount, error) {
+	accountMu.Lock()
+	c, ok := accountCache[accountCacheKey(cfg)]
+	accountMu.Unlock()
+	if ok && time.Since(c.fetchedAt) < accountCacheTTL {
+		return c.acct, c.err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), accountCheckTimeout)
+	defer cancel()
+	acct, err := fetchAccount(ctx, cfg)
+	storeAccount(cfg, acct, err)
+	return acct, err
+}
+
+func fetchAccount(ctx context.Context, cfg *models.Config) (*models.RelayAccount, error) {
+	if cfg.AccountAPI == "none" {
+		return nil, ErrAccountUnsupported
+	}
+	client, err := HTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &accountClient{
+		ctx:         ctx,
+		http:        client,
+		base:        relayRoot(cfg.BaseURL),
+		key:         cfg.APIKey,
+		quotaPerUSD: defaultQuotaPerUSD,
+	}
+	if cfg.QuotaPerUSD > 0 {
+		c.quotaPerUSD = float64(cfg.QuotaPerUSD)
+	}
+
+	apis := accountAPIs
+	if cfg.AccountAPI != "" {
+		apis = nil
+		for _, a := range accountAPIs {
+			if a.Name() == cfg.AccountAPI {
+				apis = []AccountAPI{a}
+			}
+		}
+		if apis == nil {
+			return nil, fmt.Errorf("unknown account API %q", cfg.AccountAPI)
+		}
+	}
+	var authErr error
+	for _, a := range apis {
+		acct, err := a.Fetch(c)
+		if errors.Is(err, errNoEndpoint) {
+			continue
+		}
+		if ae := (*authError)(nil); errors.As(err, &ae) && len(apis) > 1 {
+			// Another API may live under a path this one's auth guards.
+			authErr = fmt.Errorf("%s account: %w", a.Name(), err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s account: %w", a.Name(), err)
+		}
+		acct.Provider = a.Name()
+		if !acct.Unlimited {
+			acct.Remaining = max(acct.Granted-acct.Used, 0)
+			acct.Exhausted = acct.Remaining == 0
+		}
+		if acct.ExpiresAt != "" {
+			if t, err := time.Parse(time.RFC3339, acct.ExpiresAt); err == nil {
+				acct.Expired = time.Now().After(t)
+			}
+		}
+		acct.CheckedAt = time.Now().UTC().Format(time.RFC3339)
+		return acct, nil
+	}
+	if authErr != nil {
+		return nil, authErr
+	}
+	return nil, ErrAccountUnsupported
+}
+
+// AccountProblem describes why the key cannot be used, or "" if it can.
+func AccountProblem(acct *models.RelayAccount) string {
+	switch {
+	case acct.Expired:
+		return "the API key expired at " + acct.ExpiresAt
+	case acct.Exhausted:
+		return fmt.Sprintf("the API key has no quota left ($%.2f of $%.2f used)", acct.Used, acct.Granted)
+	}
+	return ""
+}
+
+// relayRoot strips a trailing /v1 so account paths can be joined to it.
+func relayRoot(baseURL string) string {
+	return strings.TrimSuffix(strings.TrimRight(baseURL, "/"), "/v1")
+}
+
+// get decodes the JSON response of path into v. 404 and 405 wrap
+// errNoEndpoint, as do HTML responses from relays that serve a SPA for
+// unknown routes.
+func (c *accountClient) get(path string, v any) error {
+	req, err := http.NewRequestWithContext(c.ctx, "GET", c.base+path, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.key)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("relay unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 || resp.StatusCode == 405 {
+		return fmt.Errorf("%s: %w", path, errNoEndpoint)
+	}
+	if resp.StatusCode == 401 || resp.StatusCode == 403 {
+		return &authError{status: resp.StatusCode}
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s: relay returned HTTP %d", path, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+		return fmt.Errorf("%s: %w", path, errNoEndpoint)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// unixTime formats a unix timestamp; zero and negative values mean "never".
+func unixTime(sec int64) string {
+	if sec <= 0 {
+		return ""
+	}
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339)
+}
+
+// --- New API: GET /api/usage/token, per-model usage from GET /api/log/token ---
+
+type newAPIAccount struct{}
+
+func (newAPIAccount) Name() string { return "newapi" }
+
+func (newAPIAccount) Fetch(c *accountClient) (*models.RelayAccount, error) {
+	var resp struct {
+		Code    *bool  `json:"code"`
+		Message string `json:"message"`
+		Data    *struct {
+			Name           string `json:"name"`
+			TotalGranted   int64  `json:"total_granted"`
+			TotalUsed      int64  `json:"total_used"`
+			UnlimitedQuota bool   `json:"unlimited_quota"`
+			ExpiresAt      int64  `json:"expires_at"`
+		} `json:"data"`
+	}
+	if err := c.get("/api/usage/token", &resp); err != nil {
+		return nil, err
+	}
+	if resp.Data == nil {
+		if resp.Code != nil && !*resp.Code {
+			return nil, fmt.Errorf("relay error: %s", resp.Message)
+		}
+		return nil, fmt.Errorf("/api/usage/token: %w", errNoEndpoint)
+	}
+	d := resp.Data
+	acct := &models.RelayAccount{
+		Name:      d.Name,
+		Unlimited: d.UnlimitedQuota,
+		Granted:   float64(d.TotalGranted) / c.quotaPerUSD,
+		Used:      float64(d.TotalUsed) / c.quotaPerUSD,
+		ExpiresAt: unixTime(d.ExpiresAt),
+	}
+	// Usage logs are optional; older versions and locked-down instances
+	// don't serve them.
+	acct.ModelUsage, _ = newAPIModelUsage(c)
+	return acct, nil
+}
+
+func newAPIModelUsage(c *accountClient) ([]models.ModelUsage, error) {
+	var resp struct {
+		Success bool `json:"success"`
+		Data    []struct {
+			ModelName        string `json:"model_name"`
+			Quota            int64  `json:"quota"`
+			PromptTokens     int    `json:"prompt_tokens"`
+			CompletionTokens int    `json:"completion_tokens"`
+		} `json:"data"`
+	}
+	if err := c.get("/api/log/token?key="+neturl.QueryEscape(c.key), &resp); err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("usage logs unavailable")
+	}
+	byModel := make(map[string]*models.ModelUsage)
+	for _, l := range resp.Data {
+		if l.ModelName == "" {
+			continue
+		}
+		u := byModel[l.ModelName]
+		if u == nil {
+			u = &models.ModelUsage{Model: l.ModelName}
+			byModel[l.ModelName] = u
+		}
+		u.Requests++
+		u.Used += float64(l.Quota) / c.quotaPerUSD
+		u.PromptTokens += l.PromptTokens
+		u.CompletionTokens += l.CompletionTokens
+	}
+	usage := make([]models.ModelUsage, 0, len(byModel))
+	for _, u := range byModel {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Used != usage[j].Used {
+			return usage[i].Used > usage[j].Used
+		}
+		return usage[i].Model < usage[j].Model
+	})
+	return usage, nil
+}
+
+// --- One API: OpenAI-style GET /v1/dashboard/billing/{subscription,usage} ---
+
+type oneAPIAccount struct{}
+
+func (oneAPIAccount) Name() string { return "oneapi" }
+
+// oneAPIUnlimited is the hard limit One API reports for unlimited keys.
+const oneAPIUnlimited = 100000000
+
+func (oneAPIAccount) Fetch(c *accountClient) (*models.RelayAccount, error) {
+	var sub struct {
+		HardLimitUSD float64 `json:"hard_limit_usd"`
+		AccessUntil  int64   `json:"access_until"`
+	}
+	if err := c.get("/v1/dashboard/billing/subscription", &sub); err != nil {
+		return nil, err
+	}
+	// One API ignores the date range and reports the key's total usage.
+	var usage struct {
+		TotalUsage float64 `json:"total_usage"` // cents
+	}
+	if err := c.get("/v1/dashboard/billing/usage", &usage); err != nil {
+		return nil, err
+	}
+	return &models.RelayAccount{
+		Unlimited: sub.HardLimitUSD >= oneAPIUnlimited,
+		Granted:   sub.HardLimitUSD,
+		Used:      usage.TotalUsage / 100,
+		ExpiresAt: unixTime(sub.AccessUntil),
+	}, nil
+}
@@ -0,0 +1,208 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"claude-relay/internal/models"
+)
+
+// relayServer serves the given path → JSON body pairs and 404 for the rest,
+// checking the bearer key on every request.
+func relayServer(t *testing.T, routes map[string]string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.Header.Get("Authorization") != "Bearer sk-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, ok := routes[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestAccountNewAPI(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour).Unix()
+	srv, _ := relayServer(t, map[string]string{
+		"/api/usage/token": fmt.Sprintf(`{"code":true,"message":"ok","data":{"name":"team","total_granted":5000000,"total_used":1250000,"unlimited_quota":false,"expires_at":%d}}`, future),
+		"/api/log/token?key=sk-test": `{"success":true,"data":[
+			{"model_name":"claude-sonnet-4-5","quota":250000,"prompt_tokens":100,"completion_tokens":10},
+			{"model_name":"claude-opus-4-6","quota":750000,"prompt_tokens":300,"completion_tokens":30},
+			{"model_name":"claude-sonnet-4-5","quota":250000,"prompt_tokens":50,"completion_tokens":5},
+			{"model_name":"","quota":1}
+		]}`,
+	})
+
+	acct, err := Account(&models.Config{BaseURL: srv.URL + "/v1", APIKey: "sk-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.Provider != "newapi" || acct.Name != "team" || acct.Granted != 10 || acct.Used != 2.5 || acct.Remaining != 7.5 {
+		t.Errorf("account = %+v", acct)
+	}
+	if acct.Expired || acct.Exhausted || acct.ExpiresAt != time.Unix(future, 0).UTC().Format(time.RFC3339) {
+		t.Errorf("expiry = %q, expired %v, exhausted %v", acct.ExpiresAt, acct.Expired, acct.Exhausted)
+	}
+	// Per-model usage is summed and sorted by spend.
+	want := []models.ModelUsage{
+		{Model: "claude-opus-4-6", Requests: 1, Used: 1.5, PromptTokens: 300, CompletionTokens: 30},
+		{Model: "claude-sonnet-4-5", Requests: 2, Used: 1, PromptTokens: 150, CompletionTokens: 15},
+	}
+	if !reflect.DeepEqual(acct.ModelUsage, want) {
+		t.Errorf("model usage = %+v", acct.ModelUsage)
+	}
+
+	// A relay with a different quota unit.
+	acct, err = Account(&models.Config{BaseURL: srv.URL, APIKey: "sk-test", AccountAPI: "newapi", QuotaPerUSD: 1000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.Granted != 5 || acct.Used != 1.25 || acct.ModelUsage[0].Used != 0.75 {
+		t.Errorf("quota_per_usd 1000000: %+v", acct)
+	}
+}
+
+func TestAccountNewAPIExhausted(t *testing.T) {
+	srv, _ := relayServer(t, map[string]string{
+		"/api/usage/token": `{"data":{"total_granted":500000,"total_used":600000,"expires_at":1000}}`,
+	})
+	acct, err := Account(&models.Config{BaseURL: srv.URL, APIKey: "sk-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Usage logs are optional.
+	if acct.ModelUsage != nil || acct.Remaining != 0 || !acct.Exhausted || !acct.Expired {
+		t.Errorf("account = %+v", acct)
+	}
+	if got := AccountProblem(acct); got != "the API key expired at 1970-01-01T00:16:40Z" {
+		t.Errorf("AccountProblem = %q", got)
+	}
+
+	srv, _ = relayServer(t, map[string]string{
+		"/api/usage/token": `{"data":{"total_granted":0,"total_used":123,"unlimited_quota":true}}`,
+	})
+	acct, err = Account(&models.Config{BaseURL: srv.URL, APIKey: "sk-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acct.Unlimited || acct.Exhausted || AccountProblem(acct) != "" {
+		t.Errorf("unlimited = %+v", acct)
+	}
+}
+
+func TestAccountOneAPI(t *testing.T) {
+	srv, _ := relayServer(t, map[string]string{
+		// New API's endpoint answers with an error envelope on One API.
+		"/api/usage/token":                   `{"success":false,"message":"no such route"}`,
+		"/v1/dashboard/billing/subscription": `{"hard_limit_usd":20,"access_until":0}`,
+		"/v1/dashboard/billing/usage":        `{"total_usage":512.5}`,
+	})
+	acct, err := Account(&models.Config{BaseURL: srv.URL + "/v1/", APIKey: "sk-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.Provider != "oneapi" || acct.Granted != 20 || acct.Used != 5.125 || acct.Remaining != 14.875 || acct.ExpiresAt != "" || acct.Unlimited {
+		t.Errorf("account = %+v", acct)
+	}
+
+	srv, _ = relayServer(t, map[string]string{
+		"/v1/dashboard/billing/subscription": `{"hard_limit_usd":100000000}`,
+		"/v1/dashboard/billing/usage":        `{"total_usage":0}`,
+	})
+	acct, err = Account(&models.Config{BaseURL: srv.URL, APIKey: "sk-test", AccountAPI: "oneapi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acct.Unlimited {
+		t.Errorf("unlimited = %+v", acct)
+	}
+}
+
+func TestAccountUnsupported(t *testing.T) {
+	srv, _ := relayServer(t, nil)
+	if _, err := Account(&models.Config{BaseURL: srv.URL, APIKey: "sk-test"}); !errors.Is(err, ErrAccountUnsupported) {
+		t.Errorf("no endpoints: got %v", err)
+	}
+	if _, err := Account(&models.Config{BaseURL: srv.URL, APIKey: "sk-test", AccountAPI: "none"}); !errors.Is(err, ErrAccountUnsupported) {
+		t.Errorf("account_api none: got %v", err)
+	}
+	if _, err := Account(&models.Config{BaseURL: srv.URL, APIKey: "sk-test", AccountAPI: "nope"}); err == nil {
+		t.Error("unknown account_api: want an error")
+	}
+
+	// Relays that serve their SPA for unknown routes.
+	spa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<!doctype html>")
+	}))
+	defer spa.Close()
+	if _, err := Account(&models.Config{BaseURL: spa.URL, APIKey: "sk-test"}); !errors.Is(err, ErrAccountUnsupported) {
+		t.Errorf("HTML responses: got %v", err)
+	}
+
+	// A rejected key is reported once no API accepts it.
+	_, err := Account(&models.Config{BaseURL: srv.URL, APIKey: "sk-wrong"})
+	if ae := (*authError)(nil); !errors.As(err, &ae) {
+		t.Errorf("wrong key: got %v", err)
+	}
+}
+
+func TestCachedAccount(t *testing.T) {
+	srv, hits := relayServer(t, map[string]string{
+		"/api/usage/token": `{"data":{"total_granted":500000,"total_used":0}}`,
+	})
+	cfg := &models.Config{BaseURL: srv.URL, APIKey: "sk-test"}
+	for range 3 {
+		if acct, err := CachedAccount(cfg); err != nil || acct.Granted != 1 {
+			t.Fatalf("CachedAccount = %+v, %v", acct, err)
+		}
+	}
+	// One usage lookup and one usage-log lookup.
+	if n := hits.Load(); n != 2 {
+		t.Errorf("relay hit %d times, want 2", n)
+	}
+
+	// Errors are cached too, so an unsupported relay is not asked again.
+	bare, hits := relayServer(t, nil)
+	cfg = &models.Config{BaseURL: bare.URL, APIKey: "sk-test"}
+	CachedAccount(cfg)
+	n := hits.Load()
+	if _, err := CachedAccount(cfg); !errors.Is(err, ErrAccountUnsupported) || hits.Load() != n {
+		t.Errorf("cached error: got %v after %d hits, want %d", err, hits.Load(), n)
+	}
+}
+
+func TestCachedAccountTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	defer func(d time.Duration) { accountCheckTimeout = d }(accountCheckTimeout)
+	accountCheckTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err := CachedAccount(&models.Config{BaseURL: srv.URL, APIKey: "sk-test", AccountAPI: "newapi"})
+	if err == nil {
+		t.Fatal("hanging relay: want an error")
+	}
+	if d := time.Since(start); d > accountCheckTimeout+time.Second {
+		t.Errorf("took %v, want about %v", d, accountCheckTimeout)
+	}
+}
@@ -0,0 +1,249 @@
+package relay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"claude-relay/internal/config"
+	"claude-relay/internal/models"
+)
+
+// defaultCatalogTTL applies when Config.CatalogTTL is empty.
+const defaultCatalogTTL = time.Hour
+
+// maxCatalogHistory bounds the number of changes kept per relay.
+const maxCatalogHistory = 100
+
+// Catalog sources reported in CatalogResult.Source.
+const (
+	SourceLive        = "live"
+	SourceCache       = "cache"
+	SourceRevalidated = "revalidated"
+	SourceOffline     = "offline"
+)
+
+// cachedCatalog is the on-disk form of a relay catalog,
+// ~/.claude-relay/cache/catalog-<key>.json.
+type cachedCatalog struct {
+	URL       string    `json:"url"`
+	FetchedAt time.Time `json:"fetched_at"`
+	catalogValidators
+	Models []models.RelayModel `json:"models"`
+}
+
+// cacheLocks serialize the use of one catalog's cache files, fetch
+// included, so concurrent calls for the same relay and key wait for one
+// fetch instead of each hitting the relay. Other catalogs are not held up.
+var (
+	cacheLocksMu sync.Mutex
+	cacheLocks   = make(map[string]*sync.Mutex)
+)
+
+// lockCache locks the cache of cfg's catalog and returns the unlock function.
+func lockCache(cfg *models.Config) func() {
+	key := cacheKey(cfg)
+	cacheLocksMu.Lock()
+	l, ok := cacheLocks[key]
+	if !ok {
+		l = new(sync.Mutex)
+		cacheLocks[key] = l
+	}
+	cacheLocksMu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// cacheKey identifies a relay and credential without storing the key itself.
+func cacheKey(cfg *models.Config) string {
+	sum := sha256.Sum256([]byte(modelsURL(cfg) + "\x00" + cfg.CatalogFormat + "\x00" + cfg.APIKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+func catalogFile(cfg *models.Config) string {
+	return filepath.Join(config.CacheDir(), "catalog-"+cacheKey(cfg)+".json")
+}
+
+func historyFile(cfg *models.Config) string {
+	return filepath.Join(config.CacheDir(), "history-"+cacheKey(cfg)+".json")
+}
+
+// catalogTTL parses Config.CatalogTTL; "0" disables the TTL so every call
+// revalidates with the relay.
+func catalogTTL(cfg *models.Config) time.Duration {
+	if cfg.CatalogTTL == "" {
+		return defaultCatalogTTL
+	}
+	d, err := time.ParseDuration(cfg.CatalogTTL)
+	if err != nil || d < 0 {
+		return defaultCatalogTTL
+	}
+	return d
+}
+
+// Catalog returns the relay catalog, served from the cache while it is
+// younger than Config.CatalogTTL unless refresh is set. Expired entries are
+// revalidated with If-None-Match/If-Modified-Since. When the relay cannot be
+// reached the last cached copy is returned with Source "offline". Every fetch
+// that changes the model list is appended to the catalog history.
+func Catalog(cfg *models.Config, refresh bool) (*models.CatalogResult, error) {
+	defer lockCache(cfg)()
+
+	cached, _ := readCachedCatalog(cfg)
+	if cached != nil && !refresh && time.Since(cached.FetchedAt) < catalogTTL(cfg) {
+		return catalogResult(cached, SourceCache), nil
+	}
+
+	var cond catalogValidators
+	if cached != nil {
+		cond = cached.catalogValidators
+	}
+	list, validators, err := fetchCatalog(cfg, cond)
+	switch {
+	case errors.Is(err, errNotModified):
+		cached.FetchedAt = time.Now().UTC()
+		if err := writeCachedCatalog(cfg, cached); err != nil {
+			return nil, err
+		}
+		return catalogResult(cached, SourceRevalidated), nil
+	case err != nil:
+		if cached == nil {
+			return nil, err
+		}
+		res := catalogResult(cached, SourceOffline)
+		res.Error = err.Error()
+		return res, nil
+	}
+
+	fresh := &cachedCatalog{URL: modelsURL(cfg), FetchedAt: time.Now().UTC(), catalogValidators: validators, Models: list}
+	if err := writeCachedCatalog(cfg, fresh); err != nil {
+		return nil, err
+	}
+	res := catalogResult(fresh, SourceLive)
+	if cached != nil {
+		if change := diffCatalogs(cached.Models, list); change != nil {
+			change.CheckedAt = fresh.FetchedAt.Format(time.RFC3339)
+			if err := appendCatalogHistory(cfg, *change); err != nil {
+				return nil, err
+			}
+			res.Changes = change
+		}
+	}
+	return res, nil
+}
+
+// CachedModels returns the catalog models, from the cache when possible.
+func CachedModels(cfg *models.Config) ([]models.RelayModel, error) {
+	res, err := Catalog(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	return res.Models, nil
+}
+
+// CatalogHistory returns the recorded catalog changes, newest first.
+func CatalogHistory(cfg *models.Config) ([]models.CatalogChange, error) {
+	defer lockCache(cfg)()
+	history, err := readCatalogHistory(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history, nil
+}
+
+func catalogResult(c *cachedCatalog, source string) *models.CatalogResult {
+	return &models.CatalogResult{
+		Models:    c.Models,
+		Source:    source,
+		FetchedAt: c.FetchedAt.Format(time.RFC3339),
+	}
+}
+
+// diffCatalogs returns the models added and removed between two catalogs, or
+// nil if the ID sets are equal.
+func diffCatalogs(prev, cur []models.RelayModel) *models.CatalogChange {
+	before := make(map[string]bool, len(prev))
+	for _, m := range prev {
+		before[m.ID] = true
+	}
+	change := &models.CatalogChange{Added: []string{}, Removed: []string{}}
+	for _, m := range cur {
+		if !before[m.ID] {
+			change.Added = append(change.Added, m.ID)
+		}
+		delete(before, m.ID)
+	}
+	for id := range before {
+		change.Removed = append(change.Removed, id)
+	}
+	if len(change.Added) == 0 && len(change.Removed) == 0 {
+		return nil
+	}
+	sort.Strings(change.Added)
+	sort.Strings(change.Removed)
+	return change
+}
+
+func readCachedCatalog(cfg *models.Config) (*cachedCatalog, error) {
+	data, err := os.ReadFile(catalogFile(cfg))
+	if err != nil {
+		return nil, err
+	}
+	var c cachedCatalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parse catalog cache: %w", err)
+	}
+	return &c, nil
+}
+
+func writeCachedCatalog(cfg *models.Config, c *cachedCatalog) error {
+	return writeCacheFile(catalogFile(cfg), c)
+}
+
+func readCatalogHistory(cfg *models.Config) ([]models.CatalogChange, error) {
+	data, err := os.ReadFile(historyFile(cfg))
+	if os.IsNotExist(err) {
+		return []models.CatalogChange{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var history []models.CatalogChange
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("parse catalog history: %w", err)
+	}
+	return history, nil
+}
+
+func appendCatalogHistory(cfg *models.Config, change models.CatalogChange) error {
+	history, err := readCatalogHistory(cfg)
+	if err != nil {
+		history = nil // start over rather than fail the fetch
+	}
+	history = append(history, change)
+	if len(history) > maxCatalogHistory {
+		history = history[len(history)-maxCatalogHistory:]
+	}
+	return writeCacheFile(historyFile(cfg), history)
+}
+
+func writeCacheFile(path string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,253 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"claude-relay/internal/config"
+	"claude-relay/internal/models"
+)
+
+// catalogServer is a relay whose catalog can be changed or taken down
+// between requests. It answers If-None-Match with 304 while the catalog is
+// unchanged.
+type catalogServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	ids   []string
+	down  bool
+	hits  int
+	conds []string // If-None-Match of each request
+}
+
+func newCatalogServer(t *testing.T, ids ...string) *catalogServer {
+	t.Helper()
+	s := &catalogServer{ids: ids}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.hits++
+		s.conds = append(s.conds, r.Header.Get("If-None-Match"))
+		if s.down {
+			http.Error(w, "upstream unavailable", http.StatusBadGateway)
+			return
+		}
+		etag := `"` + strings.Join(s.ids, ",") + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		var data []string
+		for _, id := range s.ids {
+			data = append(data, fmt.Sprintf(`{"id":%q,"object":"model","owned_by":"anthropic"}`, id))
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"object":"list","data":[%s]}`, strings.Join(data, ","))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *catalogServer) set(down bool, ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+	if ids != nil {
+		s.ids = ids
+	}
+}
+
+func (s *catalogServer) stats() (int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits, append([]string(nil), s.conds...)
+}
+
+// setupCache points the cache at a fresh HOME.
+func setupCache(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	config.Init()
+}
+
+func modelIDs(res *models.CatalogResult) []string {
+	var ids []string
+	for _, m := range res.Models {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestCatalogTTL(t *testing.T) {
+	setupCache(t)
+	srv := newCatalogServer(t, "claude-opus-4-6")
+	cfg := &models.Config{BaseURL: srv.URL, APIKey: "sk-test"}
+
+	res, err := Catalog(cfg, false)
+	if err != nil || res.Source != SourceLive || !reflect.DeepEqual(modelIDs(res), []string{"claude-opus-4-6"}) {
+		t.Fatalf("first call: %+v, %v", res, err)
+	}
+	res, err = Catalog(cfg, false)
+	if err != nil || res.Source != SourceCache || !reflect.DeepEqual(modelIDs(res), []string{"claude-opus-4-6"}) {
+		t.Errorf("within the TTL: %+v, %v", res, err)
+	}
+	if hits, _ := srv.stats(); hits != 1 {
+		t.Errorf("relay hit %d times within the TTL", hits)
+	}
+
+	// refresh bypasses the TTL but still revalidates.
+	if res, err := Catalog(cfg, true); err != nil || res.Source != SourceRevalidated {
+		t.Errorf("refresh: %+v, %v", res, err)
+	}
+	// A catalog from another relay or key is cached apart.
+	other := &models.Config{BaseURL: srv.URL, APIKey: "sk-other"}
+	if res, err := Catalog(other, false); err != nil || res.Source != SourceLive {
+		t.Errorf("other key: %+v, %v", res, err)
+	}
+}
+
+func TestCatalogETag(t *testing.T) {
+	setupCache(t)
+	srv := newCatalogServer(t, "claude-opus-4-6")
+	cfg := &models.Config{BaseURL: srv.URL, APIKey: "sk-test"}
+
+	if _, err := Catalog(cfg, false); err != nil {
+		t.Fatal(err)
+	}
+	// Let the cached copy expire.
+	cached, err := readCachedCatalog(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached.FetchedAt = cached.FetchedAt.Add(-2 * defaultCatalogTTL)
+	if err := writeCachedCatalog(cfg, cached); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Catalog(cfg, false)
+	if err != nil || res.Source != SourceRevalidated || !reflect.DeepEqual(modelIDs(res), []string{"claude-opus-4-6"}) {
+		t.Fatalf("unchanged: %+v, %v", res, err)
+	}
+	if _, conds := srv.stats(); !reflect.DeepEqual(conds, []string{"", `"claude-opus-4-6"`}) {
+		t.Errorf("If-None-Match sent: %q", conds)
+	}
+	// Revalidation renews the TTL.
+	if res, err := Catalog(cfg, false); err != nil || res.Source != SourceCache {
+		t.Errorf("after revalidation: %+v, %v", res, err)
+	}
+
+	srv.set(false, "claude-opus-4-6", "claude-sonnet-4-6")
+	res, err = Catalog(cfg, true)
+	if err != nil || res.Source != SourceLive || len(res.Models) != 2 {
+		t.Errorf("changed: %+v, %v", res, err)
+	}
+}
+
+func TestCatalogOffline(t *testing.T) {
+	setupCache(t)
+	srv := newCatalogServer(t, "claude-opus-4-6")
+	cfg := &models.Config{BaseURL: srv.URL, APIKey: "sk-test", CatalogTTL: "0"}
+
+	// Without a cached copy the error is returned.
+	srv.set(true)
+	if res, err := Catalog(cfg, false); err == nil {
+		t.Fatalf("no cache, relay down: %+v", res)
+	}
+
+	srv.set(false)
+	if _, err := Catalog(cfg, false); err != nil {
+		t.Fatal(err)
+	}
+	srv.set(true)
+	res, err := Catalog(cfg, false)
+	if err != nil || res.Source != SourceOffline || res.Error == "" || !reflect.DeepEqual(modelIDs(res), []string{"claude-opus-4-6"}) {
+		t.Errorf("relay down: %+v, %v", res, err)
+	}
+	if ids, err := CachedModels(cfg); err != nil || len(ids) != 1 {
+		t.Errorf("CachedModels offline: %v, %v", ids, err)
+	}
+}
+
+func TestCatalogHistory(t *testing.T) {
+	setupCache(t)
+	srv := newCatalogServer(t, "claude-opus-4-5", "claude-sonnet-4-5")
+	cfg := &models.Config{BaseURL: srv.URL, APIKey: "sk-test", CatalogTTL: "0"}
+
+	if history, err := CatalogHistory(cfg); err != nil || len(history) != 0 {
+		t.Fatalf("empty history: %v, %v", history, err)
+	}
+	// The first fetch has nothing to compare with.
+	if res, err := Catalog(cfg, false); err != nil || res.Changes != nil {
+		t.Fatalf("first fetch: %+v, %v", res, err)
+	}
+	srv.set(false, "claude-opus-4-5", "claude-opus-4-6", "claude-sonnet-4-5")
+	res, err := Catalog(cfg, false)
+	if err != nil || res.Changes == nil || !reflect.DeepEqual(res.Changes.Added, []string{"claude-opus-4-6"}) {
+		t.Fatalf("added: %+v, %v", res, err)
+	}
+	// Unchanged or revalidated fetches add nothing.
+	if _, err := Catalog(cfg, false); err != nil {
+		t.Fatal(err)
+	}
+	srv.set(false, "claude-opus-4-6", "claude-sonnet-4-5")
+	if _, err := Catalog(cfg, false); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := CatalogHistory(cfg)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("history: %+v, %v", history, err)
+	}
+	// Newest first.
+	if !reflect.DeepEqual(history[0].Removed, []string{"claude-opus-4-5"}) || len(history[0].Added) != 0 ||
+		!reflect.DeepEqual(history[1].Added, []string{"claude-opus-4-6"}) || history[1].CheckedAt == "" {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+// TestCatalogFetchLocksOneCatalog checks that a slow relay only holds up
+// callers of the same catalog.
+func TestCatalogFetchLocksOneCatalog(t *testing.T) {
+	setupCache(t)
+	release := make(chan struct{})
+	entered := make(chan struct{}, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		fmt.Fprint(w, `{"object":"list","data":[{"id":"claude-opus-4-6","object":"model"}]}`)
+	}))
+	defer slow.Close()
+	fast := newCatalogServer(t, "claude-sonnet-4-6")
+
+	slowDone := make(chan struct{})
+	defer func() {
+		close(release)
+		<-slowDone
+	}()
+	go func() {
+		Catalog(&models.Config{BaseURL: slow.URL, APIKey: "sk-test"}, false)
+		close(slowDone)
+	}()
+	<-entered
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := Catalog(&models.Config{BaseURL: fast.URL, APIKey: "sk-test"}, false)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetch from one relay blocked on another")
+	}
+}
@@ -0,0 +1,380 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"claude-relay/internal/models"
+)
+
+// CatalogAdapter parses one shape of relay model list into RelayModels.
+// Adapters are tried in registration order when the format is auto-detected.
+type CatalogAdapter interface {
+	// Name is the value used for Config.CatalogFormat.
+	Name() string
+	// Detect reports whether body looks like this adapter's format.
+	Detect(body []byte) bool
+	// Parse decodes one page of the catalog.
+	Parse(body []byte) (*CatalogPage, error)
+}
+
+// CatalogPage is one decoded page of a model catalog. HasMore/LastID are
+// only set by formats that paginate.
+type CatalogPage struct {
+	Models  []models.RelayModel
+	HasMore bool
+	LastID  string
+}
+
+var catalogAdapters = []CatalogAdapter{
+	anthropicAdapter{},
+	openRouterAdapter{},
+	openAIAdapter{},
+	arrayAdapter{},
+}
+
+// RegisterCatalogAdapter adds a custom adapter. It takes precedence over the
+// built-in ones during auto-detection.
+func RegisterCatalogAdapter(a CatalogAdapter) {
+	catalogAdapters = append([]CatalogAdapter{a}, catalogAdapters...)
+}
+
+// catalogAdapter returns the adapter for an explicit format name, or the
+// first one whose Detect accepts body.
+func catalogAdapter(format string, body []byte) (CatalogAdapter, error) {
+	if format != "" && format != "auto" {
+		for _, a := range catalogAdapters {
+			if a.Name() == format {
+				return a, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown catalog format %q", format)
+	}
+	for _, a := range catalogAdapters {
+		if a.Detect(body) {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("unrecognized model catalog format")
+}
+
+// catalogProbe is the common envelope used for detection.
+type catalogProbe struct {
+	Object  string                       `json:"object"`
+	Data    []map[string]json.RawMessage `json:"data"`
+	HasMore *bool                        `json:"has_more"`
+}
+
+func probe(body []byte) (*catalogProbe, bool) {
+	var p catalogProbe
+	if err := json.Unmarshal(body, &p); err != nil || p.Data == nil {
+		return nil, false
+	}
+	return &p, true
+}
+
+// --- Anthropic: {data:[{id,display_name,created_at,type}],has_more,first_id,last_id} ---
+
+type anthropicAdapter struct{}
+
+func (anthropicAdapter) Name() string { return "anthropic" }
+
+func (anthropicAdapter) Detect(body []byte) bool {
+	p, ok := probe(body)
+	if !ok {
+		return false
+	}
+	if p.HasMore != nil {
+		return true
+	}
+	if len(p.Data) > 0 {
+		_, hasDisplay := p.Data[0]["display_name"]
+		return hasDisplay
+	}
+	return false
+}
+
+func (anthropicAdapter) Parse(body []byte) (*CatalogPage, error) {
+	var resp struct {
+		Data []struct {
+			ID          string `json:"id"`
+			DisplayName string `json:"display_name"`
+			CreatedAt   string `json:"created_at"`
+			Type        string `json:"type"`
+		} `json:"data"`
+		HasMore bool   `json:"has_more"`
+		LastID  string `json:"last_id"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	page := &CatalogPage{HasMore: resp.HasMore, LastID: resp.LastID}
+	for _, d := range resp.Data {
+		page.Models = append(page.Models, models.RelayModel{
+			ID:          d.ID,
+			DisplayName: d.DisplayName,
+			CreatedAt:   d.CreatedAt,
+			Type:        d.Type,
+			Provider:    "anthropic",
+		})
+	}
+	return page, nil
+}
+
+// --- OpenRouter: {data:[{id:"anthropic/...",name,created,context_length,pricing,architecture}]} ---
+
+type openRouterAdapter struct{}
+
+func (openRouterAdapter) Name() string { return "openrouter" }
+
+func (openRouterAdapter) Detect(body []byte) bool {
+	p, ok := probe(body)
+	if !ok || len(p.Data) == 0 {
+		return false
+	}
+	_, hasPricing := p.Data[0]["pricing"]
+	_, hasContext := p.Data[0]["context_length"]
+	return hasPricing || hasContext
+}
+
+func (openRouterAdapter) Parse(body []byte) (*CatalogPage, error) {
+	var resp struct {
+		Data []struct {
+			ID            string `json:"id"`
+			Name          string `json:"name"`
+			Created       int64  `json:"created"`
+			ContextLength int    `json:"context_length"`
+			Pricing       struct {
+				Prompt     string `json:"prompt"`
+				Completion string `json:"completion"`
+			} `json:"pricing"`
+			Architecture struct {
+				InputModalities []string `json:"input_modalities"`
+			} `json:"architecture"`
+			SupportedParameters []string `json:"supported_parameters"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	page := &CatalogPage{}
+	for _, d := range resp.Data {
+		m := models.RelayModel{
+			ID:            d.ID,
+			DisplayName:   d.Name,
+			CreatedAt:     unixToRFC3339(d.Created),
+			ContextLength: d.ContextLength,
+		}
+		if d.Pricing.Prompt != "" || d.Pricing.Completion != "" {
+			m.Pricing = &models.ModelPricing{Prompt: d.Pricing.Prompt, Completion: d.Pricing.Completion}
+		}
+		for _, mod := range d.Architecture.InputModalities {
+			if mod == "image" {
+				m.Capabilities = append(m.Capabilities, "vision")
+			}
+		}
+		for _, param := range d.SupportedParameters {
+			if param == "reasoning" {
+				m.Capabilities = append(m.Capabilities, "thinking")
+			}
+		}
+		page.Models = append(page.Models, m)
+	}
+	return page, nil
+}
+
+// --- OpenAI: {object:"list",data:[{id,object:"model",created,owned_by}]} ---
+
+type openAIAdapter struct{}
+
+func (openAIAdapter) Name() string { return "openai" }
+
+func (openAIAdapter) Detect(body []byte) bool {
+	// Also the fallback for any {data:[{id}]} envelope.
+	_, ok := probe(body)
+	return ok
+}
+
+func (openAIAdapter) Parse(body []byte) (*CatalogPage, error) {
+	var resp struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Created int64  `json:"created"`
+			OwnedBy string `json:"owned_by"`
+			Object  string `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	page := &CatalogPage{}
+	for _, d := range resp.Data {
+		page.Models = append(page.Models, models.RelayModel{
+			ID:        d.ID,
+			CreatedAt: unixToRFC3339(d.Created),
+			OwnedBy:   d.OwnedBy,
+			Type:      d.Object,
+		})
+	}
+	return page, nil
+}
+
+// --- Bare array: ["id", ...] or [{id|name}, ...] ---
+
+type arrayAdapter struct{}
+
+func (arrayAdapter) Name() string { return "array" }
+
+func (arrayAdapter) Detect(body []byte) bool {
+	var raw []json.RawMessage
+	return json.Unmarshal(body, &raw) == nil
+}
+
+func (arrayAdapter) Parse(body []byte) (*CatalogPage, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+	page := &CatalogPage{}
+	for _, item := range raw {
+		var id string
+		if json.Unmarshal(item, &id) != nil {
+			var obj struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal(item, &obj); err != nil {
+				return nil, fmt.Errorf("unsupported catalog entry: %s", item)
+			}
+			id = obj.ID
+			if id == "" {
+				id = obj.Name
+			}
+		}
+		if id != "" {
+			page.Models = append(page.Models, models.RelayModel{ID: id})
+		}
+	}
+	return page, nil
+}
+
+func unixToRFC3339(sec int64) string {
+	if sec <= 0 {
+		return ""
+	}
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339)
+}
+
+// --- Normalization ---
+
+// ParseModelID extracts provider, family, tier, version, date and
+// capabilities from a model ID. It understands both Anthropic styles
+// ("claude-3-5-sonnet-20241022", "claude-opus-4-6-20260205"), dotted VS Code
+// IDs ("claude-opus-4.6"), vendor prefixes ("anthropic/...") and common
+// suffixes ("-thinking", ":thinking", "[1m]", "-latest").
+func ParseModelID(id string) models.RelayModel {
+	m := models.RelayModel{ID: id}
+	s := strings.ToLower(id)
+
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		m.Provider = s[:i]
+		s = s[i+1:]
+	}
+	if strings.HasSuffix(s, "[1m]") {
+		m.Capabilities = append(m.Capabilities, "1m-context")
+		s = strings.TrimSuffix(s, "[1m]")
+	}
+	if i := strings.Index(s, ":"); i >= 0 {
+		if strings.Contains(s[i:], "thinking") {
+			m.Capabilities = append(m.Capabilities, "thinking")
+		}
+		s = s[:i]
+	}
+
+	tokens := strings.FieldsFunc(s, func(r rune) bool { return r == '-' || r == '.' || r == '_' })
+	var version []string
+	for i, tok := range tokens {
+		switch {
+		case i == 0:
+			m.Family = tok
+		case tok == "opus" || tok == "sonnet" || tok == "haiku":
+			m.Tier = tok
+		case tok == "thinking":
+			m.Capabilities = append(m.Capabilities, "thinking")
+		case len(tok) == 8 && isDigits(tok):
+			m.Date = tok
+		case len(tok) <= 2 && isDigits(tok):
+			version = append(version, tok)
+		}
+	}
+	m.Version = strings.Join(version, ".")
+	if m.Provider == "" && m.Family == "claude" {
+		m.Provider = "anthropic"
+	}
+	return m
+}
+
+// deprecatedModels lists Claude releases that Anthropic has deprecated or
+// retired, as "tier version" (tier empty for pre-Claude 3 models). Relays
+// often keep listing them long after they stop working reliably.
+var deprecatedModels = map[string]bool{
+	" 1":         true,
+	" 2":         true,
+	" 2.1":       true,
+	"opus 3":     true,
+	"sonnet 3":   true,
+	"sonnet 3.5": true,
+	"sonnet 3.7": true,
+	"haiku 3.5":  true,
+}
+
+// IsDeprecated reports whether id is a deprecated Claude release.
+func IsDeprecated(id string) bool {
+	p := ParseModelID(id)
+	if p.Family != "claude" {
+		return false
+	}
+	if strings.Contains(strings.ToLower(id), "instant") {
+		return true
+	}
+	return deprecatedModels[p.Tier+" "+p.Version]
+}
+
+// normalizeModel fills the parsed fields of m without overwriting what the
+// relay already reported.
+func normalizeModel(m *models.RelayModel) {
+	p := ParseModelID(m.ID)
+	if m.Provider == "" {
+		m.Provider = p.Provider
+		if m.Provider == "" {
+			m.Provider = m.OwnedBy
+		}
+	}
+	m.Family, m.Tier, m.Version, m.Date = p.Family, p.Tier, p.Version, p.Date
+	m.Deprecated = m.Deprecated || IsDeprecated(m.ID)
+	for _, c := range p.Capabilities {
+		if !containsString(m.Capabilities, c) {
+			m.Capabilities = append(m.Capabilities, c)
+		}
+	}
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
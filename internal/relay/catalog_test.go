@@ -0,0 +1,163 @@
+package relay
+
+import (
+	"reflect"
+	"testing"
+
+	"claude-relay/internal/models"
+)
+
+func TestParseModelID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want models.RelayModel
+	}{
+		{"claude-3-5-sonnet-20241022", models.RelayModel{Provider: "anthropic", Family: "claude", Tier: "sonnet", Version: "3.5", Date: "20241022"}},
+		{"claude-opus-4-6-20260205", models.RelayModel{Provider: "anthropic", Family: "claude", Tier: "opus", Version: "4.6", Date: "20260205"}},
+		{"claude-opus-4.6", models.RelayModel{Provider: "anthropic", Family: "claude", Tier: "opus", Version: "4.6"}},
+		{"claude-haiku-4-5", models.RelayModel{Provider: "anthropic", Family: "claude", Tier: "haiku", Version: "4.5"}},
+		{"anthropic/claude-sonnet-4.5", models.RelayModel{Provider: "anthropic", Family: "claude", Tier: "sonnet", Version: "4.5"}},
+		{"openrouter/anthropic/claude-3.7-sonnet:thinking", models.RelayModel{Provider: "openrouter/anthropic", Family: "claude", Tier: "sonnet", Version: "3.7", Capabilities: []string{"thinking"}}},
+		{"claude-sonnet-4-20250514-thinking", models.RelayModel{Provider: "anthropic", Family: "claude", Tier: "sonnet", Version: "4", Date: "20250514", Capabilities: []string{"thinking"}}},
+		{"claude-sonnet-4-5[1m]", models.RelayModel{Provider: "anthropic", Family: "claude", Tier: "sonnet", Version: "4.5", Capabilities: []string{"1m-context"}}},
+		{"Claude-Opus-4-Latest", models.RelayModel{Provider: "anthropic", Family: "claude", Tier: "opus", Version: "4"}},
+		{"claude-2.1", models.RelayModel{Provider: "anthropic", Family: "claude", Version: "2.1"}},
+		{"gpt-4o", models.RelayModel{Family: "gpt"}},
+		{"", models.RelayModel{}},
+	}
+	for _, tt := range tests {
+		got := ParseModelID(tt.id)
+		tt.want.ID = tt.id
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseModelID(%q) = %+v, want %+v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestIsDeprecated(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"claude-3-5-sonnet-20241022", true},
+		{"claude-3-7-sonnet-20250219", true},
+		{"claude-3-opus-20240229", true},
+		{"claude-3-5-haiku-20241022", true},
+		{"claude-2.1", true},
+		{"claude-instant-1.2", true},
+		{"claude-3-haiku-20240307", false},
+		{"claude-sonnet-4-5", false},
+		{"claude-opus-4.6", false},
+		{"gpt-3.5-turbo", false},
+	}
+	for _, tt := range tests {
+		if got := IsDeprecated(tt.id); got != tt.want {
+			t.Errorf("IsDeprecated(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestCatalogAdapterDetect(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"anthropic paginated", `{"data":[{"id":"claude-opus-4-6"}],"has_more":false,"last_id":"claude-opus-4-6"}`, "anthropic"},
+		{"anthropic display name", `{"data":[{"id":"claude-opus-4-6","display_name":"Claude Opus 4.6"}]}`, "anthropic"},
+		{"openrouter", `{"data":[{"id":"anthropic/claude-opus-4.6","pricing":{"prompt":"0.000015"}}]}`, "openrouter"},
+		{"openai", `{"object":"list","data":[{"id":"claude-opus-4-6","object":"model","owned_by":"anthropic"}]}`, "openai"},
+		{"empty data", `{"data":[]}`, "openai"},
+		{"bare strings", `["claude-opus-4-6"]`, "array"},
+		{"bare objects", `[{"name":"claude-opus-4-6"}]`, "array"},
+	}
+	for _, tt := range tests {
+		a, err := catalogAdapter("", []byte(tt.body))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if a.Name() != tt.want {
+			t.Errorf("%s: detected %s, want %s", tt.name, a.Name(), tt.want)
+		}
+	}
+
+	if _, err := catalogAdapter("", []byte(`{"models":[]}`)); err == nil {
+		t.Error("unknown shape: want an error")
+	}
+	if _, err := catalogAdapter("nope", nil); err == nil {
+		t.Error("unknown format name: want an error")
+	}
+	if a, err := catalogAdapter("array", []byte(`{"data":[]}`)); err != nil || a.Name() != "array" {
+		t.Errorf("explicit format: got %v, %v", a, err)
+	}
+}
+
+func TestCatalogAdapterParse(t *testing.T) {
+	tests := []struct {
+		adapter CatalogAdapter
+		body    string
+		want    *CatalogPage
+	}{
+		{
+			anthropicAdapter{},
+			`{"data":[{"id":"claude-opus-4-6","display_name":"Claude Opus 4.6","created_at":"2026-02-05T00:00:00Z","type":"model"}],"has_more":true,"last_id":"claude-opus-4-6"}`,
+			&CatalogPage{
+				Models:  []models.RelayModel{{ID: "claude-opus-4-6", DisplayName: "Claude Opus 4.6", CreatedAt: "2026-02-05T00:00:00Z", Type: "model", Provider: "anthropic"}},
+				HasMore: true,
+				LastID:  "claude-opus-4-6",
+			},
+		},
+		{
+			openRouterAdapter{},
+			`{"data":[{"id":"anthropic/claude-sonnet-4.5","name":"Claude Sonnet 4.5","created":1759104000,"context_length":1000000,"pricing":{"prompt":"0.000003","completion":"0.000015"},"architecture":{"input_modalities":["text","image"]},"supported_parameters":["tools","reasoning"]}]}`,
+			&CatalogPage{Models: []models.RelayModel{{
+				ID:            "anthropic/claude-sonnet-4.5",
+				DisplayName:   "Claude Sonnet 4.5",
+				CreatedAt:     "2025-09-29T00:00:00Z",
+				ContextLength: 1000000,
+				Pricing:       &models.ModelPricing{Prompt: "0.000003", Completion: "0.000015"},
+				Capabilities:  []string{"vision", "thinking"},
+			}}},
+		},
+		{
+			openAIAdapter{},
+			`{"object":"list","data":[{"id":"claude-haiku-4-5","object":"model","created":0,"owned_by":"anthropic"}]}`,
+			&CatalogPage{Models: []models.RelayModel{{ID: "claude-haiku-4-5", OwnedBy: "anthropic", Type: "model"}}},
+		},
+		{
+			arrayAdapter{},
+			`["claude-opus-4-6",{"id":"claude-sonnet-4-5"},{"name":"claude-haiku-4-5"},""]`,
+			&CatalogPage{Models: []models.RelayModel{{ID: "claude-opus-4-6"}, {ID: "claude-sonnet-4-5"}, {ID: "claude-haiku-4-5"}}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := tt.adapter.Parse([]byte(tt.body))
+		if err != nil {
+			t.Errorf("%s: %v", tt.adapter.Name(), err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.adapter.Name(), got, tt.want)
+		}
+	}
+
+	if _, err := (arrayAdapter{}).Parse([]byte(`[1]`)); err == nil {
+		t.Error("array of numbers: want an error")
+	}
+}
+
+func TestNormalizeModelKeepsRelayFields(t *testing.T) {
+	m := models.RelayModel{ID: "claude-3-5-sonnet-20241022", OwnedBy: "vertex", Capabilities: []string{"vision"}}
+	normalizeModel(&m)
+	if m.Provider != "anthropic" || m.Tier != "sonnet" || m.Version != "3.5" || !m.Deprecated {
+		t.Errorf("normalized = %+v", m)
+	}
+	if !reflect.DeepEqual(m.Capabilities, []string{"vision"}) {
+		t.Errorf("capabilities = %v", m.Capabilities)
+	}
+
+	m = models.RelayModel{ID: "my-model", OwnedBy: "someone"}
+	normalizeModel(&m)
+	if m.Provider != "someone" {
+		t.Errorf("provider falls back to owned_by: got %q", m.Provider)
+	}
+}
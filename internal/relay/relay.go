@@ -1,120 +1,199 @@
 package relay
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	neturl "net/url"
 	"sort"
 	"strings"
-	"time"
 
 	"claude-relay/internal/models"
 )
 
-type modelsResponse struct {
-	Data []models.RelayModel `json:"data"`
+// anthropicVersion is sent with every catalog request; the official API
+// requires it and OpenAI-style relays ignore it.
+const anthropicVersion = "2023-06-01"
+
+// maxModelPages bounds pagination in case a relay keeps reporting has_more.
+const maxModelPages = 50
+
+// authError marks a 401/403 so auto-detection can retry with the other style.
+type authError struct{ status int }
+
+func (e *authError) Error() string { return fmt.Sprintf("relay returned HTTP %d", e.status) }
+
+// FetchModels queries the relay's model catalog, following all pages.
+// The response shape is handled by a CatalogAdapter (Config.CatalogFormat,
+// or auto-detected from the first page) and every model is normalized.
+// It always hits the relay; see Catalog for the cached variant.
+func FetchModels(cfg *models.Config) ([]models.RelayModel, error) {
+	all, _, err := fetchCatalog(cfg, catalogValidators{})
+	return all, err
 }
 
-// FetchModels queries the relay's /v1/models endpoint.
-func FetchModels(baseURL, apiKey string) ([]models.RelayModel, error) {
-	base := strings.TrimRight(baseURL, "/")
-	// Avoid double /v1 if base_url already ends with /v1
-	url := base + "/models"
-	if !strings.HasSuffix(base, "/v1") {
-		url = base + "/v1/models"
-	}
+// catalogValidators are the HTTP cache validators of a catalog's first page.
+type catalogValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
 
-	client := &http.Client{Timeout: 15 * time.Second}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
-	}
-	if apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+apiKey)
-	}
+// errNotModified is returned by fetchCatalog when the relay answered the
+// conditional request for the first page with 304.
+var errNotModified = errors.New("catalog not modified")
 
-	resp, err := client.Do(req)
+func fetchCatalog(cfg *models.Config, cond catalogValidators) ([]models.RelayModel, catalogValidators, error) {
+	url := modelsURL(cfg)
+	client, err := HTTPClient(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("relay unreachable: %w", err)
+		return nil, catalogValidators{}, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("relay returned HTTP %d", resp.StatusCode)
+	style := authStyle(cfg)
+	var adapter CatalogAdapter
+	var all []models.RelayModel
+	var validators catalogValidators
+	after := ""
+	for page := 0; page < maxModelPages; page++ {
+		pageCond := catalogValidators{}
+		if page == 0 {
+			pageCond = cond
+		}
+		body, v, err := fetchModelsPage(client, url, after, cfg.APIKey, style, pageCond)
+		var authErr *authError
+		if page == 0 && errors.As(err, &authErr) && autoAuth(cfg) {
+			// Auto-detect: the relay rejected our header style, try the other one.
+			style = otherAuthStyle(style)
+			body, v, err = fetchModelsPage(client, url, after, cfg.APIKey, style, pageCond)
+		}
+		if err != nil {
+			return nil, catalogValidators{}, err
+		}
+		if page == 0 {
+			validators = v
+		}
+		if adapter == nil {
+			if adapter, err = catalogAdapter(cfg.CatalogFormat, body); err != nil {
+				return nil, catalogValidators{}, err
+			}
+		}
+		p, err := adapter.Parse(body)
+		if err != nil {
+			return nil, catalogValidators{}, fmt.Errorf("decode %s catalog: %w", adapter.Name(), err)
+		}
+		all = append(all, p.Models...)
+		if !p.HasMore || p.LastID == "" || p.LastID == after {
+			break
+		}
+		after = p.LastID
 	}
 
-	var result modelsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("decode response: %w", err)
+	for i := range all {
+		normalizeModel(&all[i])
 	}
 
 	// Sort by ID for consistent display
-	sort.Slice(result.Data, func(i, j int) bool {
-		return result.Data[i].ID < result.Data[j].ID
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].ID < all[j].ID
 	})
 
-	return result.Data, nil
+	return all, validators, nil
 }
 
-// SuggestMappings generates recommended model mappings from detected models.
-func SuggestMappings(available []models.RelayModel) []models.ModelMapping {
-	ids := make([]string, len(available))
-	for i, m := range available {
-		ids[i] = m.ID
+// modelsURL returns the catalog URL. Config.ModelsPath overrides the default
+// /v1/models, either as a path below BaseURL or as an absolute URL.
+func modelsURL(cfg *models.Config) string {
+	if strings.HasPrefix(cfg.ModelsPath, "http://") || strings.HasPrefix(cfg.ModelsPath, "https://") {
+		return cfg.ModelsPath
 	}
+	base := strings.TrimRight(cfg.BaseURL, "/")
+	if cfg.ModelsPath != "" {
+		return base + "/" + strings.TrimLeft(cfg.ModelsPath, "/")
+	}
+	// Avoid double /v1 if base_url already ends with /v1
+	if strings.HasSuffix(base, "/v1") {
+		return base + "/models"
+	}
+	return base + "/v1/models"
+}
 
-	var mappings []models.ModelMapping
+func autoAuth(cfg *models.Config) bool {
+	return cfg.RelayAuth == "" || cfg.RelayAuth == models.RelayAuthAuto
+}
 
-	// Find best main model (opus)
-	if best := findBest(ids, []string{"thinking", "opus-4-6", "opus-3-5", "opus"}); best != "" {
-		mappings = append(mappings, models.ModelMapping{VSCodeID: "claude-opus-4.6", RelayID: best})
+// authStyle picks the header style: explicit config wins, otherwise the
+// official API gets x-api-key and everything else starts with Bearer.
+func authStyle(cfg *models.Config) string {
+	if !autoAuth(cfg) {
+		return cfg.RelayAuth
 	}
-
-	// Find best sonnet
-	if best := findBest(ids, []string{"sonnet-4-5", "sonnet-4", "sonnet-3-5", "sonnet"}); best != "" {
-		mappings = append(mappings, models.ModelMapping{VSCodeID: "claude-sonnet-4.5", RelayID: best})
+	if u, err := neturl.Parse(cfg.BaseURL); err == nil && strings.EqualFold(u.Hostname(), "api.anthropic.com") {
+		return models.RelayAuthAPIKey
 	}
+	return models.RelayAuthBearer
+}
 
-	// Find best haiku
-	if best := findBest(ids, []string{"haiku-4-5", "haiku-3-5", "haiku"}); best != "" {
-		mappings = append(mappings, models.ModelMapping{VSCodeID: "claude-haiku-4.5", RelayID: best})
+func otherAuthStyle(style string) string {
+	if style == models.RelayAuthAPIKey {
+		return models.RelayAuthBearer
 	}
-
-	return mappings
+	return models.RelayAuthAPIKey
 }
 
-// SuggestDefaults returns recommended default models for the 3 tiers.
-func SuggestDefaults(available []models.RelayModel) (opus, sonnet, haiku string) {
-	ids := make([]string, len(available))
-	for i, m := range available {
-		ids[i] = m.ID
+// setAuthHeaders applies the relay auth style to req.
+func setAuthHeaders(req *http.Request, apiKey, style string) {
+	req.Header.Set("anthropic-version", anthropicVersion)
+	if apiKey == "" {
+		return
+	}
+	if style == models.RelayAuthAPIKey {
+		req.Header.Set("x-api-key", apiKey)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
 	}
-	opus = findBest(ids, []string{"opus-4-6", "opus-4-5", "opus"})
-	sonnet = findBest(ids, []string{"sonnet-4-5", "sonnet-4", "sonnet-3-5", "sonnet"})
-	haiku = findBest(ids, []string{"haiku-4-5", "haiku-3-5", "haiku"})
-	return
 }
 
-func findBest(ids []string, keywords []string) string {
-	for _, kw := range keywords {
-		var matches []string
-		for _, id := range ids {
-			if strings.Contains(id, kw) {
-				matches = append(matches, id)
-			}
-		}
-		if len(matches) > 0 {
-			// Prefer longer names (more specific), and prefer "thinking" variants
-			sort.Slice(matches, func(i, j int) bool {
-				iThink := strings.Contains(matches[i], "thinking")
-				jThink := strings.Contains(matches[j], "thinking")
-				if iThink != jThink {
-					return iThink
-				}
-				return len(matches[i]) > len(matches[j])
-			})
-			return matches[0]
-		}
+func fetchModelsPage(client *http.Client, url, afterID, apiKey, style string, cond catalogValidators) ([]byte, catalogValidators, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, catalogValidators{}, fmt.Errorf("create request: %w", err)
+	}
+	q := req.URL.Query()
+	if style == models.RelayAuthAPIKey {
+		q.Set("limit", "1000")
+	}
+	if afterID != "" {
+		q.Set("after_id", afterID)
+	}
+	req.URL.RawQuery = q.Encode()
+	setAuthHeaders(req, apiKey, style)
+	if cond.ETag != "" {
+		req.Header.Set("If-None-Match", cond.ETag)
+	}
+	if cond.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cond.LastModified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, catalogValidators{}, fmt.Errorf("relay unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 304 {
+		return nil, catalogValidators{}, errNotModified
+	}
+	if resp.StatusCode == 401 || resp.StatusCode == 403 {
+		return nil, catalogValidators{}, &authError{status: resp.StatusCode}
+	}
+	if resp.StatusCode != 200 {
+		return nil, catalogValidators{}, fmt.Errorf("relay returned HTTP %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, catalogValidators{}, fmt.Errorf("read response: %w", err)
 	}
-	return ""
+	return body, catalogValidators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}, nil
 }
@@ -0,0 +1,240 @@
+package relay
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"claude-relay/internal/models"
+)
+
+// maxRunnersUp is the number of losing candidates reported per suggestion.
+const maxRunnersUp = 3
+
+// mappingSlots are the VS Code model IDs SuggestMappings fills in.
+var mappingSlots = []string{"claude-opus-4.6", "claude-sonnet-4.5", "claude-haiku-4.5"}
+
+// SuggestResult holds suggested mappings and tier defaults together with
+// the explanation for every pick.
+type SuggestResult struct {
+	Mappings    []models.ModelMapping
+	Opus        string
+	Sonnet      string
+	Haiku       string
+	Suggestions []models.Suggestion
+}
+
+// Suggest scores the catalog for every mapping slot and default tier.
+//
+// A candidate must be a non-deprecated Claude model of exactly the wanted tier. Among those,
+// the ranking is: same version as the VS Code ID (mappings only), configured
+// prefix/suffix preferences, newest version, dated release over alias, newest
+// date, fewest variant markers (vendor prefix, -thinking, [1m]), then the
+// shortest ID.
+func Suggest(available []models.RelayModel, prefs *models.SuggestPrefs) *SuggestResult {
+	if prefs == nil {
+		prefs = &models.SuggestPrefs{}
+	}
+	res := &SuggestResult{}
+	for _, slot := range mappingSlots {
+		want := ParseModelID(slot)
+		if s, ok := suggestFor(slot, want.Tier, want.Version, available, prefs); ok {
+			res.Mappings = append(res.Mappings, models.ModelMapping{VSCodeID: slot, RelayID: s.RelayID})
+			res.Suggestions = append(res.Suggestions, s)
+		}
+	}
+	for _, tier := range []struct {
+		name string
+		dst  *string
+	}{{"opus", &res.Opus}, {"sonnet", &res.Sonnet}, {"haiku", &res.Haiku}} {
+		if s, ok := suggestFor(tier.name, tier.name, "", available, prefs); ok {
+			*tier.dst = s.RelayID
+			res.Suggestions = append(res.Suggestions, s)
+		}
+	}
+	return res
+}
+
+// SuggestMappings generates recommended model mappings from detected models.
+func SuggestMappings(available []models.RelayModel, prefs *models.SuggestPrefs) []models.ModelMapping {
+	return Suggest(available, prefs).Mappings
+}
+
+// SuggestDefaults returns recommended default models for the 3 tiers.
+func SuggestDefaults(available []models.RelayModel, prefs *models.SuggestPrefs) (opus, sonnet, haiku string) {
+	res := Suggest(available, prefs)
+	return res.Opus, res.Sonnet, res.Haiku
+}
+
+// SuggestModel picks the best replacement for id, a VS Code or relay model
+// ID, keeping its tier and preferring its version.
+func SuggestModel(id string, available []models.RelayModel, prefs *models.SuggestPrefs) (models.Suggestion, bool) {
+	if prefs == nil {
+		prefs = &models.SuggestPrefs{}
+	}
+	want := ParseModelID(id)
+	if want.Tier == "" {
+		return models.Suggestion{}, false
+	}
+	return suggestFor(id, want.Tier, want.Version, available, prefs)
+}
+
+// candidateScore is the ranking key of one model for one slot.
+type candidateScore struct {
+	id           string
+	versionMatch bool
+	pref         int
+	version      []int
+	date         string
+	variants     int
+	reasons      []string
+}
+
+func suggestFor(slot, tier, version string, available []models.RelayModel, prefs *models.SuggestPrefs) (models.Suggestion, bool) {
+	var cands []candidateScore
+	for _, m := range available {
+		if m.Deprecated || IsDeprecated(m.ID) {
+			continue
+		}
+		if c, ok := scoreCandidate(m.ID, tier, version, prefs); ok {
+			cands = append(cands, c)
+		}
+	}
+	if len(cands) == 0 {
+		return models.Suggestion{}, false
+	}
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].better(cands[j]) })
+
+	s := models.Suggestion{Slot: slot, RelayID: cands[0].id, Reasons: cands[0].reasons}
+	for _, c := range cands[1:] {
+		if len(s.RunnersUp) == maxRunnersUp {
+			break
+		}
+		s.RunnersUp = append(s.RunnersUp, models.Candidate{ID: c.id, Reasons: c.reasons})
+	}
+	return s, true
+}
+
+func scoreCandidate(id, tier, version string, prefs *models.SuggestPrefs) (candidateScore, bool) {
+	p := ParseModelID(id)
+	if p.Family != "claude" || p.Tier != tier {
+		return candidateScore{}, false
+	}
+	c := candidateScore{id: id, version: parseVersion(p.Version), date: p.Date}
+	c.reasons = append(c.reasons, "claude "+tier)
+
+	if version != "" {
+		c.versionMatch = p.Version == version
+		if c.versionMatch {
+			c.reasons = append(c.reasons, "version "+version+" matches")
+		} else {
+			c.reasons = append(c.reasons, fmt.Sprintf("version %s, wanted %s", orNone(p.Version), version))
+		}
+	} else if p.Version != "" {
+		c.reasons = append(c.reasons, "version "+p.Version)
+	}
+
+	lower := strings.ToLower(id)
+	for _, pre := range prefs.PreferPrefixes {
+		if pre != "" && strings.HasPrefix(lower, strings.ToLower(pre)) {
+			c.pref++
+			c.reasons = append(c.reasons, "preferred prefix "+pre)
+		}
+	}
+	for _, pre := range prefs.AvoidPrefixes {
+		if pre != "" && strings.HasPrefix(lower, strings.ToLower(pre)) {
+			c.pref--
+			c.reasons = append(c.reasons, "avoided prefix "+pre)
+		}
+	}
+	for _, suf := range prefs.PreferSuffixes {
+		if suf != "" && strings.Contains(lower, strings.ToLower(suf)) {
+			c.pref++
+			c.reasons = append(c.reasons, "preferred suffix "+suf)
+		}
+	}
+	for _, suf := range prefs.AvoidSuffixes {
+		if suf != "" && strings.Contains(lower, strings.ToLower(suf)) {
+			c.pref--
+			c.reasons = append(c.reasons, "avoided suffix "+suf)
+		}
+	}
+
+	if p.Date != "" {
+		c.reasons = append(c.reasons, "dated release "+p.Date)
+	} else {
+		c.reasons = append(c.reasons, "undated alias")
+	}
+	if strings.Contains(id, "/") {
+		c.variants++
+		c.reasons = append(c.reasons, "vendor prefix "+p.Provider+"/")
+	}
+	for _, capability := range p.Capabilities {
+		c.variants++
+		c.reasons = append(c.reasons, capability+" variant")
+	}
+	return c, true
+}
+
+// better reports whether c ranks above o.
+func (c candidateScore) better(o candidateScore) bool {
+	if c.versionMatch != o.versionMatch {
+		return c.versionMatch
+	}
+	if c.pref != o.pref {
+		return c.pref > o.pref
+	}
+	if cmp := compareVersions(c.version, o.version); cmp != 0 {
+		return cmp > 0
+	}
+	if (c.date != "") != (o.date != "") {
+		return c.date != ""
+	}
+	if c.date != o.date {
+		return c.date > o.date
+	}
+	if c.variants != o.variants {
+		return c.variants < o.variants
+	}
+	if len(c.id) != len(o.id) {
+		return len(c.id) < len(o.id)
+	}
+	return c.id < o.id
+}
+
+func parseVersion(v string) []int {
+	var parts []int
+	for _, s := range strings.Split(v, ".") {
+		if n, err := strconv.Atoi(s); err == nil {
+			parts = append(parts, n)
+		}
+	}
+	return parts
+}
+
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x > y {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,126 @@
+package relay
+
+import (
+	"reflect"
+	"testing"
+
+	"claude-relay/internal/models"
+)
+
+func catalogOf(ids ...string) []models.RelayModel {
+	list := make([]models.RelayModel, len(ids))
+	for i, id := range ids {
+		list[i] = models.RelayModel{ID: id}
+	}
+	return list
+}
+
+func TestSuggestModel(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    string
+		ids   []string
+		prefs *models.SuggestPrefs
+		want  string
+	}{
+		{"same version wins over newer", "claude-sonnet-4.5",
+			[]string{"claude-sonnet-4-6", "claude-sonnet-4-5-20250929"}, nil, "claude-sonnet-4-5-20250929"},
+		{"newest version without a match", "claude-sonnet-4.5",
+			[]string{"claude-sonnet-4-20250514", "claude-sonnet-4-6"}, nil, "claude-sonnet-4-6"},
+		{"only the wanted tier", "claude-haiku-4.5",
+			[]string{"claude-opus-4-6", "claude-sonnet-4-5"}, nil, ""},
+		{"deprecated models are skipped", "claude-sonnet-3.7",
+			[]string{"claude-3-7-sonnet-20250219", "claude-sonnet-4-5"}, nil, "claude-sonnet-4-5"},
+		{"dated release over alias", "claude-opus-4.6",
+			[]string{"claude-opus-4-6", "claude-opus-4-6-20260205"}, nil, "claude-opus-4-6-20260205"},
+		{"newest date", "claude-opus-4.6",
+			[]string{"claude-opus-4-6-20260205", "claude-opus-4-6-20260301"}, nil, "claude-opus-4-6-20260301"},
+		{"fewest variants", "claude-opus-4.6",
+			[]string{"anthropic/claude-opus-4-6", "claude-opus-4-6-thinking", "claude-opus-4-6"}, nil, "claude-opus-4-6"},
+		{"preferred suffix beats fewer variants", "claude-opus-4.6",
+			[]string{"claude-opus-4-6", "claude-opus-4-6-thinking"}, &models.SuggestPrefs{PreferSuffixes: []string{"thinking"}}, "claude-opus-4-6-thinking"},
+		{"avoided prefix", "claude-opus-4.6",
+			[]string{"aws/claude-opus-4-6-20260205", "claude-opus-4-6"}, &models.SuggestPrefs{AvoidPrefixes: []string{"AWS/"}}, "claude-opus-4-6"},
+		{"preferred prefix, case-insensitive", "claude-opus-4.6",
+			[]string{"claude-opus-4-6", "Vertex/claude-opus-4-6"}, &models.SuggestPrefs{PreferPrefixes: []string{"vertex/"}}, "Vertex/claude-opus-4-6"},
+		{"shortest then lexical id", "claude-opus-4.6",
+			[]string{"claude-opus-4-6-b", "claude-opus-4-6-a", "claude-opus-4.6"}, nil, "claude-opus-4.6"},
+		{"no tier in id", "gpt-4o", []string{"claude-opus-4-6"}, nil, ""},
+	}
+	for _, tt := range tests {
+		s, ok := SuggestModel(tt.id, catalogOf(tt.ids...), tt.prefs)
+		if ok != (tt.want != "") || s.RelayID != tt.want {
+			t.Errorf("%s: got %q, %v; want %q", tt.name, s.RelayID, ok, tt.want)
+		}
+	}
+}
+
+func TestSuggestRunnersUp(t *testing.T) {
+	s, ok := SuggestModel("claude-opus-4.6", catalogOf(
+		"claude-opus-4-6-20260205", "claude-opus-4-6", "claude-opus-4-5", "claude-opus-4-1", "claude-opus-4",
+	), nil)
+	if !ok {
+		t.Fatal("no suggestion")
+	}
+	var ids []string
+	for _, c := range s.RunnersUp {
+		ids = append(ids, c.ID)
+	}
+	want := []string{"claude-opus-4-6", "claude-opus-4-5", "claude-opus-4-1"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("runners up = %v, want %v", ids, want)
+	}
+	wantReasons := []string{"claude opus", "version 4.6 matches", "dated release 20260205"}
+	if !reflect.DeepEqual(s.Reasons, wantReasons) {
+		t.Errorf("reasons = %v, want %v", s.Reasons, wantReasons)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	available := catalogOf(
+		"claude-opus-4-6-20260205",
+		"claude-sonnet-4-5-20250929",
+		"claude-sonnet-4-6",
+		"claude-haiku-4-5-20251001",
+		"claude-3-5-haiku-20241022",
+		"gpt-4o",
+	)
+	res := Suggest(available, nil)
+	wantMappings := []models.ModelMapping{
+		{VSCodeID: "claude-opus-4.6", RelayID: "claude-opus-4-6-20260205"},
+		{VSCodeID: "claude-sonnet-4.5", RelayID: "claude-sonnet-4-5-20250929"},
+		{VSCodeID: "claude-haiku-4.5", RelayID: "claude-haiku-4-5-20251001"},
+	}
+	if !reflect.DeepEqual(res.Mappings, wantMappings) {
+		t.Errorf("mappings = %+v", res.Mappings)
+	}
+	// Defaults take the newest version of each tier.
+	if res.Opus != "claude-opus-4-6-20260205" || res.Sonnet != "claude-sonnet-4-6" || res.Haiku != "claude-haiku-4-5-20251001" {
+		t.Errorf("defaults = %q, %q, %q", res.Opus, res.Sonnet, res.Haiku)
+	}
+	if len(res.Suggestions) != 6 {
+		t.Errorf("got %d suggestions, want 6", len(res.Suggestions))
+	}
+
+	if res := Suggest(catalogOf("gpt-4o"), nil); len(res.Mappings) != 0 || res.Opus != "" {
+		t.Errorf("no claude models: got %+v", res)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"4.6", "4.5", 1},
+		{"4", "4.0", 0},
+		{"4.10", "4.9", 1},
+		{"3.7", "4", -1},
+		{"", "1", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(parseVersion(tt.a), parseVersion(tt.b)); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
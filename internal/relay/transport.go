@@ -0,0 +1,98 @@
+package relay
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"time"
+
+	"claude-relay/internal/config"
+	"claude-relay/internal/models"
+)
+
+// defaultRelayTimeout bounds a relay request when HTTPSettings.Timeout is unset.
+const defaultRelayTimeout = 15 * time.Second
+
+// tlsVersions are the accepted HTTPSettings.TLSMinVersion values.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// HTTPClient builds the client used for every relay call from cfg.HTTP.
+func HTTPClient(cfg *models.Config) (*http.Client, error) {
+	s := cfg.HTTP
+	if s == nil {
+		s = &models.HTTPSettings{}
+	}
+	if err := config.ValidateHTTPSettings(s); err != nil {
+		return nil, err
+	}
+
+	timeout := defaultRelayTimeout
+	if s.Timeout != "" {
+		timeout, _ = time.ParseDuration(s.Timeout)
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if s.ConnectTimeout != "" {
+		dialer.Timeout, _ = time.ParseDuration(s.ConnectTimeout)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	switch s.Proxy {
+	case "":
+		transport.Proxy = http.ProxyFromEnvironment
+	case models.ProxyDirect:
+		transport.Proxy = nil
+	default:
+		u, _ := neturl.Parse(s.Proxy)
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if v, ok := tlsVersions[s.TLSMinVersion]; ok {
+		tlsConfig.MinVersion = v
+	}
+	if s.CABundle != "" {
+		pem, err := os.ReadFile(expandLocalPath(s.CABundle))
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s contains no PEM certificates", s.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if s.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(expandLocalPath(s.ClientCert), expandLocalPath(s.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// expandLocalPath expands ~/ and ${HOME} in a local file path.
+func expandLocalPath(p string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		return home + "/" + rest
+	}
+	return strings.ReplaceAll(p, "${HOME}", home)
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+
+	"claude-relay/internal/models"
+	"claude-relay/internal/users"
+)
+
+// Options configures access control for the web UI and API.
+type Options struct {
+	// Token must accompany every API request, either as a Bearer header or
+	// as the session cookie set when the UI is opened with ?token=. It is
+	// ignored when Users is set.
+	Token string
+	// Users switches to multi-user mode: every request needs HTTP Basic
+	// credentials of an account in the store, and routes are limited by role.
+	Users *users.Store
+	// AllowedHosts are additional Host header values to accept besides
+	// loopback names and the addresses the server listens on.
+	AllowedHosts []string
+}
+
+// NewToken returns a random per-launch session token.
+func NewToken() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// IsLoopback reports whether addr (host:port) only listens on loopback.
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// guard wraps the mux with Host, Origin and token checks. Host is checked on
+// every request to defeat DNS rebinding; Origin and the token only on /api/,
+// since the embedded frontend holds no secrets.
+type guard struct {
+	next   http.Handler
+	token  string
+	users  *users.Store
+	cookie string
+	hosts  map[string]bool
+}
+
+func newGuard(addr string, opts Options, next http.Handler) *guard {
+	_, port, _ := net.SplitHostPort(addr)
+	return &guard{
+		next:   next,
+		token:  opts.Token,
+		users:  opts.Users,
+		cookie: "claude_relay_session_" + port,
+		hosts:  allowedHosts(addr, opts.AllowedHosts),
+	}
+}
+
+// allowedHosts collects the host names a browser may legitimately use to
+// reach addr: loopback names, the listen address and, for wildcard binds,
+// this machine's hostname and interface addresses.
+func allowedHosts(addr string, extra []string) map[string]bool {
+	hosts := map[string]bool{"localhost": true, "127.0.0.1": true, "::1": true}
+	for _, h := range extra {
+		hosts[strings.ToLower(h)] = true
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		hosts[strings.ToLower(host)] = true
+		return hosts
+	}
+	if name, err := os.Hostname(); err == nil {
+		hosts[strings.ToLower(name)] = true
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				hosts[ipnet.IP.String()] = true
+			}
+		}
+	}
+	return hosts
+}
+
+func (g *guard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !g.hosts[requestHost(r.Host)] {
+		writeError(w, 421, models.CodeInvalidHost, "unexpected Host header")
+		return
+	}
+
+	if g.users != nil {
+		name, password, _ := r.BasicAuth()
+		u, ok := g.users.Authenticate(name, password)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="claude-relay", charset="UTF-8"`)
+			writeError(w, 401, models.CodeUnauthorized, "login required")
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") && !sameOrigin(r) {
+			writeError(w, 403, models.CodeCrossOrigin, "cross-origin request refused")
+			return
+		}
+		ctx := context.WithValue(r.Context(), userKey{}, u)
+		g.next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, storeKey{}, g.users)))
+		return
+	}
+
+	// Opening the UI with ?token= trades the token for a session cookie and
+	// drops it from the address bar.
+	if t := r.URL.Query().Get("token"); t != "" && !strings.HasPrefix(r.URL.Path, "/api/") {
+		if !g.validToken(t) {
+			writeError(w, 401, models.CodeUnauthorized, "invalid token")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     g.cookie,
+			Value:    g.token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+		q := r.URL.Query()
+		q.Del("token")
+		u := *r.URL
+		u.RawQuery = q.Encode()
+		http.Redirect(w, r, u.RequestURI(), http.StatusSeeOther)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		if !sameOrigin(r) {
+			writeError(w, 403, models.CodeCrossOrigin, "cross-origin request refused")
+			return
+		}
+		if !g.authenticated(r) {
+			writeError(w, 401, models.CodeUnauthorized, "missing or invalid session token; open the URL printed at startup")
+			return
+		}
+	}
+	g.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, tokenUser)))
+}
+
+type (
+	userKey  struct{}
+	storeKey struct{}
+)
+
+// tokenUser is the account behind the single-user session token.
+var tokenUser = &models.User{Name: "local", Role: models.RoleAdmin}
+
+// currentUser returns the account that made the request.
+func currentUser(r *http.Request) *models.User {
+	if u, ok := r.Context().Value(userKey{}).(*models.User); ok {
+		return u
+	}
+	return tokenUser
+}
+
+// userStore returns the accounts of a multi-user server, or nil in
+// single-user mode.
+func userStore(r *http.Request) *users.Store {
+	s, _ := r.Context().Value(storeKey{}).(*users.Store)
+	return s
+}
+
+// requireRole limits h to accounts with at least the given role.
+func requireRole(need models.Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !users.Allows(currentUser(r).Role, need) {
+			writeError(w, 403, models.CodeForbidden, "this action requires the "+string(need)+" role")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// findUserTarget is findTarget limited to the targets the requesting
+// account may access; others look exactly like missing targets.
+func findUserTarget(r *http.Request, cfg *models.Config, name string) *models.Target {
+	if !users.CanAccess(currentUser(r), name) {
+		return nil
+	}
+	return findTarget(cfg, name)
+}
+
+func (g *guard) validToken(t string) bool {
+	return g.token != "" && subtle.ConstantTimeCompare([]byte(t), []byte(g.token)) == 1
+}
+
+func (g *guard) authenticated(r *http.Request) bool {
+	if t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return g.validToken(t)
+	}
+	c, err := r.Cookie(g.cookie)
+	return err == nil && g.validToken(c.Value)
+}
+
+// sameOrigin rejects browser requests issued by another site. Requests
+// without Origin (curl, scripts) pass and rely on the token alone.
+func sameOrigin(r *http.Request) bool {
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := neturl.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// requestHost returns the lower-cased host of a Host header without port
+// or IPv6 brackets.
+func requestHost(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.Trim(hostport, "[]")
+	}
+	return strings.ToLower(host)
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"claude-relay/internal/models"
+)
+
+const testToken = "secret-token"
+
+// echoUser answers with the name of the account the guard let through.
+var echoUser = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(currentUser(r).Name))
+})
+
+// serve sends a request built by edit through h and returns the recorder.
+func serve(h http.Handler, method, target string, edit func(*http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Host = "127.0.0.1:8787"
+	if edit != nil {
+		edit(req)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// errorCode returns the code of an error response, if any.
+func errorCode(rec *httptest.ResponseRecorder) models.ErrorCode {
+	var resp models.APIResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return resp.Code
+}
+
+func bearer(token string) func(*http.Request) {
+	return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+}
+
+func TestGuardToken(t *testing.T) {
+	g := newGuard("127.0.0.1:8787", Options{Token: testToken, AllowedHosts: []string{"Relay.Example.com"}}, echoUser)
+	cookie := &http.Cookie{Name: "claude_relay_session_8787", Value: testToken}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		edit   func(*http.Request)
+		status int
+		code   models.ErrorCode
+	}{
+		{"bearer", "GET", "/api/config", bearer(testToken), 200, ""},
+		{"wrong bearer", "GET", "/api/config", bearer("guess"), 401, models.CodeUnauthorized},
+		{"no token", "GET", "/api/config", nil, 401, models.CodeUnauthorized},
+		{"session cookie", "GET", "/api/config", func(r *http.Request) { r.AddCookie(cookie) }, 200, ""},
+		{"cookie of another port", "GET", "/api/config", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "claude_relay_session_9999", Value: testToken})
+		}, 401, models.CodeUnauthorized},
+		// A bad Bearer header is not rescued by a good cookie.
+		{"wrong bearer with cookie", "GET", "/api/config", func(r *http.Request) {
+			bearer("guess")(r)
+			r.AddCookie(cookie)
+		}, 401, models.CodeUnauthorized},
+		// The token is only traded for a cookie on UI pages.
+		{"query token on api", "GET", "/api/config?token=" + testToken, nil, 401, models.CodeUnauthorized},
+		// The embedded frontend needs no token.
+		{"frontend", "GET", "/", nil, 200, ""},
+
+		{"loopback name", "GET", "/api/config", func(r *http.Request) { r.Host = "localhost:8787"; bearer(testToken)(r) }, 200, ""},
+		{"ipv6 loopback", "GET", "/api/config", func(r *http.Request) { r.Host = "[::1]:8787"; bearer(testToken)(r) }, 200, ""},
+		{"allowed host", "GET", "/api/config", func(r *http.Request) { r.Host = "relay.example.COM:8787"; bearer(testToken)(r) }, 200, ""},
+		// DNS rebinding: checked before anything else, frontend included.
+		{"foreign host", "GET", "/api/config", func(r *http.Request) { r.Host = "evil.example:8787"; bearer(testToken)(r) }, 421, models.CodeInvalidHost},
+		{"foreign host frontend", "GET", "/", func(r *http.Request) { r.Host = "evil.example" }, 421, models.CodeInvalidHost},
+
+		{"same origin", "POST", "/api/deploy", func(r *http.Request) {
+			r.Header.Set("Origin", "http://127.0.0.1:8787")
+			bearer(testToken)(r)
+		}, 200, ""},
+		{"cross origin", "POST", "/api/deploy", func(r *http.Request) {
+			r.Header.Set("Origin", "http://evil.example")
+			bearer(testToken)(r)
+		}, 403, models.CodeCrossOrigin},
+		{"null origin", "POST", "/api/deploy", func(r *http.Request) {
+			r.Header.Set("Origin", "null")
+			r.AddCookie(cookie)
+		}, 403, models.CodeCrossOrigin},
+		{"other port", "POST", "/api/deploy", func(r *http.Request) {
+			r.Header.Set("Origin", "http://127.0.0.1:9999")
+			r.AddCookie(cookie)
+		}, 403, models.CodeCrossOrigin},
+		{"cross-site fetch", "POST", "/api/deploy", func(r *http.Request) {
+			r.Header.Set("Sec-Fetch-Site", "cross-site")
+			r.AddCookie(cookie)
+		}, 403, models.CodeCrossOrigin},
+		{"same-site fetch", "POST", "/api/deploy", func(r *http.Request) {
+			r.Header.Set("Sec-Fetch-Site", "same-origin")
+			r.AddCookie(cookie)
+		}, 200, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(g, tt.method, tt.path, tt.edit)
+			if rec.Code != tt.status || errorCode(rec) != tt.code {
+				t.Errorf("got %d %q, want %d %q: %s", rec.Code, errorCode(rec), tt.status, tt.code, rec.Body)
+			}
+			if rec.Code == 200 && rec.Body.String() != tokenUser.Name {
+				t.Errorf("handler saw user %q", rec.Body)
+			}
+		})
+	}
+}
+
+func TestGuardTokenExchange(t *testing.T) {
+	g := newGuard("127.0.0.1:8787", Options{Token: testToken}, echoUser)
+
+	rec := serve(g, "GET", "/?token="+testToken+"&tab=targets", nil)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("got %d, want 303", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/?tab=targets" {
+		t.Errorf("redirected to %q", loc)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("cookies: %v", cookies)
+	}
+	c := cookies[0]
+	if c.Name != "claude_relay_session_8787" || c.Value != testToken || !c.HttpOnly || c.SameSite != http.SameSiteStrictMode || c.Secure {
+		t.Errorf("cookie = %+v", c)
+	}
+	// The cookie is what the API then accepts.
+	if rec := serve(g, "GET", "/api/config", func(r *http.Request) { r.AddCookie(c) }); rec.Code != 200 {
+		t.Errorf("with the exchanged cookie: %d", rec.Code)
+	}
+
+	rec = serve(g, "GET", "/?token=guess", nil)
+	if rec.Code != 401 || len(rec.Result().Cookies()) != 0 {
+		t.Errorf("wrong token: got %d, cookies %v", rec.Code, rec.Result().Cookies())
+	}
+}
+
+// TestGuardWithoutToken checks that an empty token, which main refuses to
+// start with on non-loopback addresses, matches nothing.
+func TestGuardWithoutToken(t *testing.T) {
+	g := newGuard("127.0.0.1:8787", Options{}, echoUser)
+	for name, edit := range map[string]func(*http.Request){
+		"no header":    nil,
+		"empty bearer": bearer(""),
+		"empty cookie": func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "claude_relay_session_8787", Value: ""}) },
+	} {
+		if rec := serve(g, "GET", "/api/config", edit); rec.Code != 401 {
+			t.Errorf("%s: got %d", name, rec.Code)
+		}
+	}
+	if rec := serve(g, "GET", "/?token=x", nil); rec.Code != 401 {
+		t.Errorf("token exchange: got %d", rec.Code)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:8787": true,
+		"127.0.0.2:8787": true,
+		"localhost:8787": true,
+		"[::1]:8787":     true,
+		":8787":          false,
+		"0.0.0.0:8787":   false,
+		"[::]:8787":      false,
+		"10.0.0.5:8787":  false,
+		"relay.lan:8787": false,
+		"127.0.0.1":      false,
+	}
+	for addr, want := range tests {
+		if got := IsLoopback(addr); got != want {
+			t.Errorf("IsLoopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestAllowedHostsWildcard(t *testing.T) {
+	hosts := allowedHosts(":8787", nil)
+	for _, h := range []string{"localhost", "127.0.0.1", "::1"} {
+		if !hosts[h] {
+			t.Errorf("%s not allowed", h)
+		}
+	}
+	if hosts["evil.example"] {
+		t.Error("foreign host allowed")
+	}
+	if hosts := allowedHosts("10.0.0.5:8787", nil); !hosts["10.0.0.5"] || len(hosts) != 4 {
+		t.Errorf("specific bind: %v", hosts)
+	}
+	for in, want := range map[string]string{"[::1]:8787": "::1", "[::1]": "::1", "Relay.LAN": "relay.lan", "relay.lan:80": "relay.lan"} {
+		if got := requestHost(in); got != want {
+			t.Errorf("requestHost(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -1,29 +1,38 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"claude-relay/internal/autodetect"
+	"claude-relay/internal/codespaces"
 	"claude-relay/internal/config"
 	"claude-relay/internal/deployer"
+	"claude-relay/internal/docker"
+	"claude-relay/internal/jobs"
+	"claude-relay/internal/locks"
 	"claude-relay/internal/models"
 	"claude-relay/internal/relay"
+	"claude-relay/internal/sshconfig"
+	"claude-relay/internal/users"
 )
 
-type apiResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message,omitempty"`
-}
-
 func writeJSON(w http.ResponseWriter, code int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(v)
 }
 
-func writeError(w http.ResponseWriter, code int, msg string) {
-	writeJSON(w, code, apiResponse{Status: "error", Message: msg})
+func writeError(w http.ResponseWriter, status int, code models.ErrorCode, msg string) {
+	writeJSON(w, status, models.APIResponse{Status: "error", Code: code, Message: msg})
 }
 
 const maskedKeyPlaceholder = "__MASKED__"
@@ -42,28 +51,34 @@ func isMaskedKey(key string) bool {
 	return strings.Count(key[starIdx:], "*") == len(key)-starIdx
 }
 
+// maskKey hides an API key — show prefix for identification but use a fixed
+// sentinel so the frontend can never accidentally save the truncated value as
+// the real key.
+func maskKey(key string) string {
+	if len(key) > 8 {
+		return key[:8] + strings.Repeat("*", len(key)-8)
+	} else if len(key) > 0 {
+		return maskedKeyPlaceholder
+	}
+	return key
+}
+
 // --- Config ---
 
 func handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	cfg, err := config.Load()
 	if err != nil {
-		writeError(w, 500, err.Error())
+		writeError(w, 500, models.CodeInternal, err.Error())
 		return
 	}
-	// Mask API key — show prefix for identification but use a fixed sentinel
-	// so the frontend can never accidentally save the truncated value as the real key.
-	if len(cfg.APIKey) > 8 {
-		cfg.APIKey = cfg.APIKey[:8] + strings.Repeat("*", len(cfg.APIKey)-8)
-	} else if len(cfg.APIKey) > 0 {
-		cfg.APIKey = maskedKeyPlaceholder
-	}
+	cfg.APIKey = maskKey(cfg.APIKey)
 	writeJSON(w, 200, cfg)
 }
 
 func handlePutConfig(w http.ResponseWriter, r *http.Request) {
 	var cfg models.Config
 	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
-		writeError(w, 400, "invalid JSON: "+err.Error())
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON: "+err.Error())
 		return
 	}
 
@@ -76,11 +91,96 @@ func handlePutConfig(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if err := config.Validate(&cfg); err != nil {
+		writeError(w, 400, models.CodeValidationFailed, err.Error())
+		return
+	}
+
 	if err := config.Save(&cfg); err != nil {
-		writeError(w, 500, err.Error())
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, 200, models.APIResponse{Status: "ok"})
+}
+
+// --- Permissions & hooks ---
+
+func handleGetPermissions(w http.ResponseWriter, r *http.Request) {
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	perms := cfg.Permissions
+	if perms == nil {
+		perms = &models.Permissions{}
+	}
+	writeJSON(w, 200, perms)
+}
+
+func handlePutPermissions(w http.ResponseWriter, r *http.Request) {
+	var perms models.Permissions
+	if err := json.NewDecoder(r.Body).Decode(&perms); err != nil {
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if err := config.ValidatePermissions(&perms); err != nil {
+		writeError(w, 400, models.CodeValidationFailed, err.Error())
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	// An empty block means "not managed": leave targets' own permissions alone.
+	cfg.Permissions = &perms
+	if len(perms.Allow)+len(perms.Deny)+len(perms.Ask)+len(perms.AdditionalDirectories) == 0 && perms.DefaultMode == "" {
+		cfg.Permissions = nil
+	}
+	if err := config.Save(cfg); err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
 		return
 	}
-	writeJSON(w, 200, apiResponse{Status: "ok"})
+	writeJSON(w, 200, models.APIResponse{Status: "ok"})
+}
+
+func handleGetHooks(w http.ResponseWriter, r *http.Request) {
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	hooks := cfg.Hooks
+	if hooks == nil {
+		hooks = map[string][]models.HookMatcher{}
+	}
+	writeJSON(w, 200, hooks)
+}
+
+func handlePutHooks(w http.ResponseWriter, r *http.Request) {
+	var hooks map[string][]models.HookMatcher
+	if err := json.NewDecoder(r.Body).Decode(&hooks); err != nil {
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if err := config.ValidateHooks(hooks); err != nil {
+		writeError(w, 400, models.CodeValidationFailed, err.Error())
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	cfg.Hooks = hooks
+	if err := config.Save(cfg); err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, 200, models.APIResponse{Status: "ok"})
 }
 
 // --- Models ---
@@ -88,114 +188,498 @@ func handlePutConfig(w http.ResponseWriter, r *http.Request) {
 func handleDetectModels(w http.ResponseWriter, r *http.Request) {
 	cfg, err := config.Load()
 	if err != nil {
-		writeError(w, 500, err.Error())
+		writeError(w, 500, models.CodeInternal, err.Error())
 		return
 	}
 	if cfg.BaseURL == "" || cfg.APIKey == "" {
-		writeError(w, 400, "base_url and api_key must be configured first")
+		writeError(w, 400, models.CodeNotConfigured, "base_url and api_key must be configured first")
 		return
 	}
-	result, err := relay.FetchModels(cfg.BaseURL, cfg.APIKey)
+	catalog, err := relay.Catalog(cfg, r.URL.Query().Get("refresh") == "true")
 	if err != nil {
-		writeError(w, 502, err.Error())
+		writeError(w, 502, models.CodeRelayError, err.Error())
 		return
 	}
-	mappings := relay.SuggestMappings(result)
-	opus, sonnet, haiku := relay.SuggestDefaults(result)
-	writeJSON(w, 200, map[string]any{
-		"models":           result,
-		"suggest_mappings": mappings,
-		"suggest_opus":     opus,
-		"suggest_sonnet":   sonnet,
-		"suggest_haiku":    haiku,
+	result := catalog.Models
+	suggested := relay.Suggest(result, cfg.SuggestPrefs)
+	writeJSON(w, 200, models.DetectModelsResponse{
+		Models:          result,
+		Source:          catalog.Source,
+		FetchedAt:       catalog.FetchedAt,
+		Error:           catalog.Error,
+		Changes:         catalog.Changes,
+		SuggestMappings: suggested.Mappings,
+		SuggestOpus:     suggested.Opus,
+		SuggestSonnet:   suggested.Sonnet,
+		SuggestHaiku:    suggested.Haiku,
+		Suggestions:     suggested.Suggestions,
 	})
 }
 
+func handleModelHistory(w http.ResponseWriter, r *http.Request) {
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	history, err := relay.CatalogHistory(cfg)
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, 200, history)
+}
+
+// --- Relay account ---
+
+func handleRelayAccount(w http.ResponseWriter, r *http.Request) {
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	if cfg.BaseURL == "" || cfg.APIKey == "" {
+		writeError(w, 400, models.CodeNotConfigured, "base_url and api_key must be configured first")
+		return
+	}
+	acct, err := relay.Account(cfg)
+	if errors.Is(err, relay.ErrAccountUnsupported) {
+		writeError(w, 404, models.CodeAccountUnsupported, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, 502, models.CodeRelayError, err.Error())
+		return
+	}
+	writeJSON(w, 200, models.AccountResponse{Account: acct, Problem: relay.AccountProblem(acct)})
+}
+
+// --- Auto-detect ---
+
+func handleGetAutoDetect(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, models.AutoDetectResponse{Report: autodetect.Last()})
+}
+
+func handleRunAutoDetect(w http.ResponseWriter, r *http.Request) {
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	if cfg.BaseURL == "" || cfg.APIKey == "" {
+		writeError(w, 400, models.CodeNotConfigured, "base_url and api_key must be configured first")
+		return
+	}
+	report := autodetect.Run(cfg, autodetect.TriggerManual)
+	if report.Applied {
+		if err := config.Save(cfg); err != nil {
+			writeError(w, 500, models.CodeInternal, err.Error())
+			return
+		}
+	}
+	writeJSON(w, 200, models.AutoDetectResponse{Report: report})
+}
+
+// autoDetectBeforeDeploy runs the catalog check when AutoDetect is on, saving
+// applied suggestions. Unless force is set, it writes a 409 with the report
+// and returns false when the deploy must not go ahead.
+func autoDetectBeforeDeploy(w http.ResponseWriter, cfg *models.Config, force bool) bool {
+	if !cfg.AutoDetect || cfg.BaseURL == "" || cfg.APIKey == "" {
+		return true
+	}
+	report := autodetect.Run(cfg, autodetect.TriggerDeploy)
+	if report.Applied {
+		if err := config.Save(cfg); err != nil {
+			writeError(w, 500, models.CodeInternal, err.Error())
+			return false
+		}
+	}
+	if report.Blocked && !force {
+		writeJSON(w, 409, models.APIResponse{
+			Status:  "error",
+			Code:    models.CodeCatalogCheckFailed,
+			Message: autodetect.Summary(report),
+			Report:  report,
+		})
+		return false
+	}
+	return true
+}
+
+// preflightBeforeDeploy runs the deploy preflight. Unless force is set, it
+// writes a 422 with the report and returns false when it found errors.
+func preflightBeforeDeploy(w http.ResponseWriter, target models.Target, cfg *models.Config, force bool) (*models.PreflightReport, bool) {
+	report := deployer.Preflight(target, cfg)
+	if !report.OK && !force {
+		var errs []string
+		for _, is := range report.Issues {
+			if is.Level == models.PreflightError {
+				errs = append(errs, is.Field+": "+is.Message)
+			}
+		}
+		writeJSON(w, 422, models.APIResponse{
+			Status:    "error",
+			Code:      models.CodePreflightFailed,
+			Message:   "preflight failed: " + strings.Join(errs, "; "),
+			Preflight: report,
+		})
+		return report, false
+	}
+	return report, true
+}
+
 // --- Deploy ---
 
 func handleDeploy(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		TargetName string `json:"target_name"`
+	var req models.DeployRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON")
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+
+	target := findUserTarget(r, cfg, req.TargetName)
+	if target == nil {
+		writeError(w, 404, models.CodeTargetNotFound, "target not found: "+req.TargetName)
+		return
+	}
+	t := *target
+
+	if !req.Start && codespaceStopped(w, r, t) {
+		return
+	}
+	if !autoDetectBeforeDeploy(w, cfg, req.Force) {
+		return
+	}
+	preflight, ok := preflightBeforeDeploy(w, t, cfg, req.Force)
+	if !ok {
+		return
+	}
+	lock, ok := lockTarget(w, r, t.Name, models.JobDeploy)
+	if !ok {
+		return
+	}
+	writeJSON(w, 202, startDeployJob(r, t, cfg, preflight, lock, req.Start))
+}
+
+// startDeployJob deploys to t in a background job that releases lock when
+// it ends. With start set, a codespace target is started first.
+func startDeployJob(r *http.Request, t models.Target, cfg *models.Config, preflight *models.PreflightReport, lock *locks.Lock, start bool) models.Job {
+	return jobs.Start(models.Job{
+		Kind:      models.JobDeploy,
+		Target:    t.Name,
+		User:      currentUser(r).Name,
+		Preflight: preflight,
+	}, func(ctx context.Context, logf func(string, ...any)) (string, error) {
+		defer lock.Release()
+		if start && t.Type == models.TargetCodespace {
+			if err := codespaces.Start(ctx, t.Host, logf); err != nil {
+				return "", err
+			}
+		}
+		ctx = deployer.WithLog(ctx, logf)
+		ctx, err := deployer.Pin(ctx, t)
+		if err != nil {
+			return "", err
+		}
+		unlock, err := deployer.LockRemote(ctx, t, lock.Holder)
+		if err != nil {
+			return "", err
+		}
+		defer unlock()
+		if err := deployer.Deploy(ctx, t, cfg); err != nil {
+			return "", err
+		}
+		return "deployed to " + t.Name, nil
+	})
+}
+
+// codespaceStopped writes a 409 and returns true when t is a codespace that
+// is not running, so that the caller can offer to start it. When the state
+// cannot be read the deploy goes ahead and reports its own error.
+func codespaceStopped(w http.ResponseWriter, r *http.Request, t models.Target) bool {
+	if t.Type != models.TargetCodespace {
+		return false
+	}
+	state, err := codespaces.State(r.Context(), t.Host)
+	if err != nil || state == models.CodespaceAvailable {
+		return false
+	}
+	writeError(w, 409, models.CodeCodespaceStopped,
+		fmt.Sprintf("codespace %s is %s; deploy with start to start it first", t.Host, state))
+	return true
+}
+
+// handleDeployCodespaces deploys to every codespace of a repository. Admins
+// also get targets added for codespaces that have none; other accounts
+// deploy to the existing targets they may access.
+func handleDeployCodespaces(w http.ResponseWriter, r *http.Request) {
+	var req models.CodespaceDeployRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON")
+		return
+	}
+	if req.Repo == "" {
+		writeError(w, 400, models.CodeInvalidRequest, "repo is required")
+		return
+	}
+	list, err := codespaces.List(r.Context())
+	if err != nil {
+		writeError(w, 500, models.CodeGitHubError, err.Error())
+		return
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+
+	result := models.CodespaceDeployResult{Created: []string{}, Jobs: []models.Job{}, Skipped: []models.SkippedHost{}}
+	skip := func(host, reason string) {
+		result.Skipped = append(result.Skipped, models.SkippedHost{Host: host, Reason: reason})
+	}
+	admin := users.Allows(currentUser(r).Role, models.RoleAdmin)
+	var targets []models.Target
+	// Targets for codespaces without one are only saved once their deploy
+	// starts, so a failed check or preflight leaves the config untouched.
+	created := make(map[string]bool)
+	for _, cs := range list {
+		if !strings.EqualFold(cs.Repository, req.Repo) {
+			continue
+		}
+		t := codespaceTarget(cfg, cs.Name)
+		switch {
+		case t == nil && !admin:
+			skip(cs.Name, "no target for this codespace")
+			continue
+		case t == nil:
+			if findTarget(cfg, cs.Name) != nil {
+				skip(cs.Name, "target name taken")
+				continue
+			}
+			t = &models.Target{Name: cs.Name, Type: models.TargetCodespace, Host: cs.Name}
+			created[cs.Name] = true
+		case !users.CanAccess(currentUser(r), t.Name):
+			skip(cs.Name, "no access to target "+t.Name)
+			continue
+		}
+		if cs.State != models.CodespaceAvailable && !req.Start {
+			skip(cs.Name, "codespace is "+cs.State)
+			continue
+		}
+		targets = append(targets, *t)
 	}
+	if len(targets) == 0 && len(result.Skipped) == 0 {
+		writeError(w, 404, models.CodeTargetNotFound, "no codespaces of "+req.Repo)
+		return
+	}
+
+	if len(targets) > 0 && !autoDetectBeforeDeploy(w, cfg, req.Force) {
+		return
+	}
+	reports := make([]*models.PreflightReport, len(targets))
+	for i, t := range targets {
+		var ok bool
+		if reports[i], ok = preflightBeforeDeploy(w, t, cfg, req.Force); !ok {
+			return
+		}
+	}
+	held := make([]*locks.Lock, len(targets))
+	for i, t := range targets {
+		lock, err := locks.Acquire(t.Name, locks.NewHolder(models.JobDeploy, currentUser(r).Name))
+		if err != nil {
+			skip(t.Host, err.Error())
+			continue
+		}
+		held[i] = lock
+		if created[t.Name] {
+			cfg.Targets = append(cfg.Targets, t)
+			result.Created = append(result.Created, t.Name)
+		}
+	}
+	if len(result.Created) > 0 {
+		if err := config.Save(cfg); err != nil {
+			for _, lock := range held {
+				if lock != nil {
+					lock.Release()
+				}
+			}
+			writeError(w, 500, models.CodeInternal, err.Error())
+			return
+		}
+	}
+	for i, t := range targets {
+		if held[i] != nil {
+			result.Jobs = append(result.Jobs, startDeployJob(r, t, cfg, reports[i], held[i], req.Start))
+		}
+	}
+	writeJSON(w, 202, result)
+}
+
+func handleDeployPreflight(w http.ResponseWriter, r *http.Request) {
+	var req models.TargetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON")
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+
+	target := findUserTarget(r, cfg, req.TargetName)
+	if target == nil {
+		writeError(w, 404, models.CodeTargetNotFound, "target not found")
+		return
+	}
+	writeJSON(w, 200, deployer.Preflight(*target, cfg))
+}
+
+func handleDeployPreview(w http.ResponseWriter, r *http.Request) {
+	var req models.TargetRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, 400, "invalid JSON")
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON")
 		return
 	}
 
 	cfg, err := config.Load()
 	if err != nil {
-		writeError(w, 500, err.Error())
+		writeError(w, 500, models.CodeInternal, err.Error())
 		return
 	}
 
-	target := findTarget(cfg, req.TargetName)
+	target := findUserTarget(r, cfg, req.TargetName)
 	if target == nil {
-		writeError(w, 404, "target not found: "+req.TargetName)
+		writeError(w, 404, models.CodeTargetNotFound, "target not found")
+		return
+	}
+	if err := config.Validate(cfg); err != nil {
+		writeError(w, 400, models.CodeValidationFailed, err.Error())
 		return
 	}
 
-	if err := deployer.Deploy(*target, cfg); err != nil {
-		writeError(w, 500, err.Error())
+	preview, err := deployer.Preview(r.Context(), *target, cfg)
+	if err != nil {
+		writeError(w, 500, models.CodeTargetError, err.Error())
 		return
 	}
-	writeJSON(w, 200, apiResponse{Status: "ok", Message: "deployed to " + req.TargetName})
+	preview.Env["ANTHROPIC_API_KEY"] = maskKey(preview.Env["ANTHROPIC_API_KEY"])
+	writeJSON(w, 200, preview)
 }
 
 func handleDeployStatus(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		TargetName string `json:"target_name"`
-	}
+	var req models.TargetRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, 400, "invalid JSON")
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON")
 		return
 	}
 
 	cfg, err := config.Load()
 	if err != nil {
-		writeError(w, 500, err.Error())
+		writeError(w, 500, models.CodeInternal, err.Error())
 		return
 	}
 
-	target := findTarget(cfg, req.TargetName)
+	target := findUserTarget(r, cfg, req.TargetName)
 	if target == nil {
-		writeError(w, 404, "target not found")
+		writeError(w, 404, models.CodeTargetNotFound, "target not found")
 		return
 	}
 
-	status, err := deployer.Status(*target)
+	status, err := deployer.Status(r.Context(), *target, cfg)
 	if err != nil {
-		writeError(w, 500, err.Error())
+		writeError(w, 500, models.CodeTargetError, err.Error())
 		return
 	}
 	writeJSON(w, 200, status)
 }
 
 func handleRestore(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		TargetName string `json:"target_name"`
+	var req models.TargetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON")
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+
+	target := findUserTarget(r, cfg, req.TargetName)
+	if target == nil {
+		writeError(w, 404, models.CodeTargetNotFound, "target not found")
+		return
 	}
+
+	ctx, unlock, ok := lockTargetNow(w, r, *target, "restore")
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// Project files first: they do not depend on cli.js being found.
+	for _, ws := range cfg.Workspaces {
+		if ws.Target != target.Name {
+			continue
+		}
+		if err := deployer.RestoreWorkspace(ctx, *target, ws); err != nil {
+			writeError(w, 500, models.CodeTargetError, "workspace "+ws.Name+": "+err.Error())
+			return
+		}
+	}
+	if err := deployer.Restore(ctx, *target); err != nil {
+		writeError(w, 500, models.CodeTargetError, err.Error())
+		return
+	}
+	writeJSON(w, 200, models.APIResponse{Status: "ok", Message: "restored " + req.TargetName})
+}
+
+// --- Assets ---
+
+func handleSyncAssets(w http.ResponseWriter, r *http.Request) {
+	var req models.TargetRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, 400, "invalid JSON")
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON")
 		return
 	}
 
 	cfg, err := config.Load()
 	if err != nil {
-		writeError(w, 500, err.Error())
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	if cfg.AssetSync == nil || !cfg.AssetSync.Enabled {
+		writeError(w, 400, models.CodeAssetSyncDisabled, "asset sync is not enabled")
 		return
 	}
 
-	target := findTarget(cfg, req.TargetName)
+	target := findUserTarget(r, cfg, req.TargetName)
 	if target == nil {
-		writeError(w, 404, "target not found")
+		writeError(w, 404, models.CodeTargetNotFound, "target not found")
 		return
 	}
 
-	if err := deployer.Restore(*target); err != nil {
-		writeError(w, 500, err.Error())
+	ctx, unlock, ok := lockTargetNow(w, r, *target, "asset_sync")
+	if !ok {
 		return
 	}
-	writeJSON(w, 200, apiResponse{Status: "ok", Message: "restored " + req.TargetName})
+	defer unlock()
+
+	result, err := deployer.SyncAssets(ctx, *target, cfg.AssetSync)
+	if err != nil {
+		writeError(w, 500, models.CodeTargetError, err.Error())
+		return
+	}
+	writeJSON(w, 200, result)
 }
 
 // --- Targets ---
@@ -203,81 +687,697 @@ func handleRestore(w http.ResponseWriter, r *http.Request) {
 func handleGetTargets(w http.ResponseWriter, r *http.Request) {
 	cfg, err := config.Load()
 	if err != nil {
-		writeError(w, 500, err.Error())
+		writeError(w, 500, models.CodeInternal, err.Error())
 		return
 	}
-	writeJSON(w, 200, cfg.Targets)
+	u := currentUser(r)
+	targets := []models.Target{}
+	for _, t := range cfg.Targets {
+		if users.CanAccess(u, t.Name) {
+			targets = append(targets, t)
+		}
+	}
+	writeJSON(w, 200, targets)
 }
 
 func handleAddTarget(w http.ResponseWriter, r *http.Request) {
 	var target models.Target
 	if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
-		writeError(w, 400, "invalid JSON")
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON")
 		return
 	}
-	if target.Name == "" || target.Type == "" {
-		writeError(w, 400, "name and type are required")
+	if err := deployer.ValidateTarget(target); err != nil {
+		writeError(w, 400, models.CodeValidationFailed, err.Error())
 		return
 	}
+	target.Facts = nil // only a connection test sets them
 
 	cfg, err := config.Load()
 	if err != nil {
-		writeError(w, 500, err.Error())
+		writeError(w, 500, models.CodeInternal, err.Error())
 		return
 	}
 
 	// Check duplicate
 	for _, t := range cfg.Targets {
 		if t.Name == target.Name {
-			writeError(w, 409, "target already exists: "+target.Name)
+			writeError(w, 409, models.CodeAlreadyExists, "target already exists: "+target.Name)
 			return
 		}
 	}
 
 	cfg.Targets = append(cfg.Targets, target)
 	if err := config.Save(cfg); err != nil {
-		writeError(w, 500, err.Error())
+		writeError(w, 500, models.CodeInternal, err.Error())
 		return
 	}
-	writeJSON(w, 201, apiResponse{Status: "ok"})
+	writeJSON(w, 201, models.APIResponse{Status: "ok"})
 }
 
-func handleDeleteTarget(w http.ResponseWriter, r *http.Request) {
-	name := r.PathValue("name")
-	if name == "local" {
-		writeError(w, 400, "cannot delete local target")
+// handleDiscoverSSH lists the host aliases in ~/.ssh/config, marking those
+// that are targets already.
+func handleDiscoverSSH(w http.ResponseWriter, r *http.Request) {
+	hosts, err := sshconfig.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, "read ssh config: "+err.Error())
 		return
 	}
-
 	cfg, err := config.Load()
 	if err != nil {
-		writeError(w, 500, err.Error())
+		writeError(w, 500, models.CodeInternal, err.Error())
 		return
 	}
+	for i := range hosts {
+		hosts[i].Exists = findTarget(cfg, hosts[i].Alias) != nil
+	}
+	writeJSON(w, 200, hosts)
+}
 
-	filtered := cfg.Targets[:0]
-	found := false
-	for _, t := range cfg.Targets {
-		if t.Name == name {
-			found = true
-			continue
-		}
-		filtered = append(filtered, t)
+// handleImportSSH adds SSH targets for host aliases in ~/.ssh/config. The
+// target's host is the alias, so ssh keeps applying the config to it.
+func handleImportSSH(w http.ResponseWriter, r *http.Request) {
+	var req models.SSHImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON")
+		return
 	}
-	if !found {
-		writeError(w, 404, "target not found")
+	hosts, err := sshconfig.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, "read ssh config: "+err.Error())
 		return
 	}
-
-	cfg.Targets = filtered
-	if err := config.Save(cfg); err != nil {
-		writeError(w, 500, err.Error())
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
 		return
 	}
-	writeJSON(w, 200, apiResponse{Status: "ok"})
-}
 
-// --- Helpers ---
+	wanted := req.Hosts
+	if len(wanted) == 0 {
+		for _, h := range hosts {
+			wanted = append(wanted, h.Alias)
+		}
+	}
+	result := models.TargetImportResult{Imported: []string{}, Skipped: []models.SkippedHost{}}
+	skip := func(host, reason string) {
+		result.Skipped = append(result.Skipped, models.SkippedHost{Host: host, Reason: reason})
+	}
+	for _, alias := range wanted {
+		if strings.ContainsAny(alias, "*?!") {
+			skip(alias, "wildcard pattern")
+			continue
+		}
+		if !slices.ContainsFunc(hosts, func(h models.SSHHost) bool { return h.Alias == alias }) {
+			skip(alias, "not in ssh config")
+			continue
+		}
+		if findTarget(cfg, alias) != nil {
+			skip(alias, "target exists")
+			continue
+		}
+		t := models.Target{Name: alias, Type: models.TargetSSH, Host: alias}
+		if err := deployer.ValidateTarget(t); err != nil {
+			skip(alias, err.Error())
+			continue
+		}
+		cfg.Targets = append(cfg.Targets, t)
+		result.Imported = append(result.Imported, alias)
+	}
+
+	if len(result.Imported) > 0 {
+		if err := config.Save(cfg); err != nil {
+			writeError(w, 500, models.CodeInternal, err.Error())
+			return
+		}
+	}
+	writeJSON(w, 200, result)
+}
+
+// handleDiscoverDocker lists the running devcontainers, naming the target
+// of each that has one.
+func handleDiscoverDocker(w http.ResponseWriter, r *http.Request) {
+	list, err := docker.List(r.Context())
+	if err != nil {
+		writeError(w, 500, models.CodeDockerError, err.Error())
+		return
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	for i := range list {
+		if t := devcontainerTarget(cfg, list[i].LocalFolder); t != nil {
+			list[i].Target = t.Name
+		}
+	}
+	writeJSON(w, 200, list)
+}
+
+// handleImportDocker adds a docker target for each selected devcontainer.
+// Targets find the container by its project folder and are named after it.
+func handleImportDocker(w http.ResponseWriter, r *http.Request) {
+	var req models.ContainerImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON")
+		return
+	}
+	list, err := docker.List(r.Context())
+	if err != nil {
+		writeError(w, 500, models.CodeDockerError, err.Error())
+		return
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+
+	wanted := req.LocalFolders
+	if len(wanted) == 0 {
+		for _, c := range list {
+			wanted = append(wanted, c.LocalFolder)
+		}
+	}
+	result := models.TargetImportResult{Imported: []string{}, Skipped: []models.SkippedHost{}}
+	skip := func(host, reason string) {
+		result.Skipped = append(result.Skipped, models.SkippedHost{Host: host, Reason: reason})
+	}
+	for _, folder := range wanted {
+		name := filepath.Base(folder)
+		t := models.Target{Name: name, Type: models.TargetDocker, LocalFolder: folder}
+		switch {
+		case !slices.ContainsFunc(list, func(c models.Container) bool { return c.LocalFolder == folder }):
+			skip(folder, "no running devcontainer")
+		case devcontainerTarget(cfg, folder) != nil:
+			skip(folder, "target exists")
+		case findTarget(cfg, name) != nil:
+			skip(folder, "target name taken: "+name)
+		default:
+			if err := deployer.ValidateTarget(t); err != nil {
+				skip(folder, err.Error())
+				continue
+			}
+			cfg.Targets = append(cfg.Targets, t)
+			result.Imported = append(result.Imported, name)
+		}
+	}
+
+	if len(result.Imported) > 0 {
+		if err := config.Save(cfg); err != nil {
+			writeError(w, 500, models.CodeInternal, err.Error())
+			return
+		}
+	}
+	writeJSON(w, 200, result)
+}
+
+// handleDiscoverCodespaces lists the user's codespaces, naming the target
+// of each that has one.
+func handleDiscoverCodespaces(w http.ResponseWriter, r *http.Request) {
+	list, err := codespaces.List(r.Context())
+	if err != nil {
+		writeError(w, 500, models.CodeGitHubError, err.Error())
+		return
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	for i := range list {
+		if t := codespaceTarget(cfg, list[i].Name); t != nil {
+			list[i].Target = t.Name
+		}
+	}
+	writeJSON(w, 200, list)
+}
+
+// handleImportCodespaces adds a codespace target, named after the
+// codespace, for each selected codespace that has none yet.
+func handleImportCodespaces(w http.ResponseWriter, r *http.Request) {
+	var req models.CodespaceImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON")
+		return
+	}
+	list, err := codespaces.List(r.Context())
+	if err != nil {
+		writeError(w, 500, models.CodeGitHubError, err.Error())
+		return
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+
+	wanted := req.Names
+	if len(wanted) == 0 {
+		for _, cs := range list {
+			if req.Repo == "" || strings.EqualFold(cs.Repository, req.Repo) {
+				wanted = append(wanted, cs.Name)
+			}
+		}
+	}
+	result := models.TargetImportResult{Imported: []string{}, Skipped: []models.SkippedHost{}}
+	skip := func(host, reason string) {
+		result.Skipped = append(result.Skipped, models.SkippedHost{Host: host, Reason: reason})
+	}
+	for _, name := range wanted {
+		switch {
+		case !slices.ContainsFunc(list, func(cs models.Codespace) bool { return cs.Name == name }):
+			skip(name, "no such codespace")
+		case codespaceTarget(cfg, name) != nil:
+			skip(name, "target exists")
+		case findTarget(cfg, name) != nil:
+			skip(name, "target name taken")
+		default:
+			cfg.Targets = append(cfg.Targets, models.Target{Name: name, Type: models.TargetCodespace, Host: name})
+			result.Imported = append(result.Imported, name)
+		}
+	}
+
+	if len(result.Imported) > 0 {
+		if err := config.Save(cfg); err != nil {
+			writeError(w, 500, models.CodeInternal, err.Error())
+			return
+		}
+	}
+	writeJSON(w, 200, result)
+}
+
+// handleUpdateTarget replaces a target. A new name renames it, along with
+// the workspaces on it; cached facts are kept unless the way to reach it
+// changed.
+func handleUpdateTarget(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var target models.Target
+	if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON")
+		return
+	}
+	if err := deployer.ValidateTarget(target); err != nil {
+		writeError(w, 400, models.CodeValidationFailed, err.Error())
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	existing := findTarget(cfg, name)
+	if existing == nil {
+		writeError(w, 404, models.CodeTargetNotFound, "target not found")
+		return
+	}
+	if name == "local" && (target.Name != name || target.Type != models.TargetLocal) {
+		writeError(w, 400, models.CodeInvalidRequest, "cannot rename or retype local target")
+		return
+	}
+	if target.Name != name && findTarget(cfg, target.Name) != nil {
+		writeError(w, 409, models.CodeAlreadyExists, "target already exists: "+target.Name)
+		return
+	}
+
+	// Do not change a target under a running deploy, nor rename it onto a
+	// name whose lock is held.
+	lock, ok := lockTarget(w, r, name, "update")
+	if !ok {
+		return
+	}
+	defer lock.Release()
+	if target.Name != name {
+		newLock, ok := lockTarget(w, r, target.Name, "update")
+		if !ok {
+			return
+		}
+		defer newLock.Release()
+	}
+	orig, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+
+	target.Facts = nil
+	if sameConnection(target, *existing) {
+		target.Facts = existing.Facts
+	}
+	*existing = target
+	for i := range cfg.Workspaces {
+		if cfg.Workspaces[i].Target == name {
+			cfg.Workspaces[i].Target = target.Name
+		}
+	}
+	if err := config.Save(cfg); err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	// Accounts granted the old name keep the target.
+	if store := userStore(r); store != nil && target.Name != name {
+		if _, err := store.RenameTarget(name, target.Name); err != nil {
+			config.Save(orig)
+			writeError(w, 500, models.CodeInternal, "update user grants: "+err.Error())
+			return
+		}
+	}
+	writeJSON(w, 200, models.APIResponse{Status: "ok"})
+}
+
+// handleTestTarget runs a connection test and caches the facts it found on
+// the target.
+func handleTestTarget(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	target := findUserTarget(r, cfg, name)
+	if target == nil {
+		writeError(w, 404, models.CodeTargetNotFound, "target not found")
+		return
+	}
+
+	facts := deployer.TestTarget(r.Context(), *target)
+
+	// The test can take a while; save into the config as it is now.
+	cfg, err = config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	if t := findTarget(cfg, name); t != nil && sameConnection(*t, *target) {
+		t.Facts = facts
+		if err := config.Save(cfg); err != nil {
+			writeError(w, 500, models.CodeInternal, err.Error())
+			return
+		}
+	}
+	writeJSON(w, 200, facts)
+}
+
+func handleDeleteTarget(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == "local" {
+		writeError(w, 400, models.CodeInvalidRequest, "cannot delete local target")
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+
+	filtered := cfg.Targets[:0]
+	found := false
+	for _, t := range cfg.Targets {
+		if t.Name == name {
+			found = true
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+	if !found {
+		writeError(w, 404, models.CodeTargetNotFound, "target not found")
+		return
+	}
+	// Workspaces name their target; deleting it would orphan them.
+	var inUse []string
+	for _, ws := range cfg.Workspaces {
+		if ws.Target == name {
+			inUse = append(inUse, ws.Name)
+		}
+	}
+	if len(inUse) > 0 {
+		writeError(w, 409, models.CodeTargetInUse, "target is used by workspaces: "+strings.Join(inUse, ", "))
+		return
+	}
+
+	cfg.Targets = filtered
+	if err := config.Save(cfg); err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, 200, models.APIResponse{Status: "ok"})
+}
+
+// --- Workspaces ---
+
+func handleGetWorkspaces(w http.ResponseWriter, r *http.Request) {
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	u := currentUser(r)
+	workspaces := []models.Workspace{}
+	for _, ws := range cfg.Workspaces {
+		if users.CanAccess(u, ws.Target) {
+			workspaces = append(workspaces, ws)
+		}
+	}
+	writeJSON(w, 200, workspaces)
+}
+
+func handleAddWorkspace(w http.ResponseWriter, r *http.Request) {
+	var ws models.Workspace
+	if err := json.NewDecoder(r.Body).Decode(&ws); err != nil {
+		writeError(w, 400, models.CodeInvalidRequest, "invalid JSON")
+		return
+	}
+	if err := deployer.ValidateWorkspace(ws); err != nil {
+		writeError(w, 400, models.CodeValidationFailed, err.Error())
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	if findTarget(cfg, ws.Target) == nil {
+		writeError(w, 400, models.CodeValidationFailed, "target not found: "+ws.Target)
+		return
+	}
+	for _, existing := range cfg.Workspaces {
+		if existing.Name == ws.Name {
+			writeError(w, 409, models.CodeAlreadyExists, "workspace already exists: "+ws.Name)
+			return
+		}
+	}
+
+	cfg.Workspaces = append(cfg.Workspaces, ws)
+	if err := config.Save(cfg); err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, 201, models.APIResponse{Status: "ok"})
+}
+
+func handleDeleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+
+	filtered := cfg.Workspaces[:0]
+	found := false
+	for _, ws := range cfg.Workspaces {
+		if ws.Name == name {
+			found = true
+			continue
+		}
+		filtered = append(filtered, ws)
+	}
+	if !found {
+		writeError(w, 404, models.CodeWorkspaceNotFound, "workspace not found")
+		return
+	}
+
+	cfg.Workspaces = filtered
+	if err := config.Save(cfg); err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, 200, models.APIResponse{Status: "ok"})
+}
+
+func handleDeployWorkspace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	cfg, err := config.Load()
+	if err != nil {
+		writeError(w, 500, models.CodeInternal, err.Error())
+		return
+	}
+
+	ws := findWorkspace(cfg, name)
+	if ws == nil || !users.CanAccess(currentUser(r), ws.Target) {
+		writeError(w, 404, models.CodeWorkspaceNotFound, "workspace not found")
+		return
+	}
+	target := findTarget(cfg, ws.Target)
+	if target == nil {
+		writeError(w, 404, models.CodeTargetNotFound, "target not found: "+ws.Target)
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+	// The catalog check fixes the global config, which it may save; the
+	// preflight checks what is deployed, the workspace overrides included.
+	if !autoDetectBeforeDeploy(w, cfg, force) {
+		return
+	}
+	preflight, ok := preflightBeforeDeploy(w, *target, deployer.WorkspaceConfig(*ws, cfg), force)
+	if !ok {
+		return
+	}
+	t, workspace := *target, *ws
+	lock, ok := lockTarget(w, r, t.Name, models.JobDeployWorkspace)
+	if !ok {
+		return
+	}
+	job := jobs.Start(models.Job{
+		Kind:      models.JobDeployWorkspace,
+		Target:    t.Name,
+		Workspace: workspace.Name,
+		User:      currentUser(r).Name,
+		Preflight: preflight,
+	}, func(ctx context.Context, logf func(string, ...any)) (string, error) {
+		defer lock.Release()
+		ctx = deployer.WithLog(ctx, logf)
+		ctx, err := deployer.Pin(ctx, t)
+		if err != nil {
+			return "", err
+		}
+		unlock, err := deployer.LockRemote(ctx, t, lock.Holder)
+		if err != nil {
+			return "", err
+		}
+		defer unlock()
+		if err := deployer.DeployWorkspace(ctx, t, workspace, cfg); err != nil {
+			return "", err
+		}
+		return "deployed workspace " + workspace.Name, nil
+	})
+	writeJSON(w, 202, job)
+}
+
+// --- Jobs ---
+
+func handleGetJobs(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	list := []models.Job{}
+	for _, j := range jobs.List() {
+		if users.CanAccess(u, j.Target) {
+			list = append(list, j)
+		}
+	}
+	writeJSON(w, 200, list)
+}
+
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := jobs.Get(r.PathValue("id"))
+	if err != nil || !users.CanAccess(currentUser(r), job.Target) {
+		writeError(w, 404, models.CodeJobNotFound, "job not found")
+		return
+	}
+	writeJSON(w, 200, job)
+}
+
+func handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if job, err := jobs.Get(id); err != nil || !users.CanAccess(currentUser(r), job.Target) {
+		writeError(w, 404, models.CodeJobNotFound, "job not found")
+		return
+	}
+	job, err := jobs.Cancel(id)
+	if errors.Is(err, jobs.ErrFinished) {
+		writeError(w, 409, models.CodeJobFinished, "job already "+string(job.State))
+		return
+	}
+	if err != nil {
+		writeError(w, 404, models.CodeJobNotFound, "job not found")
+		return
+	}
+	writeJSON(w, 202, job)
+}
+
+// --- Helpers ---
+
+// lockTarget takes this machine's lock on a target for op. When someone
+// else holds it, it writes a 409 naming the holder and returns false.
+func lockTarget(w http.ResponseWriter, r *http.Request, target, op string) (*locks.Lock, bool) {
+	lock, err := locks.Acquire(target, locks.NewHolder(op, currentUser(r).Name))
+	if err != nil {
+		writeLockError(w, err)
+		return nil, false
+	}
+	return lock, true
+}
+
+// lockedRequestTimeout bounds an operation that runs within a request under
+// the target locks (restore, asset sync), as the job timeout bounds jobs.
+// It must stay below locks.StaleAfter, or the locks could be taken over
+// while the operation still runs.
+const lockedRequestTimeout = 30 * time.Minute
+
+// lockTargetNow takes both the local and, for remote targets, the remote
+// lock for an operation that runs within the request. The operation runs in
+// the returned context, pinned to the container or pod that was locked and
+// bounded by lockedRequestTimeout.
+func lockTargetNow(w http.ResponseWriter, r *http.Request, target models.Target, op string) (context.Context, func(), bool) {
+	lock, ok := lockTarget(w, r, target.Name, op)
+	if !ok {
+		return nil, nil, false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), lockedRequestTimeout)
+	ctx, err := deployer.Pin(ctx, target)
+	if err != nil {
+		cancel()
+		lock.Release()
+		writeError(w, 500, models.CodeTargetError, err.Error())
+		return nil, nil, false
+	}
+	unlock, err := deployer.LockRemote(ctx, target, lock.Holder)
+	if err != nil {
+		cancel()
+		lock.Release()
+		writeLockError(w, err)
+		return nil, nil, false
+	}
+	return ctx, func() { unlock(); lock.Release(); cancel() }, true
+}
+
+func writeLockError(w http.ResponseWriter, err error) {
+	var locked *locks.LockedError
+	if errors.As(err, &locked) {
+		writeJSON(w, 409, models.APIResponse{
+			Status:  "error",
+			Code:    models.CodeTargetLocked,
+			Message: err.Error(),
+			Lock:    &locked.Holder,
+		})
+		return
+	}
+	writeError(w, 500, models.CodeTargetError, err.Error())
+}
+
+// --- Session ---
+
+// handleMe tells the UI who is logged in, so it can hide what the role
+// cannot do.
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	writeJSON(w, 200, models.Session{Name: u.Name, Role: u.Role, Targets: u.Targets})
+}
 
 func findTarget(cfg *models.Config, name string) *models.Target {
 	for i := range cfg.Targets {
@@ -287,3 +1387,41 @@ func findTarget(cfg *models.Config, name string) *models.Target {
 	}
 	return nil
 }
+
+// devcontainerTarget returns the docker target of the devcontainer for
+// a project folder.
+func devcontainerTarget(cfg *models.Config, folder string) *models.Target {
+	for i := range cfg.Targets {
+		if cfg.Targets[i].Type == models.TargetDocker && cfg.Targets[i].LocalFolder == folder {
+			return &cfg.Targets[i]
+		}
+	}
+	return nil
+}
+
+// sameConnection reports whether two targets reach the same machine the
+// same way, so that facts about one hold for the other.
+func sameConnection(a, b models.Target) bool {
+	return a.Type == b.Type && a.Host == b.Host && a.LocalFolder == b.LocalFolder && a.User == b.User &&
+		a.Namespace == b.Namespace && a.Selector == b.Selector && a.Container == b.Container && a.KubeContext == b.KubeContext
+}
+
+// codespaceTarget returns the codespace target connecting to the named
+// codespace.
+func codespaceTarget(cfg *models.Config, codespace string) *models.Target {
+	for i := range cfg.Targets {
+		if cfg.Targets[i].Type == models.TargetCodespace && cfg.Targets[i].Host == codespace {
+			return &cfg.Targets[i]
+		}
+	}
+	return nil
+}
+
+func findWorkspace(cfg *models.Config, name string) *models.Workspace {
+	for i := range cfg.Workspaces {
+		if cfg.Workspaces[i].Name == name {
+			return &cfg.Workspaces[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-relay/internal/config"
+	"claude-relay/internal/locks"
+	"claude-relay/internal/models"
+	"claude-relay/internal/users"
+)
+
+// setupConfig points the config at a fresh HOME and saves cfg there.
+func setupConfig(t *testing.T, cfg *models.Config) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	config.Init()
+	if err := config.Save(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// multiUserServer returns the full handler in multi-user mode with one
+// account per role; every password is "pw-" followed by the name.
+func multiUserServer(t *testing.T) http.Handler {
+	t.Helper()
+	setupConfig(t, &models.Config{Targets: []models.Target{
+		{Name: "dev-1", Type: models.TargetLocal},
+		{Name: "prod", Type: models.TargetSSH, Host: "prod"},
+	}})
+	f := &models.UsersFile{Users: []models.User{
+		{Name: "vera", Role: models.RoleViewer},
+		{Name: "otto", Role: models.RoleOperator, Targets: []string{"dev-*"}},
+		{Name: "ivy", Role: models.RoleOperator, Targets: []string{"prod"}},
+		{Name: "ada", Role: models.RoleAdmin},
+	}}
+	for i := range f.Users {
+		hash, err := users.HashPassword("pw-" + f.Users[i].Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Users[i].PasswordHash = hash
+	}
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := users.WriteFile(path, f); err != nil {
+		t.Fatal(err)
+	}
+	store, err := users.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New("127.0.0.1:8787", Options{Users: store}).Handler
+}
+
+// as logs in as name with its test password and sends body as JSON.
+func as(name, body string) func(*http.Request) {
+	return func(r *http.Request) {
+		r.SetBasicAuth(name, "pw-"+name)
+		if body != "" {
+			r.Body = io.NopCloser(strings.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Set("Content-Type", "application/json")
+		}
+	}
+}
+
+func TestMultiUserLogin(t *testing.T) {
+	h := multiUserServer(t)
+	tests := []struct {
+		name string
+		edit func(*http.Request)
+	}{
+		{"no credentials", nil},
+		{"wrong password", func(r *http.Request) { r.SetBasicAuth("ada", "pw-otto") }},
+		{"unknown user", func(r *http.Request) { r.SetBasicAuth("mallory", "pw-mallory") }},
+		{"empty password", func(r *http.Request) { r.SetBasicAuth("ada", "") }},
+		// The session token of single-user mode means nothing here.
+		{"bearer token", bearer("pw-ada")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{"/api/me", "/"} {
+				rec := serve(h, "GET", path, tt.edit)
+				if rec.Code != 401 || errorCode(rec) != models.CodeUnauthorized {
+					t.Errorf("%s: got %d %q", path, rec.Code, errorCode(rec))
+				}
+				if !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Basic ") {
+					t.Errorf("%s: no Basic challenge", path)
+				}
+			}
+		})
+	}
+
+	rec := serve(h, "GET", "/api/me", as("otto", ""))
+	var me models.Session
+	if rec.Code != 200 || json.Unmarshal(rec.Body.Bytes(), &me) != nil || me.Name != "otto" || me.Role != models.RoleOperator {
+		t.Errorf("otto: got %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(h, "POST", "/api/deploy", func(r *http.Request) {
+		as("ada", `{}`)(r)
+		r.Header.Set("Origin", "http://evil.example")
+	})
+	if rec.Code != 403 || errorCode(rec) != models.CodeCrossOrigin {
+		t.Errorf("cross-origin with valid login: got %d %q", rec.Code, errorCode(rec))
+	}
+}
+
+func TestMultiUserRoles(t *testing.T) {
+	h := multiUserServer(t)
+	tests := []struct {
+		name, user, method, path, body string
+		status                         int
+		code                           models.ErrorCode
+	}{
+		{"viewer reads status", "vera", "POST", "/api/deploy/status", `{"target_name":"dev-1"}`, 200, ""},
+		{"viewer cannot deploy", "vera", "POST", "/api/deploy", `{"target_name":"dev-1"}`, 403, models.CodeForbidden},
+		{"viewer cannot restore", "vera", "POST", "/api/deploy/restore", `{"target_name":"dev-1"}`, 403, models.CodeForbidden},
+		{"viewer cannot cancel jobs", "vera", "DELETE", "/api/jobs/123", "", 403, models.CodeForbidden},
+		{"operator cannot read config", "otto", "GET", "/api/config", "", 403, models.CodeForbidden},
+		{"operator cannot add targets", "otto", "POST", "/api/targets", `{"name":"x","type":"local"}`, 403, models.CodeForbidden},
+		{"operator reads own target", "otto", "POST", "/api/deploy/status", `{"target_name":"dev-1"}`, 200, ""},
+		// Targets outside the account's patterns look missing.
+		{"operator status elsewhere", "otto", "POST", "/api/deploy/status", `{"target_name":"prod"}`, 404, models.CodeTargetNotFound},
+		{"operator deploys elsewhere", "otto", "POST", "/api/deploy", `{"target_name":"prod"}`, 404, models.CodeTargetNotFound},
+		{"operator restores elsewhere", "otto", "POST", "/api/deploy/restore", `{"target_name":"prod"}`, 404, models.CodeTargetNotFound},
+		{"operator tests elsewhere", "otto", "POST", "/api/targets/prod/test", "", 404, models.CodeTargetNotFound},
+		{"admin reads config", "ada", "GET", "/api/config", "", 200, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, tt.method, tt.path, as(tt.user, tt.body))
+			if rec.Code != tt.status || errorCode(rec) != tt.code {
+				t.Errorf("got %d %q, want %d %q: %s", rec.Code, errorCode(rec), tt.status, tt.code, rec.Body)
+			}
+		})
+	}
+}
+
+// targetNames lists the targets user sees.
+func targetNames(t *testing.T, h http.Handler, user string) []string {
+	t.Helper()
+	rec := serve(h, "GET", "/api/targets", as(user, ""))
+	var targets []models.Target
+	if err := json.Unmarshal(rec.Body.Bytes(), &targets); err != nil {
+		t.Fatalf("%s: %d %s", user, rec.Code, rec.Body)
+	}
+	var names []string
+	for _, tg := range targets {
+		names = append(names, tg.Name)
+	}
+	return names
+}
+
+func TestMultiUserTargetList(t *testing.T) {
+	h := multiUserServer(t)
+	for user, want := range map[string][]string{
+		"otto": {"dev-1"},
+		"ivy":  {"prod"},
+		"vera": {"dev-1", "prod"},
+		"ada":  {"dev-1", "prod"},
+	} {
+		if names := targetNames(t, h, user); strings.Join(names, ",") != strings.Join(want, ",") {
+			t.Errorf("%s sees %q, want %q", user, names, want)
+		}
+	}
+}
+
+func TestRenameTarget(t *testing.T) {
+	h := multiUserServer(t)
+	rename := as("ada", `{"name":"prod-eu","type":"ssh","host":"prod"}`)
+
+	// The new name is locked as well as the old one.
+	held, err := locks.Acquire("prod-eu", locks.NewHolder("deploy", "otto"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(h, "PUT", "/api/targets/prod", rename); rec.Code != 409 || errorCode(rec) != models.CodeTargetLocked {
+		t.Errorf("onto a locked name: got %d %s", rec.Code, rec.Body)
+	}
+	held.Release()
+	if names := targetNames(t, h, "ada"); strings.Join(names, ",") != "dev-1,prod" {
+		t.Errorf("refused rename changed the targets: %q", names)
+	}
+
+	if rec := serve(h, "PUT", "/api/targets/prod", rename); rec.Code != 200 {
+		t.Fatalf("rename: got %d %s", rec.Code, rec.Body)
+	}
+	// An account granted the old name follows the target.
+	if names := targetNames(t, h, "ivy"); strings.Join(names, ",") != "prod-eu" {
+		t.Errorf("ivy sees %q after the rename", names)
+	}
+	if names := targetNames(t, h, "otto"); strings.Join(names, ",") != "dev-1" {
+		t.Errorf("otto sees %q after the rename", names)
+	}
+}
+
+func TestDeleteTargetInUse(t *testing.T) {
+	h := multiUserServer(t)
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Workspaces = []models.Workspace{{Name: "api", Target: "prod", Path: "/srv/api"}}
+	if err := config.Save(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(h, "DELETE", "/api/targets/prod", as("ada", ""))
+	if rec.Code != 409 || errorCode(rec) != models.CodeTargetInUse || !strings.Contains(rec.Body.String(), "api") {
+		t.Errorf("delete with a workspace: got %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "DELETE", "/api/workspaces/api", as("ada", "")); rec.Code != 200 {
+		t.Fatalf("delete workspace: got %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "DELETE", "/api/targets/prod", as("ada", "")); rec.Code != 200 {
+		t.Errorf("delete after the workspace: got %d %s", rec.Code, rec.Body)
+	}
+	if names := targetNames(t, h, "ada"); strings.Join(names, ",") != "dev-1" {
+		t.Errorf("targets after delete: %q", names)
+	}
+}
+
+func TestLockTargetNowDeadline(t *testing.T) {
+	if lockedRequestTimeout >= locks.StaleAfter {
+		t.Fatalf("lockedRequestTimeout %s does not stay below locks.StaleAfter %s", lockedRequestTimeout, locks.StaleAfter)
+	}
+	setupConfig(t, &models.Config{})
+	target := models.Target{Name: "local", Type: models.TargetLocal}
+	r := httptest.NewRequest("POST", "/api/deploy/restore", nil)
+
+	ctx, unlock, ok := lockTargetNow(httptest.NewRecorder(), r, target, "restore")
+	if !ok {
+		t.Fatal("lock refused")
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > lockedRequestTimeout {
+		t.Errorf("deadline %v, %v", deadline, ok)
+	}
+	// A second operation waits for the first to finish.
+	rec := httptest.NewRecorder()
+	if _, _, ok := lockTargetNow(rec, r, target, "asset_sync"); ok || rec.Code != 409 {
+		t.Errorf("second lock: %v, %d", ok, rec.Code)
+	}
+	unlock()
+	if ctx.Err() == nil {
+		t.Error("context still live after unlock")
+	}
+	if _, unlock, ok := lockTargetNow(httptest.NewRecorder(), r, target, "asset_sync"); !ok {
+		t.Error("lock not released")
+	} else {
+		unlock()
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"claude-relay/internal/models"
+)
+
+// apiVersion is the version reported in the OpenAPI document; bump it when
+// a request or response changes incompatibly.
+const apiVersion = "2.0.0"
+
+// handleOpenAPI serves the OpenAPI 3 description of apiRoutes.
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, openAPIDocument(apiRoutes()))
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// enums lists the values of string types that only take a fixed set.
+var enums = map[reflect.Type][]string{
+	reflect.TypeFor[models.Role]():       {string(models.RoleViewer), string(models.RoleOperator), string(models.RoleAdmin)},
+	reflect.TypeFor[models.TargetType](): {string(models.TargetLocal), string(models.TargetSSH), string(models.TargetCodespace), string(models.TargetDocker), string(models.TargetKubernetes)},
+	reflect.TypeFor[models.JobState]():   {string(models.JobRunning), string(models.JobSucceeded), string(models.JobFailed), string(models.JobCanceled)},
+	reflect.TypeFor[models.ErrorCode](): func() []string {
+		var codes []string
+		for _, c := range models.ErrorCodes {
+			codes = append(codes, string(c))
+		}
+		return codes
+	}(),
+}
+
+// openAPIDocument builds the OpenAPI document for routes, deriving schemas
+// from the Go request and response types and their json tags.
+func openAPIDocument(routes []route) map[string]any {
+	schemas := map[string]any{}
+	errorResponse := map[string]any{
+		"description": "Error; code tells the kind",
+		"content":     jsonContent(schemaOf(reflect.TypeFor[models.APIResponse](), schemas)),
+	}
+
+	paths := map[string]map[string]any{}
+	for _, rt := range routes {
+		var params []any
+		for _, m := range pathParam.FindAllStringSubmatch(rt.path, -1) {
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+			})
+		}
+		for name, desc := range rt.query {
+			params = append(params, map[string]any{
+				"name": name, "in": "query", "description": desc, "schema": map[string]any{"type": "boolean"},
+			})
+		}
+
+		status := rt.status
+		if status == 0 {
+			status = 200
+		}
+		op := map[string]any{
+			"operationId":     rt.id,
+			"summary":         rt.summary,
+			"x-required-role": rt.role,
+			"responses": map[string]any{
+				strconv.Itoa(status): map[string]any{
+					"description": http.StatusText(status),
+					"content":     jsonContent(schemaOf(reflect.TypeOf(rt.resp), schemas)),
+				},
+				"default": errorResponse,
+			},
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if rt.body != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  jsonContent(schemaOf(reflect.TypeOf(rt.body), schemas)),
+			}
+		}
+		if paths[rt.path] == nil {
+			paths[rt.path] = map[string]any{}
+		}
+		paths[rt.path][strings.ToLower(rt.method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "claude-relay API",
+			"version": apiVersion,
+			"description": "Errors share the APIResponse schema with status \"error\" and a machine-readable code. " +
+				"x-required-role is the minimum account role in multi-user mode.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"token":   map[string]any{"type": "http", "scheme": "bearer", "description": "Session token printed at startup"},
+				"session": map[string]any{"type": "apiKey", "in": "cookie", "name": "claude_relay_session_<port>"},
+				"login":   map[string]any{"type": "http", "scheme": "basic", "description": "Multi-user mode accounts"},
+			},
+		},
+		"security": []any{
+			map[string]any{"token": []string{}},
+			map[string]any{"session": []string{}},
+			map[string]any{"login": []string{}},
+		},
+	}
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// schemaOf returns the schema of t, adding named structs to schemas and
+// referring to them by $ref.
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	if values, ok := enums[t]; ok {
+		return map[string]any{"type": "string", "enum": values}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := schemaOf(t.Elem(), schemas)
+		if _, ref := s["$ref"]; ref {
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = nil // placeholder for recursive types
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	props := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaOf(f.Type, schemas)
+	}
+	return map[string]any{"type": "object", "properties": props}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"claude-relay/internal/models"
+)
+
+// TestOpenAPICoversRoutes checks the served document against the route
+// table: every route appears with its operationId and role, and every
+// $ref resolves.
+func TestOpenAPICoversRoutes(t *testing.T) {
+	setupConfig(t, &models.Config{})
+	h := New("127.0.0.1:8787", Options{Token: testToken}).Handler
+	rec := serve(h, "GET", "/api/openapi.json", bearer(testToken))
+	if rec.Code != 200 {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	var doc struct {
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[string]bool{}
+	ops := 0
+	for _, rt := range apiRoutes() {
+		op := doc.Paths[rt.path][strings.ToLower(rt.method)]
+		if op == nil {
+			t.Errorf("%s %s missing from the document", rt.method, rt.path)
+			continue
+		}
+		if op["operationId"] != rt.id || op["x-required-role"] != string(rt.role) {
+			t.Errorf("%s %s: operationId %v, role %v", rt.method, rt.path, op["operationId"], op["x-required-role"])
+		}
+		if ids[rt.id] {
+			t.Errorf("operationId %s used twice", rt.id)
+		}
+		ids[rt.id] = true
+		if strings.Contains(rt.path, "{") && op["parameters"] == nil {
+			t.Errorf("%s %s: no path parameters", rt.method, rt.path)
+		}
+	}
+	for _, methods := range doc.Paths {
+		ops += len(methods)
+	}
+	if ops != len(apiRoutes()) {
+		t.Errorf("document has %d operations, route table %d", ops, len(apiRoutes()))
+	}
+
+	refs := strings.Split(rec.Body.String(), `"$ref":"#/components/schemas/`)[1:]
+	if len(refs) == 0 {
+		t.Error("no $ref in the document")
+	}
+	for _, ref := range refs {
+		name, _, _ := strings.Cut(ref, `"`)
+		if doc.Components.Schemas[name] == nil {
+			t.Errorf("$ref to missing schema %s", name)
+		}
+	}
+}
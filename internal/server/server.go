@@ -5,25 +5,127 @@ import (
 	"net/http"
 
 	"claude-relay/frontend"
+	"claude-relay/internal/models"
 )
 
-func New(addr string) *http.Server {
+// route is one API endpoint. The same table registers the handlers and
+// generates /api/openapi.json, so the document cannot drift from the mux.
+type route struct {
+	method  string
+	path    string
+	role    models.Role // minimum role that may call it
+	handler http.HandlerFunc
+	id      string // OpenAPI operationId
+	summary string
+	query   map[string]string // query parameter -> description
+	body    any               // request body, nil for none
+	resp    any               // success response body
+	status  int               // success status, 200 if zero
+}
+
+// apiRoutes lists every API endpoint.
+func apiRoutes() []route {
+	force := map[string]string{"force": "true to deploy even if the catalog check or preflight fails"}
+	return []route{
+		{method: "GET", path: "/api/openapi.json", role: models.RoleViewer, handler: handleOpenAPI,
+			id: "getOpenAPI", summary: "This OpenAPI document", resp: map[string]any{}},
+		{method: "GET", path: "/api/me", role: models.RoleViewer, handler: handleMe,
+			id: "getSession", summary: "The logged-in account", resp: models.Session{}},
+		{method: "GET", path: "/api/config", role: models.RoleAdmin, handler: handleGetConfig,
+			id: "getConfig", summary: "Relay config with the API key masked", resp: models.Config{}},
+		{method: "PUT", path: "/api/config", role: models.RoleAdmin, handler: handlePutConfig,
+			id: "putConfig", summary: "Replace the relay config; a masked API key keeps the stored one",
+			body: models.Config{}, resp: models.APIResponse{}},
+		{method: "GET", path: "/api/permissions", role: models.RoleAdmin, handler: handleGetPermissions,
+			id: "getPermissions", summary: "Managed permissions block", resp: models.Permissions{}},
+		{method: "PUT", path: "/api/permissions", role: models.RoleAdmin, handler: handlePutPermissions,
+			id: "putPermissions", summary: "Replace the managed permissions block; empty stops managing it",
+			body: models.Permissions{}, resp: models.APIResponse{}},
+		{method: "GET", path: "/api/hooks", role: models.RoleAdmin, handler: handleGetHooks,
+			id: "getHooks", summary: "Managed hooks by event", resp: map[string][]models.HookMatcher{}},
+		{method: "PUT", path: "/api/hooks", role: models.RoleAdmin, handler: handlePutHooks,
+			id: "putHooks", summary: "Replace the managed hooks",
+			body: map[string][]models.HookMatcher{}, resp: models.APIResponse{}},
+		{method: "GET", path: "/api/models/detect", role: models.RoleOperator, handler: handleDetectModels,
+			id: "detectModels", summary: "Relay catalog with suggested mappings",
+			query: map[string]string{"refresh": "true to bypass the catalog cache"}, resp: models.DetectModelsResponse{}},
+		{method: "GET", path: "/api/models/history", role: models.RoleOperator, handler: handleModelHistory,
+			id: "getModelHistory", summary: "Catalog changes seen so far", resp: []models.CatalogChange{}},
+		{method: "GET", path: "/api/relay/account", role: models.RoleOperator, handler: handleRelayAccount,
+			id: "getRelayAccount", summary: "Balance and usage of the API key", resp: models.AccountResponse{}},
+		{method: "GET", path: "/api/autodetect", role: models.RoleViewer, handler: handleGetAutoDetect,
+			id: "getAutoDetect", summary: "Latest catalog check", resp: models.AutoDetectResponse{}},
+		{method: "POST", path: "/api/autodetect/run", role: models.RoleOperator, handler: handleRunAutoDetect,
+			id: "runAutoDetect", summary: "Check the configured models against the catalog now", resp: models.AutoDetectResponse{}},
+		{method: "POST", path: "/api/deploy", role: models.RoleOperator, handler: handleDeploy,
+			id: "deploy", summary: "Start deploying the config to a target", body: models.DeployRequest{}, resp: models.Job{}, status: 202},
+		{method: "POST", path: "/api/deploy/codespaces", role: models.RoleOperator, handler: handleDeployCodespaces,
+			id: "deployCodespaces", summary: "Start deploying to every codespace of a repository",
+			body: models.CodespaceDeployRequest{}, resp: models.CodespaceDeployResult{}, status: 202},
+		{method: "POST", path: "/api/deploy/preview", role: models.RoleOperator, handler: handleDeployPreview,
+			id: "previewDeploy", summary: "What a deploy would write", body: models.TargetRequest{}, resp: models.DeployPreview{}},
+		{method: "POST", path: "/api/deploy/preflight", role: models.RoleOperator, handler: handleDeployPreflight,
+			id: "preflightDeploy", summary: "Check a deploy without running it", body: models.TargetRequest{}, resp: models.PreflightReport{}},
+		{method: "POST", path: "/api/deploy/status", role: models.RoleViewer, handler: handleDeployStatus,
+			id: "getDeployStatus", summary: "Deploy state of a target", body: models.TargetRequest{}, resp: models.DeployStatus{}},
+		{method: "POST", path: "/api/deploy/restore", role: models.RoleOperator, handler: handleRestore,
+			id: "restore", summary: "Restore the settings of a target and its workspaces from backup", body: models.TargetRequest{}, resp: models.APIResponse{}},
+		{method: "POST", path: "/api/assets/sync", role: models.RoleOperator, handler: handleSyncAssets,
+			id: "syncAssets", summary: "Push shared assets to a target", body: models.TargetRequest{}, resp: models.AssetSyncResult{}},
+		{method: "GET", path: "/api/targets", role: models.RoleViewer, handler: handleGetTargets,
+			id: "listTargets", summary: "Targets the account may access", resp: []models.Target{}},
+		{method: "POST", path: "/api/targets", role: models.RoleAdmin, handler: handleAddTarget,
+			id: "addTarget", summary: "Add a target", body: models.Target{}, resp: models.APIResponse{}, status: 201},
+		{method: "GET", path: "/api/targets/discover/ssh", role: models.RoleAdmin, handler: handleDiscoverSSH,
+			id: "discoverSSHTargets", summary: "Host aliases in ~/.ssh/config that can become targets", resp: []models.SSHHost{}},
+		{method: "POST", path: "/api/targets/discover/ssh", role: models.RoleAdmin, handler: handleImportSSH,
+			id: "importSSHTargets", summary: "Add SSH targets for ~/.ssh/config aliases, skipping wildcards and existing names",
+			body: models.SSHImportRequest{}, resp: models.TargetImportResult{}},
+		{method: "GET", path: "/api/targets/discover/docker", role: models.RoleAdmin, handler: handleDiscoverDocker,
+			id: "discoverDevcontainers", summary: "Running devcontainers with their project folder and target", resp: []models.Container{}},
+		{method: "POST", path: "/api/targets/discover/docker", role: models.RoleAdmin, handler: handleImportDocker,
+			id: "importDevcontainers", summary: "Add docker targets for devcontainers that have none",
+			body: models.ContainerImportRequest{}, resp: models.TargetImportResult{}},
+		{method: "GET", path: "/api/targets/discover/codespaces", role: models.RoleAdmin, handler: handleDiscoverCodespaces,
+			id: "discoverCodespaces", summary: "Codespaces from gh with repo, branch, state and their target", resp: []models.Codespace{}},
+		{method: "POST", path: "/api/targets/discover/codespaces", role: models.RoleAdmin, handler: handleImportCodespaces,
+			id: "importCodespaces", summary: "Add targets for codespaces that have none",
+			body: models.CodespaceImportRequest{}, resp: models.TargetImportResult{}},
+		{method: "PUT", path: "/api/targets/{name}", role: models.RoleAdmin, handler: handleUpdateTarget,
+			id: "updateTarget", summary: "Replace or rename a target; workspaces and exact user grants follow a rename", body: models.Target{}, resp: models.APIResponse{}},
+		{method: "POST", path: "/api/targets/{name}/test", role: models.RoleOperator, handler: handleTestTarget,
+			id: "testTarget", summary: "Test the connection and cache OS, tools and copilot-chat versions", resp: models.TargetFacts{}},
+		{method: "DELETE", path: "/api/targets/{name}", role: models.RoleAdmin, handler: handleDeleteTarget,
+			id: "deleteTarget", summary: "Remove a target; refused while workspaces use it", resp: models.APIResponse{}},
+		{method: "GET", path: "/api/workspaces", role: models.RoleViewer, handler: handleGetWorkspaces,
+			id: "listWorkspaces", summary: "Workspaces on targets the account may access", resp: []models.Workspace{}},
+		{method: "POST", path: "/api/workspaces", role: models.RoleAdmin, handler: handleAddWorkspace,
+			id: "addWorkspace", summary: "Add a workspace", body: models.Workspace{}, resp: models.APIResponse{}, status: 201},
+		{method: "DELETE", path: "/api/workspaces/{name}", role: models.RoleAdmin, handler: handleDeleteWorkspace,
+			id: "deleteWorkspace", summary: "Remove a workspace", resp: models.APIResponse{}},
+		{method: "POST", path: "/api/workspaces/{name}/deploy", role: models.RoleOperator, handler: handleDeployWorkspace,
+			id: "deployWorkspace", summary: "Start deploying project settings to a workspace", query: force, resp: models.Job{}, status: 202},
+		{method: "GET", path: "/api/jobs", role: models.RoleViewer, handler: handleGetJobs,
+			id: "listJobs", summary: "Recent jobs on targets the account may access, without logs", resp: []models.Job{}},
+		{method: "GET", path: "/api/jobs/{id}", role: models.RoleViewer, handler: handleGetJob,
+			id: "getJob", summary: "State and log of a job", resp: models.Job{}},
+		{method: "DELETE", path: "/api/jobs/{id}", role: models.RoleOperator, handler: handleCancelJob,
+			id: "cancelJob", summary: "Cancel a running job", resp: models.Job{}, status: 202},
+	}
+}
+
+// New returns the web server for addr. Every request passes the Host, Origin
+// and token or login checks described in Options.
+func New(addr string, opts Options) *http.Server {
 	mux := http.NewServeMux()
 
-	// API routes
-	mux.HandleFunc("GET /api/config", handleGetConfig)
-	mux.HandleFunc("PUT /api/config", handlePutConfig)
-	mux.HandleFunc("GET /api/models/detect", handleDetectModels)
-	mux.HandleFunc("POST /api/deploy", handleDeploy)
-	mux.HandleFunc("POST /api/deploy/status", handleDeployStatus)
-	mux.HandleFunc("POST /api/deploy/restore", handleRestore)
-	mux.HandleFunc("GET /api/targets", handleGetTargets)
-	mux.HandleFunc("POST /api/targets", handleAddTarget)
-	mux.HandleFunc("DELETE /api/targets/{name}", handleDeleteTarget)
+	for _, rt := range apiRoutes() {
+		mux.HandleFunc(rt.method+" "+rt.path, requireRole(rt.role, rt.handler))
+	}
 
 	// Frontend (embedded)
 	frontendFS, _ := fs.Sub(frontend.Assets, ".")
 	mux.Handle("/", http.FileServer(http.FS(frontendFS)))
 
-	return &http.Server{Addr: addr, Handler: mux}
+	return &http.Server{Addr: addr, Handler: newGuard(addr, opts, mux)}
 }
@@ -0,0 +1,223 @@
+// Package sshconfig reads OpenSSH client config files to find the host
+// aliases that can become SSH targets. It understands Host blocks, Include
+// and the settings claude-relay shows (HostName, User, Port, IdentityFile,
+// ProxyJump); Match blocks and everything else are skipped.
+package sshconfig
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"claude-relay/internal/models"
+)
+
+// maxIncludeDepth matches the recursion limit of OpenSSH.
+const maxIncludeDepth = 16
+
+// block is a Host (or Match) section; the settings before the first Host
+// line form a block that applies to every host.
+type block struct {
+	patterns []string // nil for the leading global block
+	match    bool     // a Match block, never applied
+	source   string
+	options  [][2]string // lower-cased keyword, value
+}
+
+// Load parses ~/.ssh/config and returns its concrete host aliases.
+func Load() ([]models.SSHHost, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return Parse(filepath.Join(home, ".ssh", "config"))
+}
+
+// Parse returns the host aliases defined in the config file and
+// its includes, in file order, with the settings ssh would resolve for
+// each. Wildcard and negated patterns are not aliases and are left out. A
+// missing file yields no hosts.
+func Parse(file string) ([]models.SSHHost, error) {
+	p := &parser{sshDir: filepath.Dir(file)}
+	p.blocks = []*block{{source: file}}
+	if err := p.parseFile(file, 0); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	hosts := []models.SSHHost{}
+	for _, b := range p.blocks {
+		if b.match {
+			continue
+		}
+		for _, pat := range b.patterns {
+			if isWildcard(pat) || seen[pat] {
+				continue
+			}
+			seen[pat] = true
+			hosts = append(hosts, p.resolve(pat, b.source))
+		}
+	}
+	return hosts, nil
+}
+
+type parser struct {
+	sshDir string // relative Include paths are resolved against it
+	blocks []*block
+}
+
+func (p *parser) parseFile(file string, depth int) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		key, value, ok := splitLine(sc.Text())
+		if !ok {
+			continue
+		}
+		switch key {
+		case "host":
+			var patterns []string
+			for _, pat := range strings.Fields(value) {
+				patterns = append(patterns, strings.Trim(pat, `"`))
+			}
+			p.blocks = append(p.blocks, &block{patterns: patterns, source: file})
+		case "match":
+			p.blocks = append(p.blocks, &block{match: true, source: file})
+		case "include":
+			if depth >= maxIncludeDepth {
+				return fmt.Errorf("%s:%d: includes nested too deeply", file, line)
+			}
+			outer := p.blocks[len(p.blocks)-1]
+			for _, pattern := range strings.Fields(value) {
+				matches, err := filepath.Glob(p.includePath(pattern))
+				if err != nil {
+					return fmt.Errorf("%s:%d: %w", file, line, err)
+				}
+				for _, m := range matches {
+					if err := p.parseFile(m, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+			// Host lines in an included file end at its end, as in ssh.
+			if p.blocks[len(p.blocks)-1] != outer {
+				p.blocks = append(p.blocks, &block{patterns: outer.patterns, match: outer.match, source: file})
+			}
+		default:
+			cur := p.blocks[len(p.blocks)-1]
+			cur.options = append(cur.options, [2]string{key, value})
+		}
+	}
+	return sc.Err()
+}
+
+// includePath resolves an Include argument: ~ is the home directory and
+// relative paths are relative to ~/.ssh.
+func (p *parser) includePath(pattern string) string {
+	if rest, ok := strings.CutPrefix(pattern, "~/"); ok {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, rest)
+	}
+	if filepath.IsAbs(pattern) {
+		return pattern
+	}
+	return filepath.Join(p.sshDir, pattern)
+}
+
+// resolve collects the settings for alias the way ssh does: every matching
+// block in order, the first value of a keyword winning. IdentityFile
+// accumulates.
+func (p *parser) resolve(alias, source string) models.SSHHost {
+	h := models.SSHHost{Alias: alias, Source: source}
+	for _, b := range p.blocks {
+		if b.match || (b.patterns != nil && !matches(b.patterns, alias)) {
+			continue
+		}
+		for _, opt := range b.options {
+			switch key, value := opt[0], opt[1]; key {
+			case "hostname":
+				if h.HostName == "" {
+					h.HostName = value
+				}
+			case "user":
+				if h.User == "" {
+					h.User = value
+				}
+			case "port":
+				if h.Port == 0 {
+					h.Port, _ = strconv.Atoi(value)
+				}
+			case "proxyjump":
+				if h.ProxyJump == "" {
+					h.ProxyJump = value
+				}
+			case "identityfile":
+				h.IdentityFile = append(h.IdentityFile, expandHome(value))
+			}
+		}
+	}
+	return h
+}
+
+// splitLine splits a config line into its lower-cased keyword and value,
+// accepting "Key value" and "Key=value" and dropping quotes.
+func splitLine(line string) (key, value string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return strings.ToLower(line), "", true
+	}
+	key = strings.ToLower(line[:i])
+	value = strings.TrimSpace(line[i:])
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	return key, value, true
+}
+
+// matches reports whether alias matches a Host pattern list: at least one
+// positive pattern and no negated one.
+func matches(patterns []string, alias string) bool {
+	found := false
+	for _, pat := range patterns {
+		if neg, ok := strings.CutPrefix(pat, "!"); ok {
+			if m, _ := path.Match(neg, alias); m {
+				return false
+			}
+			continue
+		}
+		if m, _ := path.Match(pat, alias); m {
+			found = true
+		}
+	}
+	return found
+}
+
+func isWildcard(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?!")
+}
+
+func expandHome(p string) string {
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return p
+}
@@ -0,0 +1,170 @@
+package sshconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"claude-relay/internal/models"
+)
+
+// writeFiles creates the named files under dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	sshDir := filepath.Join(home, ".ssh")
+	config := filepath.Join(sshDir, "config")
+	writeFiles(t, sshDir, map[string]string{
+		"config": `# Settings before the first Host apply to every host.
+IdentityFile ~/.ssh/id_global
+
+Host dev dev-alt
+    HostName dev.example.com
+    User alice
+    Port 2222
+    IdentityFile ~/.ssh/id_dev
+
+Include conf.d/*.conf
+
+Host "quoted"
+    HostName=quoted.example.com
+
+Host prod
+    HostName 10.0.0.5
+    Include per-host
+
+Host dev
+    # A later block cannot override earlier values.
+    User bob
+    ProxyJump never
+
+Match host dev
+    User carol
+
+Host *.internal !secret.internal
+    ProxyJump bastion
+    User ops
+
+Host secret.internal web.internal
+    HostName secret
+
+Host *
+    User default
+    Port 22
+`,
+		"conf.d/10-build.conf": `Host build
+    HostName build.example.com
+`,
+		"conf.d/20-dev.conf": `Host dev
+    ProxyJump jump
+`,
+		"conf.d/ignored.txt": "Host ignored\n",
+		// Lines before the first Host in an included file stay under the
+		// Host block that included it.
+		"per-host": `User deploy
+
+Host included-alias
+    Port 2200
+`,
+	})
+
+	hosts, err := Parse(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	global, devKey := filepath.Join(sshDir, "id_global"), filepath.Join(sshDir, "id_dev")
+	want := []models.SSHHost{
+		{Alias: "dev", HostName: "dev.example.com", User: "alice", Port: 2222, ProxyJump: "jump", IdentityFile: []string{global, devKey}, Source: config},
+		{Alias: "dev-alt", HostName: "dev.example.com", User: "alice", Port: 2222, IdentityFile: []string{global, devKey}, Source: config},
+		{Alias: "build", HostName: "build.example.com", User: "default", Port: 22, IdentityFile: []string{global}, Source: filepath.Join(sshDir, "conf.d/10-build.conf")},
+		{Alias: "quoted", HostName: "quoted.example.com", User: "default", Port: 22, IdentityFile: []string{global}, Source: config},
+		{Alias: "prod", HostName: "10.0.0.5", User: "deploy", Port: 22, IdentityFile: []string{global}, Source: config},
+		{Alias: "included-alias", User: "default", Port: 2200, IdentityFile: []string{global}, Source: filepath.Join(sshDir, "per-host")},
+		// The negated pattern keeps secret.internal out of the wildcard block.
+		{Alias: "secret.internal", HostName: "secret", User: "default", Port: 22, IdentityFile: []string{global}, Source: config},
+		{Alias: "web.internal", HostName: "secret", User: "ops", Port: 22, ProxyJump: "bastion", IdentityFile: []string{global}, Source: config},
+	}
+	if len(hosts) != len(want) {
+		t.Fatalf("got %d hosts, want %d: %+v", len(hosts), len(want), hosts)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(hosts[i], want[i]) {
+			t.Errorf("host %d = %+v\nwant %+v", i, hosts[i], want[i])
+		}
+	}
+}
+
+func TestParseMissingFile(t *testing.T) {
+	hosts, err := Parse(filepath.Join(t.TempDir(), "config"))
+	if err != nil || len(hosts) != 0 {
+		t.Errorf("missing file: got %v, %v", hosts, err)
+	}
+}
+
+func TestParseIncludeLoop(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"config": "Include config\n"})
+	if _, err := Parse(filepath.Join(dir, "config")); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("include loop: got %v", err)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		alias    string
+		want     bool
+	}{
+		{[]string{"dev"}, "dev", true},
+		{[]string{"dev"}, "dev2", false},
+		{[]string{"dev?"}, "dev2", true},
+		{[]string{"*"}, "anything", true},
+		{[]string{"*", "!prod"}, "prod", false},
+		{[]string{"!prod", "*"}, "prod", false},
+		// A negation alone matches nothing.
+		{[]string{"!prod"}, "dev", false},
+		{[]string{"*.corp", "!db.*"}, "db.corp", false},
+		{[]string{"*.corp", "!db.*"}, "web.corp", true},
+	}
+	for _, tt := range tests {
+		if got := matches(tt.patterns, tt.alias); got != tt.want {
+			t.Errorf("matches(%q, %q) = %v, want %v", tt.patterns, tt.alias, got, tt.want)
+		}
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	tests := []struct {
+		line, key, value string
+		ok               bool
+	}{
+		{"HostName example.com", "hostname", "example.com", true},
+		{"  User=alice", "user", "alice", true},
+		{"Port = 22", "port", "22", true},
+		{"\tIdentityFile \"~/.ssh/my key\"", "identityfile", "~/.ssh/my key", true},
+		{"# comment", "", "", false},
+		{"   ", "", "", false},
+		{"Compression", "compression", "", true},
+	}
+	for _, tt := range tests {
+		key, value, ok := splitLine(tt.line)
+		if key != tt.key || value != tt.value || ok != tt.ok {
+			t.Errorf("splitLine(%q) = %q, %q, %v", tt.line, key, value, ok)
+		}
+	}
+}
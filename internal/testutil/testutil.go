@@ -0,0 +1,58 @@
+// Package testutil holds fixtures shared by the tests of other packages:
+// fake CLIs (ssh, gh, docker, kubectl) put on PATH in place of the real ones.
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// FakeCommand puts an executable named name on PATH for the rest of the
+// test and returns the directory holding it. The executable is a sh script
+// that first appends its arguments to dir/calls, one line per call with
+// newlines turned into spaces, and then runs script with $dir set to that
+// directory, so script can read files the test writes there.
+func FakeCommand(t testing.TB, name, script string) string {
+	t.Helper()
+	dir := t.TempDir()
+	stub := "#!/bin/sh\ndir='" + dir + "'\n" +
+		`printf '%s\n' "$*" | tr '\n' ' ' >> "$dir/calls"; echo >> "$dir/calls"` + "\n" +
+		script
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(stub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+// Calls returns the arguments of every call to the fake commands in dir,
+// oldest first, or nil when there was none.
+func Calls(t testing.TB, dir string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "calls"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, " ")
+	}
+	return lines
+}
+
+// WriteFile writes content to path, creating its directory, and fails the
+// test on error.
+func WriteFile(t testing.TB, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
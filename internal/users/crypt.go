@@ -0,0 +1,258 @@
+package users
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// Password hashes are stored in crypt(3) formats so they can come from
+// standard tools: SHA-crypt ($5$, $6$; openssl passwd -6, mkpasswd) and the
+// htpasswd formats apr1 ($apr1$, the htpasswd default) and {SHA}. bcrypt
+// would need a dependency outside the standard library and is rejected.
+
+// hashRounds is the SHA-512-crypt cost used for new local passwords.
+const hashRounds = 100000
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// HashPassword returns a SHA-512-crypt hash of password with a random salt.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	for i, b := range salt {
+		salt[i] = cryptAlphabet[b&0x3f]
+	}
+	return shaCrypt(sha512.New, "$6$", []byte(password), salt, hashRounds, true), nil
+}
+
+// checkPassword reports whether password matches hash.
+func checkPassword(hash, password string) (bool, error) {
+	var computed string
+	switch {
+	case strings.HasPrefix(hash, "$6$"):
+		salt, rounds, custom, err := parseSHACrypt(hash[3:])
+		if err != nil {
+			return false, err
+		}
+		computed = shaCrypt(sha512.New, "$6$", []byte(password), salt, rounds, custom)
+	case strings.HasPrefix(hash, "$5$"):
+		salt, rounds, custom, err := parseSHACrypt(hash[3:])
+		if err != nil {
+			return false, err
+		}
+		computed = shaCrypt(sha256.New, "$5$", []byte(password), salt, rounds, custom)
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt, _, _ := strings.Cut(hash[6:], "$")
+		computed = apr1([]byte(password), []byte(salt))
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		computed = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hash, "$2"):
+		return false, fmt.Errorf("bcrypt hashes are not supported; use htpasswd -m or openssl passwd -6")
+	default:
+		return false, fmt.Errorf("unrecognized password hash format")
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1, nil
+}
+
+// parseSHACrypt splits "[rounds=N$]salt$hash".
+func parseSHACrypt(rest string) (salt []byte, rounds int, custom bool, err error) {
+	rounds = 5000
+	if r, ok := strings.CutPrefix(rest, "rounds="); ok {
+		n, tail, found := strings.Cut(r, "$")
+		if !found {
+			return nil, 0, false, fmt.Errorf("malformed SHA-crypt hash")
+		}
+		if rounds, err = strconv.Atoi(n); err != nil {
+			return nil, 0, false, fmt.Errorf("malformed SHA-crypt rounds: %w", err)
+		}
+		rounds = min(max(rounds, 1000), 999999999)
+		custom, rest = true, tail
+	}
+	s, _, found := strings.Cut(rest, "$")
+	if !found {
+		return nil, 0, false, fmt.Errorf("malformed SHA-crypt hash")
+	}
+	if len(s) > 16 {
+		s = s[:16]
+	}
+	return []byte(s), rounds, custom, nil
+}
+
+// shaCrypt implements Ulrich Drepper's SHA-crypt for SHA-256 and SHA-512.
+func shaCrypt(newHash func() hash.Hash, magic string, password, salt []byte, rounds int, custom bool) string {
+	sum := func(parts ...[]byte) []byte {
+		h := newHash()
+		for _, p := range parts {
+			h.Write(p)
+		}
+		return h.Sum(nil)
+	}
+	size := newHash().Size()
+	repeat := func(b []byte, n int) []byte {
+		out := make([]byte, 0, n)
+		for len(out) < n {
+			out = append(out, b[:min(len(b), n-len(out))]...)
+		}
+		return out
+	}
+
+	b := sum(password, salt, password)
+	h := newHash()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(repeat(b, len(password)))
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(password)
+		}
+	}
+	a := h.Sum(nil)
+
+	dp := newHash()
+	for range password {
+		dp.Write(password)
+	}
+	p := repeat(dp.Sum(nil), len(password))
+
+	ds := newHash()
+	for i := 0; i < 16+int(a[0]); i++ {
+		ds.Write(salt)
+	}
+	s := repeat(ds.Sum(nil), len(salt))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h := newHash()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(magic)
+	if custom {
+		fmt.Fprintf(&out, "rounds=%d$", rounds)
+	}
+	out.Write(salt)
+	out.WriteByte('$')
+	if size == sha512.Size {
+		for k := 0; k < 21; k++ {
+			i, j, l := k, k+21, k+42
+			switch k % 3 {
+			case 1:
+				i, j, l = j, l, i
+			case 2:
+				i, j, l = l, i, j
+			}
+			encode24(&out, c[i], c[j], c[l], 4)
+		}
+		encode24(&out, 0, 0, c[63], 2)
+	} else {
+		for k := 0; k < 10; k++ {
+			i, j, l := k, k+10, k+20
+			switch k % 3 {
+			case 1:
+				i, j, l = l, i, j
+			case 2:
+				i, j, l = j, l, i
+			}
+			encode24(&out, c[i], c[j], c[l], 4)
+		}
+		encode24(&out, 0, c[31], c[30], 3)
+	}
+	return out.String()
+}
+
+// apr1 implements Apache's MD5-crypt variant.
+func apr1(password, salt []byte) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	const magic = "$apr1$"
+	alt := md5.Sum(append(append(append([]byte{}, password...), salt...), password...))
+
+	h := md5.New()
+	h.Write(password)
+	h.Write([]byte(magic))
+	h.Write(salt)
+	for n := len(password); n > 0; n -= 16 {
+		h.Write(alt[:min(n, 16)])
+	}
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(password[:1])
+		}
+	}
+	final := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write(password)
+		} else {
+			h.Write(final)
+		}
+		if i%3 != 0 {
+			h.Write(salt)
+		}
+		if i%7 != 0 {
+			h.Write(password)
+		}
+		if i&1 != 0 {
+			h.Write(final)
+		} else {
+			h.Write(password)
+		}
+		final = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(magic)
+	out.Write(salt)
+	out.WriteByte('$')
+	for _, t := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode24(&out, final[t[0]], final[t[1]], final[t[2]], 4)
+	}
+	encode24(&out, 0, 0, final[11], 2)
+	return out.String()
+}
+
+// encode24 writes n crypt-base64 characters of the 24-bit group b2 b1 b0,
+// least significant bits first.
+func encode24(out *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		out.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
@@ -0,0 +1,69 @@
+package users
+
+import (
+	"strings"
+	"testing"
+)
+
+// Known answers from the SHA-crypt specification, openssl passwd -5/-6 and
+// openssl passwd -apr1 (the htpasswd -m format).
+var cryptVectors = []struct {
+	password, hash string
+}{
+	// Default rounds, no rounds= field.
+	{"Hello world!", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+	{"Hello world!", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+	// Explicit rounds; salts longer than 16 bytes are truncated.
+	{"Hello world!", "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+	{"This is just a test", "$6$rounds=5000$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0"},
+	{"Hello world!", "$6$rounds=1000$abc$Z1qGqKjJ955Q3hxAKRENp11lM160CDktIAQXrnkWjszWG6/BYyr9DR5eFLvBn4Tv/XyP46lXwBA6X4flRX5/B0"},
+	{"correct horse", "$6$rounds=1000$abc$3EgFb4AlyVHHbB8rZGsx.UO9EloeRJkmmCazg5i8FYgCDMmLggphTmz.lZDeWybZ2DY3jFUtB1pLy87i2YoZr."},
+	{"Hello world!", "$5$rounds=1000$abcdefghijklmnop$2YkAk4CSgMlRdux50wNUEDGZcvebT7CsSvJODseOuj6"},
+	{"correct horse", "$5$rounds=1000$abcdefghijklmnop$sMH/aXVPoABuMIam8Brfej2trWzGTpr5d2WuYZNwEmA"},
+	{"relay", "$apr1$r31.....$R.XGyWmGAmIyYfZq6krvc1"},
+	{"Hello world!", "$apr1$xyz$5xZrtnJ2AEufNQuzK6zuk."},
+	{"correct horse", "$apr1$xyz$d4P0ibuCEA5lkDozDZz320"},
+	{"", "$apr1$xyz$Pix4eE3fQHxJjb6LqtyMK1"},
+	{"relay", "{SHA}vSiyu5YH0KpqlIy2Ly8I5MyJPas="},
+}
+
+func TestCheckPasswordKnownAnswers(t *testing.T) {
+	for _, v := range cryptVectors {
+		if ok, err := checkPassword(v.hash, v.password); err != nil || !ok {
+			t.Errorf("checkPassword(%q, %q) = %v, %v; want true", v.hash, v.password, ok, err)
+		}
+		if ok, err := checkPassword(v.hash, v.password+"x"); err != nil || ok {
+			t.Errorf("checkPassword(%q) accepts a wrong password: %v, %v", v.hash, ok, err)
+		}
+	}
+}
+
+func TestCheckPasswordErrors(t *testing.T) {
+	tests := []struct{ hash, want string }{
+		{"$2y$10$abcdefghijklmnopqrstuv", "bcrypt"},
+		{"plaintext", "unrecognized"},
+		{"$6$rounds=abc$salt$x", "rounds"},
+	}
+	for _, tt := range tests {
+		_, err := checkPassword(tt.hash, "pw")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("checkPassword(%q): got %v, want an error containing %q", tt.hash, err, tt.want)
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$6$rounds=100000$") {
+		t.Errorf("HashPassword = %q", hash)
+	}
+	if ok, _ := checkPassword(hash, "s3cret"); !ok {
+		t.Error("hash does not verify")
+	}
+	if again, _ := HashPassword("s3cret"); again == hash {
+		t.Error("salts are not random")
+	}
+}
@@ -0,0 +1,300 @@
+// Package users manages the accounts of a multi-user claude-relay server:
+// loading the users file and an optional htpasswd file, checking passwords
+// and answering role and target access questions.
+package users
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"claude-relay/internal/models"
+)
+
+// verifiedTTL is how long a successful password check is remembered, so
+// that SHA-crypt's deliberate cost is not paid on every API request.
+const verifiedTTL = 5 * time.Minute
+
+// hashChecks bounds the password hashes computed at once, so a flood of
+// wrong passwords costs a few cores rather than all of them. Remembered
+// logins do not wait for it.
+var hashChecks = make(chan struct{}, max(runtime.NumCPU()/2, 1))
+
+var roleRank = map[models.Role]int{
+	models.RoleViewer:   1,
+	models.RoleOperator: 2,
+	models.RoleAdmin:    3,
+}
+
+// Allows reports whether role grants at least need.
+func Allows(role, need models.Role) bool {
+	return roleRank[role] >= roleRank[need]
+}
+
+// CanAccess reports whether u may see and act on the named target.
+func CanAccess(u *models.User, target string) bool {
+	if u.Role == models.RoleAdmin || len(u.Targets) == 0 {
+		return true
+	}
+	for _, pattern := range u.Targets {
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Store holds the accounts of a users file. Both the users file and the
+// htpasswd file are re-read when they change on disk, so accounts can be
+// edited with "claude-relay user" while the server runs.
+type Store struct {
+	path string
+
+	mu       sync.Mutex
+	file     *models.UsersFile
+	htpasswd map[string]string
+	modTimes [2]time.Time
+	verified map[[32]byte]time.Time
+}
+
+// Load opens the users file at path.
+func Load(path string) (*Store, error) {
+	s := &Store{path: path, verified: make(map[[32]byte]time.Time)}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Authenticate checks name and password and returns the account. The hash
+// is computed without holding the store lock, so slow checks of wrong
+// passwords never delay requests from logins that are remembered.
+func (s *Store) Authenticate(name, password string) (*models.User, bool) {
+	s.mu.Lock()
+	// A file that fails to parse keeps the last good accounts in effect.
+	s.reload()
+	u, hash := s.lookup(name)
+	key := sha256.Sum256([]byte(name + "\x00" + hash + "\x00" + password))
+	exp, remembered := s.verified[key]
+	s.mu.Unlock()
+
+	if u == nil || hash == "" {
+		return nil, false
+	}
+	if remembered && time.Now().Before(exp) {
+		return u, true
+	}
+	hashChecks <- struct{}{}
+	ok, err := checkPassword(hash, password)
+	<-hashChecks
+	if err != nil || !ok {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, exp := range s.verified {
+		if now.After(exp) {
+			delete(s.verified, k)
+		}
+	}
+	s.verified[key] = now.Add(verifiedTTL)
+	return u, true
+}
+
+// lookup returns the account and password hash for name. Users without a
+// hash of their own fall back to the htpasswd file.
+func (s *Store) lookup(name string) (*models.User, string) {
+	for i := range s.file.Users {
+		u := &s.file.Users[i]
+		if u.Name != name {
+			continue
+		}
+		if u.PasswordHash != "" {
+			return u, u.PasswordHash
+		}
+		return u, s.htpasswd[name]
+	}
+	if hash, ok := s.htpasswd[name]; ok && s.file.DefaultRole != "" {
+		return &models.User{Name: name, Role: s.file.DefaultRole}, hash
+	}
+	return nil, ""
+}
+
+// reload re-reads the users and htpasswd files if either changed.
+func (s *Store) reload() error {
+	st, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	mod := [2]time.Time{st.ModTime()}
+	if s.file != nil && s.file.Htpasswd != "" {
+		if st, err := os.Stat(s.htpasswdPath(s.file)); err == nil {
+			mod[1] = st.ModTime()
+		}
+	}
+	if s.file != nil && mod == s.modTimes {
+		return nil
+	}
+
+	f, err := ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var htpasswd map[string]string
+	if f.Htpasswd != "" {
+		p := s.htpasswdPath(f)
+		if htpasswd, err = readHtpasswd(p); err != nil {
+			return err
+		}
+		if st, err := os.Stat(p); err == nil {
+			mod[1] = st.ModTime()
+		}
+	}
+	s.file, s.htpasswd, s.modTimes = f, htpasswd, mod
+	clear(s.verified)
+	return nil
+}
+
+// RenameTarget rewrites the grants of exactly the old target name to new in
+// the users file, so that a renamed target stays with the accounts it was
+// granted to. Patterns with wildcards are left alone. It returns the
+// accounts that changed.
+func (s *Store) RenameTarget(old, new string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for i := range f.Users {
+		u := &f.Users[i]
+		if i := slices.Index(u.Targets, old); i >= 0 {
+			u.Targets[i] = new
+			changed = append(changed, u.Name)
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	if err := WriteFile(s.path, f); err != nil {
+		return nil, err
+	}
+	// Pick the change up even within the file system's time resolution.
+	s.modTimes = [2]time.Time{}
+	return changed, s.reload()
+}
+
+// htpasswdPath resolves UsersFile.Htpasswd relative to the users file.
+func (s *Store) htpasswdPath(f *models.UsersFile) string {
+	if filepath.IsAbs(f.Htpasswd) {
+		return f.Htpasswd
+	}
+	return filepath.Join(filepath.Dir(s.path), f.Htpasswd)
+}
+
+// ReadFile reads and validates a users file.
+func ReadFile(path string) (*models.UsersFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f models.UsersFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := Validate(&f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// WriteFile validates f and writes it to path with owner-only permissions.
+func WriteFile(path string, f *models.UsersFile) error {
+	if err := Validate(f); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// Validate checks roles, names and target patterns.
+func Validate(f *models.UsersFile) error {
+	if f.DefaultRole != "" && roleRank[f.DefaultRole] == 0 {
+		return fmt.Errorf("default_role %q: must be viewer, operator or admin", f.DefaultRole)
+	}
+	seen := make(map[string]bool)
+	for _, u := range f.Users {
+		if err := ValidateUser(u); err != nil {
+			return err
+		}
+		if seen[u.Name] {
+			return fmt.Errorf("user %q: duplicate name", u.Name)
+		}
+		seen[u.Name] = true
+		if u.PasswordHash == "" && f.Htpasswd == "" {
+			return fmt.Errorf("user %q: no password_hash and no htpasswd file", u.Name)
+		}
+	}
+	return nil
+}
+
+// ValidateUser checks the name, role and target patterns of one account.
+func ValidateUser(u models.User) error {
+	if u.Name == "" || strings.ContainsAny(u.Name, ": \t") {
+		return fmt.Errorf("user %q: name must be non-empty without spaces or colons", u.Name)
+	}
+	if roleRank[u.Role] == 0 {
+		return fmt.Errorf("user %q: role must be viewer, operator or admin", u.Name)
+	}
+	if u.Role == models.RoleAdmin && len(u.Targets) > 0 {
+		return fmt.Errorf("user %q: admins can access every target, remove targets", u.Name)
+	}
+	for _, p := range u.Targets {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("user %q: target pattern %q: %w", u.Name, p, err)
+		}
+	}
+	return nil
+}
+
+// readHtpasswd parses "name:hash" lines, skipping blanks and comments.
+func readHtpasswd(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make(map[string]string)
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s: malformed line for %q", path, name)
+		}
+		entries[name] = hash
+	}
+	return entries, sc.Err()
+}
@@ -0,0 +1,179 @@
+package users
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-relay/internal/models"
+)
+
+func writeUsers(t *testing.T, dir string, f *models.UsersFile, htpasswd string) string {
+	t.Helper()
+	path := filepath.Join(dir, "users.json")
+	if err := WriteFile(path, f); err != nil {
+		t.Fatal(err)
+	}
+	if htpasswd != "" {
+		if err := os.WriteFile(filepath.Join(dir, "htpasswd"), []byte(htpasswd), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestAuthenticate(t *testing.T) {
+	dir := t.TempDir()
+	path := writeUsers(t, dir, &models.UsersFile{
+		Htpasswd:    "htpasswd",
+		DefaultRole: models.RoleViewer,
+		Users: []models.User{
+			{Name: "alice", Role: models.RoleAdmin, PasswordHash: "$6$rounds=1000$abc$Z1qGqKjJ955Q3hxAKRENp11lM160CDktIAQXrnkWjszWG6/BYyr9DR5eFLvBn4Tv/XyP46lXwBA6X4flRX5/B0"},
+			{Name: "bob", Role: models.RoleOperator, Targets: []string{"dev-*"}},
+		},
+	}, "# htpasswd -m\nbob:$apr1$xyz$d4P0ibuCEA5lkDozDZz320\n\ncarol:{SHA}vSiyu5YH0KpqlIy2Ly8I5MyJPas=\nalice:$apr1$xyz$Pix4eE3fQHxJjb6LqtyMK1\n")
+
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, password string
+		role           models.Role
+	}{
+		{"alice", "Hello world!", models.RoleAdmin},
+		// The users file hash wins over the htpasswd entry.
+		{"alice", "", ""},
+		// Users without a hash fall back to htpasswd.
+		{"bob", "correct horse", models.RoleOperator},
+		{"bob", "wrong", ""},
+		// htpasswd-only users get the default role.
+		{"carol", "relay", models.RoleViewer},
+		{"dave", "relay", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		u, ok := s.Authenticate(tt.name, tt.password)
+		if ok != (tt.role != "") || (ok && u.Role != tt.role) {
+			t.Errorf("Authenticate(%q, %q) = %+v, %v; want role %q", tt.name, tt.password, u, ok, tt.role)
+		}
+	}
+
+	// Logins are remembered until the files change.
+	if len(s.verified) != 3 {
+		t.Errorf("%d remembered logins, want 3", len(s.verified))
+	}
+	writeUsers(t, dir, &models.UsersFile{
+		Htpasswd: "htpasswd",
+		Users:    []models.User{{Name: "bob", Role: models.RoleViewer}},
+	}, "")
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	if _, ok := s.Authenticate("carol", "relay"); ok {
+		t.Error("carol: default role removed, want no login")
+	}
+	if u, ok := s.Authenticate("bob", "correct horse"); !ok || u.Role != models.RoleViewer {
+		t.Errorf("bob after reload = %+v, %v", u, ok)
+	}
+	if len(s.verified) != 1 {
+		t.Errorf("%d remembered logins after reload, want 1", len(s.verified))
+	}
+}
+
+func TestAuthenticateForgetsExpiredLogins(t *testing.T) {
+	path := writeUsers(t, t.TempDir(), &models.UsersFile{Users: []models.User{
+		{Name: "alice", Role: models.RoleAdmin, PasswordHash: "$apr1$xyz$5xZrtnJ2AEufNQuzK6zuk."},
+	}}, "")
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.verified[[32]byte{1}] = time.Now().Add(-time.Second)
+	if _, ok := s.Authenticate("alice", "Hello world!"); !ok {
+		t.Fatal("alice: want a login")
+	}
+	if _, ok := s.verified[[32]byte{1}]; ok || len(s.verified) != 1 {
+		t.Errorf("expired logins are kept: %d entries", len(s.verified))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		f    models.UsersFile
+		want string
+	}{
+		{"bad default role", models.UsersFile{DefaultRole: "root"}, "default_role"},
+		{"bad name", models.UsersFile{Users: []models.User{{Name: "a:b", Role: models.RoleViewer, PasswordHash: "x"}}}, "name must be"},
+		{"bad role", models.UsersFile{Users: []models.User{{Name: "a", Role: "root", PasswordHash: "x"}}}, "role must be"},
+		{"admin with targets", models.UsersFile{Users: []models.User{{Name: "a", Role: models.RoleAdmin, PasswordHash: "x", Targets: []string{"dev"}}}}, "remove targets"},
+		{"bad pattern", models.UsersFile{Users: []models.User{{Name: "a", Role: models.RoleViewer, PasswordHash: "x", Targets: []string{"["}}}}, "target pattern"},
+		{"duplicate", models.UsersFile{Users: []models.User{{Name: "a", Role: models.RoleViewer, PasswordHash: "x"}, {Name: "a", Role: models.RoleViewer, PasswordHash: "x"}}}, "duplicate"},
+		{"no password", models.UsersFile{Users: []models.User{{Name: "a", Role: models.RoleViewer}}}, "no password_hash"},
+	}
+	for _, tt := range tests {
+		err := Validate(&tt.f)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCanAccess(t *testing.T) {
+	op := &models.User{Role: models.RoleOperator, Targets: []string{"dev-*", "staging"}}
+	for target, want := range map[string]bool{"dev-1": true, "staging": true, "prod": false, "dev": false} {
+		if got := CanAccess(op, target); got != want {
+			t.Errorf("CanAccess(%q) = %v, want %v", target, got, want)
+		}
+	}
+	if !CanAccess(&models.User{Role: models.RoleViewer}, "prod") {
+		t.Error("no target list: want access to every target")
+	}
+	if !Allows(models.RoleAdmin, models.RoleOperator) || Allows(models.RoleViewer, models.RoleOperator) {
+		t.Error("Allows: wrong role order")
+	}
+}
+
+func TestRenameTarget(t *testing.T) {
+	path := writeUsers(t, t.TempDir(), &models.UsersFile{Users: []models.User{
+		{Name: "alice", Role: models.RoleAdmin, PasswordHash: "$apr1$xyz$5xZrtnJ2AEufNQuzK6zuk."},
+		{Name: "bob", Role: models.RoleOperator, PasswordHash: "x", Targets: []string{"staging", "prod"}},
+		{Name: "carol", Role: models.RoleViewer, PasswordHash: "x", Targets: []string{"pro?"}},
+	}}, "")
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Authenticate("alice", "Hello world!"); !ok {
+		t.Fatal("alice: want a login")
+	}
+
+	changed, err := s.RenameTarget("prod", "prod-eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Patterns that happen to match the old name are not rewritten.
+	if strings.Join(changed, ",") != "bob" {
+		t.Errorf("changed %q, want bob", changed)
+	}
+	f, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(f.Users[1].Targets, ","); got != "staging,prod-eu" {
+		t.Errorf("bob's targets = %s", got)
+	}
+	if got := strings.Join(f.Users[2].Targets, ","); got != "pro?" {
+		t.Errorf("carol's targets = %s", got)
+	}
+	// The store serves the new file at once.
+	if u, _ := s.lookup("bob"); !CanAccess(u, "prod-eu") || CanAccess(u, "prod") {
+		t.Errorf("bob after rename: %+v", u)
+	}
+
+	if changed, err := s.RenameTarget("unknown", "other"); err != nil || changed != nil {
+		t.Errorf("no grants: got %q, %v", changed, err)
+	}
+}
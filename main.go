@@ -4,28 +4,126 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"os"
 	"os/exec"
 	"runtime"
+	"strings"
 
+	"claude-relay/internal/autodetect"
 	"claude-relay/internal/config"
 	"claude-relay/internal/server"
+	"claude-relay/internal/users"
 )
 
 func main() {
 	addr := flag.String("addr", "127.0.0.1:8787", "listen address")
 	noBrowser := flag.Bool("no-browser", false, "don't auto-open browser")
+	token := flag.String("token", os.Getenv("CLAUDE_RELAY_TOKEN"), "API session token (default: random per launch, or $CLAUDE_RELAY_TOKEN)")
+	allowHosts := flag.String("allow-host", "", "comma-separated extra Host names to accept, e.g. a DNS name for the machine")
+	usersFile := flag.String("users", "", "users file; enables multi-user mode with logins and roles (see \"claude-relay user\")")
+	tlsSelfSigned := flag.Bool("tls", false, "serve HTTPS with a self-signed certificate kept in ~/.claude-relay/tls")
+	tlsCert := flag.String("tls-cert", "", "serve HTTPS with this certificate (PEM)")
+	tlsKey := flag.String("tls-key", "", "private key for -tls-cert (PEM)")
 	flag.Parse()
 
 	config.Init()
 
-	srv := server.New(*addr)
+	switch flag.Arg(0) {
+	case "models":
+		if err := runModelsCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "account":
+		if err := runAccountCommand(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "user":
+		if err := runUserCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var opts server.Options
+	if *allowHosts != "" {
+		opts.AllowedHosts = strings.Split(*allowHosts, ",")
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be given together")
+	}
+	useTLS := *tlsSelfSigned || *tlsCert != ""
+
+	generated := false
+	switch {
+	case *usersFile != "":
+		store, err := users.Load(*usersFile)
+		if err != nil {
+			log.Fatalf("load users: %v", err)
+		}
+		if !useTLS && !server.IsLoopback(*addr) {
+			log.Fatalf("refusing to accept passwords over plain HTTP on %s; add -tls or -tls-cert/-tls-key", *addr)
+		}
+		opts.Users = store
+	case *token == "" && !server.IsLoopback(*addr):
+		// A generated token is only known to whoever sees the startup URL,
+		// which is enough on loopback. Elsewhere auth must be chosen on purpose.
+		log.Fatalf("refusing to listen on non-loopback address %s without -token, CLAUDE_RELAY_TOKEN or -users", *addr)
+	case *token == "":
+		generated = true
+		*token = server.NewToken()
+	}
+	opts.Token = *token
+
+	certFile, keyFile := *tlsCert, *tlsKey
+	if *tlsSelfSigned && certFile == "" {
+		var err error
+		certFile, keyFile, err = server.SelfSignedCert(config.TLSDir(), server.CertHosts(*addr, opts.AllowedHosts))
+		if err != nil {
+			log.Fatalf("self-signed certificate: %v", err)
+		}
+	}
+	if useTLS {
+		fp, err := server.CertFingerprint(certFile, keyFile)
+		if err != nil {
+			log.Fatalf("load TLS certificate: %v", err)
+		}
+		fmt.Printf("TLS certificate SHA-256 fingerprint: %s\n", fp)
+	}
+
+	autodetect.StartScheduler()
 
-	url := "http://" + *addr
+	srv := server.New(*addr, opts)
+
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	url := scheme + "://" + *addr
+	if host, port, _ := net.SplitHostPort(*addr); host == "" || net.ParseIP(host).IsUnspecified() {
+		url = scheme + "://127.0.0.1:" + port
+	}
+	loginURL := url
+	if opts.Users == nil {
+		loginURL += "/?token=" + *token
+	}
 	if !*noBrowser {
-		openBrowser(url)
+		openBrowser(loginURL)
 	}
 
-	fmt.Printf("claude-relay running at %s\n", url)
+	switch {
+	case opts.Users != nil:
+		fmt.Printf("claude-relay running at %s (multi-user, log in with your account)\n", url)
+	case generated:
+		fmt.Printf("claude-relay running at %s\n", loginURL)
+	default:
+		fmt.Printf("claude-relay running at %s (open with ?token=<your token>)\n", url)
+	}
+	if useTLS {
+		log.Fatal(srv.ListenAndServeTLS(certFile, keyFile))
+	}
 	log.Fatal(srv.ListenAndServe())
 }
 
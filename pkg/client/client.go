@@ -0,0 +1,459 @@
+// Package client calls the claude-relay web API from Go programs. It
+// covers the endpoints listed in /api/openapi.json and returns API errors
+// as *Error with the server's machine-readable code.
+//
+//	c := client.New("http://127.0.0.1:8787", os.Getenv("CLAUDE_RELAY_TOKEN"))
+//	job, err := c.Deploy(ctx, "devbox", false)
+//	if client.IsCode(err, client.CodePreflightFailed) { ... }
+//	job, err = c.WaitJob(ctx, job.ID, time.Second)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"claude-relay/internal/models"
+)
+
+// Types of the API, re-exported for callers outside this module.
+type (
+	APIResponse            = models.APIResponse
+	ErrorCode              = models.ErrorCode
+	Config                 = models.Config
+	Permissions            = models.Permissions
+	HookMatcher            = models.HookMatcher
+	Target                 = models.Target
+	TargetFacts            = models.TargetFacts
+	ToolInfo               = models.ToolInfo
+	Container              = models.Container
+	ContainerImportRequest = models.ContainerImportRequest
+	Codespace              = models.Codespace
+	CodespaceImportRequest = models.CodespaceImportRequest
+	CodespaceDeployRequest = models.CodespaceDeployRequest
+	CodespaceDeployResult  = models.CodespaceDeployResult
+	DeployRequest          = models.DeployRequest
+	SSHHost                = models.SSHHost
+	TargetImportResult     = models.TargetImportResult
+	Workspace              = models.Workspace
+	Session                = models.Session
+	DetectModelsResponse   = models.DetectModelsResponse
+	CatalogChange          = models.CatalogChange
+	AccountResponse        = models.AccountResponse
+	AutoDetectReport       = models.AutoDetectReport
+	DeployPreview          = models.DeployPreview
+	PreflightReport        = models.PreflightReport
+	DeployStatus           = models.DeployStatus
+	AssetSyncResult        = models.AssetSyncResult
+	Job                    = models.Job
+	JobState               = models.JobState
+	LockHolder             = models.LockHolder
+)
+
+// Job states.
+const (
+	JobRunning   = models.JobRunning
+	JobSucceeded = models.JobSucceeded
+	JobFailed    = models.JobFailed
+	JobCanceled  = models.JobCanceled
+)
+
+// Error codes returned in Error.Code.
+const (
+	CodeInvalidRequest     = models.CodeInvalidRequest
+	CodeValidationFailed   = models.CodeValidationFailed
+	CodeNotConfigured      = models.CodeNotConfigured
+	CodeAssetSyncDisabled  = models.CodeAssetSyncDisabled
+	CodeTargetNotFound     = models.CodeTargetNotFound
+	CodeWorkspaceNotFound  = models.CodeWorkspaceNotFound
+	CodeAlreadyExists      = models.CodeAlreadyExists
+	CodeTargetInUse        = models.CodeTargetInUse
+	CodeCatalogCheckFailed = models.CodeCatalogCheckFailed
+	CodePreflightFailed    = models.CodePreflightFailed
+	CodeAccountUnsupported = models.CodeAccountUnsupported
+	CodeRelayError         = models.CodeRelayError
+	CodeTargetError        = models.CodeTargetError
+	CodeInternal           = models.CodeInternal
+	CodeUnauthorized       = models.CodeUnauthorized
+	CodeForbidden          = models.CodeForbidden
+	CodeCrossOrigin        = models.CodeCrossOrigin
+	CodeInvalidHost        = models.CodeInvalidHost
+	CodeJobNotFound        = models.CodeJobNotFound
+	CodeJobFinished        = models.CodeJobFinished
+	CodeTargetLocked       = models.CodeTargetLocked
+	CodeCodespaceStopped   = models.CodeCodespaceStopped
+	CodeGitHubError        = models.CodeGitHubError
+	CodeDockerError        = models.CodeDockerError
+)
+
+// Client is a claude-relay API client. Set Token for a single-user server
+// or Username and Password for a multi-user one.
+type Client struct {
+	BaseURL    string
+	Token      string
+	Username   string
+	Password   string
+	HTTPClient *http.Client // http.DefaultClient if nil
+}
+
+// New returns a client for the server at baseURL using a session token.
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Token: token}
+}
+
+// Error is a non-2xx API response. Report and Preflight explain a refused
+// deploy; Lock names who holds a locked target.
+type Error struct {
+	StatusCode int
+	Code       ErrorCode
+	Message    string
+	Report     *AutoDetectReport
+	Preflight  *PreflightReport
+	Lock       *LockHolder
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("claude-relay: HTTP %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("claude-relay: %s: %s", e.Code, e.Message)
+}
+
+// IsCode reports whether err is an API error with the given code.
+func IsCode(err error, code ErrorCode) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// do sends a request with in as the JSON body (if not nil) and decodes the
+// response into out (if not nil).
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	u := strings.TrimRight(c.BaseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	case c.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		var r APIResponse
+		if json.Unmarshal(data, &r) != nil || r.Message == "" {
+			r.Message = strings.TrimSpace(string(data))
+		}
+		return &Error{StatusCode: resp.StatusCode, Code: r.Code, Message: r.Message, Report: r.Report, Preflight: r.Preflight, Lock: r.Lock}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// OpenAPI returns the server's OpenAPI document.
+func (c *Client) OpenAPI(ctx context.Context) (json.RawMessage, error) {
+	var doc json.RawMessage
+	err := c.do(ctx, "GET", "/api/openapi.json", nil, nil, &doc)
+	return doc, err
+}
+
+// Me returns the account the client is logged in as.
+func (c *Client) Me(ctx context.Context) (*Session, error) {
+	var s Session
+	return &s, c.do(ctx, "GET", "/api/me", nil, nil, &s)
+}
+
+// Config returns the relay config with the API key masked.
+func (c *Client) Config(ctx context.Context) (*Config, error) {
+	var cfg Config
+	return &cfg, c.do(ctx, "GET", "/api/config", nil, nil, &cfg)
+}
+
+// SetConfig replaces the relay config. A masked API key, as returned by
+// Config, keeps the stored key.
+func (c *Client) SetConfig(ctx context.Context, cfg *Config) error {
+	return c.do(ctx, "PUT", "/api/config", nil, cfg, nil)
+}
+
+// Permissions returns the managed permissions block.
+func (c *Client) Permissions(ctx context.Context) (*Permissions, error) {
+	var p Permissions
+	return &p, c.do(ctx, "GET", "/api/permissions", nil, nil, &p)
+}
+
+// SetPermissions replaces the managed permissions block.
+func (c *Client) SetPermissions(ctx context.Context, p *Permissions) error {
+	return c.do(ctx, "PUT", "/api/permissions", nil, p, nil)
+}
+
+// Hooks returns the managed hooks by event.
+func (c *Client) Hooks(ctx context.Context) (map[string][]HookMatcher, error) {
+	var hooks map[string][]HookMatcher
+	err := c.do(ctx, "GET", "/api/hooks", nil, nil, &hooks)
+	return hooks, err
+}
+
+// SetHooks replaces the managed hooks.
+func (c *Client) SetHooks(ctx context.Context, hooks map[string][]HookMatcher) error {
+	return c.do(ctx, "PUT", "/api/hooks", nil, hooks, nil)
+}
+
+// DetectModels returns the relay catalog with suggested mappings; refresh
+// bypasses the catalog cache.
+func (c *Client) DetectModels(ctx context.Context, refresh bool) (*DetectModelsResponse, error) {
+	var q url.Values
+	if refresh {
+		q = url.Values{"refresh": {"true"}}
+	}
+	var res DetectModelsResponse
+	return &res, c.do(ctx, "GET", "/api/models/detect", q, nil, &res)
+}
+
+// ModelHistory returns the catalog changes seen so far.
+func (c *Client) ModelHistory(ctx context.Context) ([]CatalogChange, error) {
+	var changes []CatalogChange
+	err := c.do(ctx, "GET", "/api/models/history", nil, nil, &changes)
+	return changes, err
+}
+
+// RelayAccount returns the balance of the API key.
+func (c *Client) RelayAccount(ctx context.Context) (*AccountResponse, error) {
+	var res AccountResponse
+	return &res, c.do(ctx, "GET", "/api/relay/account", nil, nil, &res)
+}
+
+// AutoDetect returns the latest catalog check, nil if none ran yet.
+func (c *Client) AutoDetect(ctx context.Context) (*AutoDetectReport, error) {
+	var res models.AutoDetectResponse
+	err := c.do(ctx, "GET", "/api/autodetect", nil, nil, &res)
+	return res.Report, err
+}
+
+// RunAutoDetect checks the configured models against the catalog now.
+func (c *Client) RunAutoDetect(ctx context.Context) (*AutoDetectReport, error) {
+	var res models.AutoDetectResponse
+	err := c.do(ctx, "POST", "/api/autodetect/run", nil, nil, &res)
+	return res.Report, err
+}
+
+// Deploy starts deploying the config to a target and returns the job.
+// Unless force is set, a failed catalog check or preflight returns an
+// *Error with its report instead.
+func (c *Client) Deploy(ctx context.Context, target string, force bool) (*Job, error) {
+	return c.DeployWith(ctx, DeployRequest{TargetName: target, Force: force})
+}
+
+// DeployWith is Deploy with all request options, such as starting a stopped
+// codespace first. Without Start, a stopped codespace returns an *Error
+// with CodeCodespaceStopped.
+func (c *Client) DeployWith(ctx context.Context, req DeployRequest) (*Job, error) {
+	var job Job
+	return &job, c.do(ctx, "POST", "/api/deploy", nil, req, &job)
+}
+
+// DeployCodespaces starts a deploy to every codespace of repo (owner/name).
+func (c *Client) DeployCodespaces(ctx context.Context, req CodespaceDeployRequest) (*CodespaceDeployResult, error) {
+	var res CodespaceDeployResult
+	return &res, c.do(ctx, "POST", "/api/deploy/codespaces", nil, req, &res)
+}
+
+// Preview returns what a deploy to target would write.
+func (c *Client) Preview(ctx context.Context, target string) (*DeployPreview, error) {
+	var res DeployPreview
+	return &res, c.do(ctx, "POST", "/api/deploy/preview", nil, models.TargetRequest{TargetName: target}, &res)
+}
+
+// Preflight checks a deploy to target without running it.
+func (c *Client) Preflight(ctx context.Context, target string) (*PreflightReport, error) {
+	var res PreflightReport
+	return &res, c.do(ctx, "POST", "/api/deploy/preflight", nil, models.TargetRequest{TargetName: target}, &res)
+}
+
+// Status returns the deploy state of target.
+func (c *Client) Status(ctx context.Context, target string) (*DeployStatus, error) {
+	var res DeployStatus
+	return &res, c.do(ctx, "POST", "/api/deploy/status", nil, models.TargetRequest{TargetName: target}, &res)
+}
+
+// Restore puts back the settings target had before the first deploy.
+func (c *Client) Restore(ctx context.Context, target string) error {
+	return c.do(ctx, "POST", "/api/deploy/restore", nil, models.TargetRequest{TargetName: target}, nil)
+}
+
+// SyncAssets pushes the shared assets to target.
+func (c *Client) SyncAssets(ctx context.Context, target string) (*AssetSyncResult, error) {
+	var res AssetSyncResult
+	return &res, c.do(ctx, "POST", "/api/assets/sync", nil, models.TargetRequest{TargetName: target}, &res)
+}
+
+// Targets lists the targets the account may access.
+func (c *Client) Targets(ctx context.Context) ([]Target, error) {
+	var targets []Target
+	err := c.do(ctx, "GET", "/api/targets", nil, nil, &targets)
+	return targets, err
+}
+
+// AddTarget adds a target.
+func (c *Client) AddTarget(ctx context.Context, t Target) error {
+	return c.do(ctx, "POST", "/api/targets", nil, t, nil)
+}
+
+// UpdateTarget replaces the target called name with t, renaming it if
+// t.Name differs.
+func (c *Client) UpdateTarget(ctx context.Context, name string, t Target) error {
+	return c.do(ctx, "PUT", "/api/targets/"+url.PathEscape(name), nil, t, nil)
+}
+
+// TestTarget tests the connection to a target and returns what it found.
+func (c *Client) TestTarget(ctx context.Context, name string) (*TargetFacts, error) {
+	var res TargetFacts
+	return &res, c.do(ctx, "POST", "/api/targets/"+url.PathEscape(name)+"/test", nil, nil, &res)
+}
+
+// DiscoverSSH lists the host aliases in the server's ~/.ssh/config.
+func (c *Client) DiscoverSSH(ctx context.Context) ([]SSHHost, error) {
+	var hosts []SSHHost
+	err := c.do(ctx, "GET", "/api/targets/discover/ssh", nil, nil, &hosts)
+	return hosts, err
+}
+
+// ImportSSH adds SSH targets for the given aliases, or for every alias in
+// the server's ~/.ssh/config if none are given.
+func (c *Client) ImportSSH(ctx context.Context, hosts ...string) (*TargetImportResult, error) {
+	var res TargetImportResult
+	return &res, c.do(ctx, "POST", "/api/targets/discover/ssh", nil, models.SSHImportRequest{Hosts: hosts}, &res)
+}
+
+// Devcontainers lists the devcontainers running on the server.
+func (c *Client) Devcontainers(ctx context.Context) ([]Container, error) {
+	var list []Container
+	err := c.do(ctx, "GET", "/api/targets/discover/docker", nil, nil, &list)
+	return list, err
+}
+
+// ImportDevcontainers adds docker targets for the devcontainers of the given
+// project folders, or for every devcontainer without a target.
+func (c *Client) ImportDevcontainers(ctx context.Context, folders ...string) (*TargetImportResult, error) {
+	var res TargetImportResult
+	return &res, c.do(ctx, "POST", "/api/targets/discover/docker", nil, models.ContainerImportRequest{LocalFolders: folders}, &res)
+}
+
+// Codespaces lists the codespaces gh sees on the server.
+func (c *Client) Codespaces(ctx context.Context) ([]Codespace, error) {
+	var list []Codespace
+	err := c.do(ctx, "GET", "/api/targets/discover/codespaces", nil, nil, &list)
+	return list, err
+}
+
+// ImportCodespaces adds targets for the named codespaces, for those of
+// repo, or for every codespace without a target.
+func (c *Client) ImportCodespaces(ctx context.Context, req CodespaceImportRequest) (*TargetImportResult, error) {
+	var res TargetImportResult
+	return &res, c.do(ctx, "POST", "/api/targets/discover/codespaces", nil, req, &res)
+}
+
+// DeleteTarget removes a target.
+func (c *Client) DeleteTarget(ctx context.Context, name string) error {
+	return c.do(ctx, "DELETE", "/api/targets/"+url.PathEscape(name), nil, nil, nil)
+}
+
+// Workspaces lists the workspaces on targets the account may access.
+func (c *Client) Workspaces(ctx context.Context) ([]Workspace, error) {
+	var workspaces []Workspace
+	err := c.do(ctx, "GET", "/api/workspaces", nil, nil, &workspaces)
+	return workspaces, err
+}
+
+// AddWorkspace adds a workspace.
+func (c *Client) AddWorkspace(ctx context.Context, ws Workspace) error {
+	return c.do(ctx, "POST", "/api/workspaces", nil, ws, nil)
+}
+
+// DeleteWorkspace removes a workspace.
+func (c *Client) DeleteWorkspace(ctx context.Context, name string) error {
+	return c.do(ctx, "DELETE", "/api/workspaces/"+url.PathEscape(name), nil, nil, nil)
+}
+
+// DeployWorkspace starts deploying project settings to a workspace and
+// returns the job.
+func (c *Client) DeployWorkspace(ctx context.Context, name string, force bool) (*Job, error) {
+	var q url.Values
+	if force {
+		q = url.Values{"force": {"true"}}
+	}
+	var job Job
+	return &job, c.do(ctx, "POST", "/api/workspaces/"+url.PathEscape(name)+"/deploy", q, nil, &job)
+}
+
+// Jobs lists recent jobs, newest first, without their logs.
+func (c *Client) Jobs(ctx context.Context) ([]Job, error) {
+	var list []Job
+	err := c.do(ctx, "GET", "/api/jobs", nil, nil, &list)
+	return list, err
+}
+
+// Job returns the state and log of a job.
+func (c *Client) Job(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	return &job, c.do(ctx, "GET", "/api/jobs/"+url.PathEscape(id), nil, nil, &job)
+}
+
+// CancelJob asks a running job to stop; poll it to see it end.
+func (c *Client) CancelJob(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	return &job, c.do(ctx, "DELETE", "/api/jobs/"+url.PathEscape(id), nil, nil, &job)
+}
+
+// WaitJob polls a job every interval until it is no longer running.
+func (c *Client) WaitJob(ctx context.Context, id string, interval time.Duration) (*Job, error) {
+	for {
+		job, err := c.Job(ctx, id)
+		if err != nil || job.State != JobRunning {
+			return job, err
+		}
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
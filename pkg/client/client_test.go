@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// request is what the test server saw.
+type request struct {
+	method, path, query, auth, contentType string
+	body                                   string
+}
+
+// fakeServer answers every request with status and body and records it.
+func fakeServer(t *testing.T, status int, body string) (*httptest.Server, *[]request) {
+	t.Helper()
+	var seen []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		seen = append(seen, request{
+			method: r.Method, path: r.URL.EscapedPath(), query: r.URL.RawQuery,
+			auth: r.Header.Get("Authorization"), contentType: r.Header.Get("Content-Type"),
+			body: string(data),
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &seen
+}
+
+func TestDeployRoundTrip(t *testing.T) {
+	srv, seen := fakeServer(t, 202, `{"id":"42","kind":"deploy","target":"dev box","state":"running","created_at":"2026-01-02T03:04:05Z"}`)
+	c := New(srv.URL+"/", "tok")
+
+	job, err := c.Deploy(context.Background(), "dev box", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != "42" || job.Target != "dev box" || job.State != JobRunning {
+		t.Errorf("job = %+v", job)
+	}
+	r := (*seen)[0]
+	if r.method != "POST" || r.path != "/api/deploy" || r.auth != "Bearer tok" || r.contentType != "application/json" {
+		t.Errorf("request = %+v", r)
+	}
+	var body DeployRequest
+	if err := json.Unmarshal([]byte(r.body), &body); err != nil || body.TargetName != "dev box" || !body.Force {
+		t.Errorf("body = %s", r.body)
+	}
+}
+
+func TestRequestShape(t *testing.T) {
+	srv, seen := fakeServer(t, 200, `{}`)
+	c := &Client{BaseURL: srv.URL, Username: "ada", Password: "pw"}
+	ctx := context.Background()
+
+	if _, err := c.DetectModels(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DeployWorkspace(ctx, "api/v2", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteTarget(ctx, "dev box"); err != nil {
+		t.Fatal(err)
+	}
+	want := []request{
+		{method: "GET", path: "/api/models/detect", query: "refresh=true"},
+		{method: "POST", path: "/api/workspaces/api%2Fv2/deploy"},
+		{method: "DELETE", path: "/api/targets/dev%20box"},
+	}
+	for i, w := range want {
+		r := (*seen)[i]
+		if r.method != w.method || r.path != w.path || r.query != w.query || r.body != "" || r.contentType != "" {
+			t.Errorf("request %d = %+v, want %s %s?%s", i, r, w.method, w.path, w.query)
+		}
+		// Basic credentials win over a token.
+		if r.auth != "Basic YWRhOnB3" {
+			t.Errorf("request %d: Authorization = %q", i, r.auth)
+		}
+	}
+}
+
+func TestErrorDecoding(t *testing.T) {
+	ctx := context.Background()
+
+	srv, _ := fakeServer(t, 409, `{"status":"error","code":"target_locked","message":"target dev is locked",`+
+		`"lock":{"id":"x1","op":"deploy","user":"otto","host":"laptop","pid":7,"since":"2026-01-02T03:04:05Z"}}`)
+	err := New(srv.URL, "tok").Restore(ctx, "dev")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %T %v", err, err)
+	}
+	if apiErr.StatusCode != 409 || apiErr.Code != CodeTargetLocked || apiErr.Message != "target dev is locked" ||
+		apiErr.Lock == nil || apiErr.Lock.User != "otto" || apiErr.Lock.PID != 7 {
+		t.Errorf("error = %+v", apiErr)
+	}
+	if !IsCode(err, CodeTargetLocked) || IsCode(err, CodeForbidden) {
+		t.Error("IsCode")
+	}
+	if err.Error() != "claude-relay: target_locked: target dev is locked" {
+		t.Errorf("Error() = %q", err)
+	}
+
+	srv, _ = fakeServer(t, 409, `{"status":"error","code":"preflight_failed","message":"preflight found 1 error",`+
+		`"preflight":{"target":"dev","ok":false}}`)
+	_, err = New(srv.URL, "tok").Deploy(ctx, "dev", false)
+	if !errors.As(err, &apiErr) || apiErr.Preflight == nil || apiErr.Preflight.Target != "dev" {
+		t.Errorf("preflight error = %+v", err)
+	}
+
+	// A proxy in front of the server may answer with plain text.
+	srv, _ = fakeServer(t, 502, "Bad Gateway\n")
+	_, err = New(srv.URL, "tok").Targets(ctx)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 502 || apiErr.Code != "" || apiErr.Message != "Bad Gateway" {
+		t.Errorf("plain-text error = %+v", err)
+	}
+	if err.Error() != "claude-relay: HTTP 502: Bad Gateway" {
+		t.Errorf("Error() = %q", err)
+	}
+
+	// A 2xx body that does not decode is an error too, not a zero value.
+	srv, _ = fakeServer(t, 200, `[`)
+	if _, err := New(srv.URL, "tok").Targets(ctx); err == nil || IsCode(err, "") {
+		t.Errorf("bad body: %v", err)
+	}
+}
+
+func TestWaitJob(t *testing.T) {
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		state := JobRunning
+		if polls == 3 {
+			state = JobSucceeded
+		}
+		json.NewEncoder(w).Encode(Job{ID: "7", State: state})
+	}))
+	defer srv.Close()
+
+	job, err := New(srv.URL, "tok").WaitJob(context.Background(), "7", time.Millisecond)
+	if err != nil || job.State != JobSucceeded || polls != 3 {
+		t.Errorf("got %+v, %v after %d polls", job, err, polls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	polls = -100 // never finishes
+	if _, err := New(srv.URL, "tok").WaitJob(ctx, "7", time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled wait: %v", err)
+	}
+}